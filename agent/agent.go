@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"runtime"
 	"sync"
 	"time"
 
@@ -30,11 +31,15 @@ type agent struct {
 	target *target
 
 	pipe         model.Pipe
+	topics       map[string]bool // subscribed request topics
 	disconnected chan bool
 	logger       *logger
 	installer    installer
 	runner       runner
 	terminal     *executor
+	// encrypted secrets received ahead of tasks
+	secrets       map[string][]byte
+	secretsLocker sync.Mutex
 }
 
 // TODO
@@ -46,6 +51,7 @@ func startAgent(target *target, managerAddr string) (*agent, error) {
 	a := &agent{
 		pipe:         model.NewPipe(),
 		disconnected: make(chan bool),
+		secrets:      make(map[string][]byte),
 	}
 	a.target = target
 
@@ -69,7 +75,7 @@ func startAgent(target *target, managerAddr string) (*agent, error) {
 		a.target.saveState()
 	}
 
-	a.logger = newLogger(a.target.ID, a.pipe.ResponseCh)
+	a.logger = newLogger(a.target.ID, a.target.LogConf, a.pipe.ResponseCh)
 	a.runner = newRunner(a.logger.enqueue)
 	a.installer = newInstaller(a.logger.enqueue)
 
@@ -80,8 +86,8 @@ func startAgent(target *target, managerAddr string) (*agent, error) {
 
 	// autostart
 	// TODO check autostart settings
-	if len(a.target.TaskRun) > 0 {
-		go a.runner.run(a.target.TaskRun, a.target.TaskID, a.target.TaskDebug)
+	if len(a.target.TaskRun) > 0 || a.target.TaskContainer != nil {
+		go a.runner.run(a.target.runSpec(), a.target.TaskID, a.target.TaskDebug)
 	}
 
 	go a.startWorker()
@@ -170,7 +176,7 @@ func (a *agent) getServerInfo(addr string) (*model.ZeromqServerInfo, error) {
 }
 
 func (a *agent) startWorker() {
-	topics := a.subscribe()
+	a.subscribe()
 
 	log.Println("worker: Waiting for connection and requests...")
 	var latestMessageChecksum [16]byte
@@ -181,7 +187,7 @@ func (a *agent) startWorker() {
 			go a.connected()
 		case request.Topic == model.PipeDisconnected:
 			a.disconnected <- true
-		case topics[request.Topic]:
+		case a.subscribed(request.Topic):
 			// a request may be received on few topics but needs to be processed only once
 			sum := md5.Sum(request.Payload)
 			if latestMessageChecksum != sum {
@@ -197,20 +203,38 @@ func (a *agent) startWorker() {
 	}
 }
 
-func (a *agent) subscribe() map[string]bool {
+// subscribe subscribes to request topics of the target and unsubscribes from the ones no longer relevant
+func (a *agent) subscribe() {
 	log.Printf("Subscribing to topics...")
 
 	topics := make(map[string]bool)
 	topics[model.RequestTargetAll] = true
+	a.target.mutex.Lock()
 	topics[model.FormatTopicID(a.target.ID)] = true
 	for _, tag := range a.target.Tags {
 		topics[model.FormatTopicTag(tag)] = true
 	}
+	a.target.mutex.Unlock()
 
+	a.Lock()
+	defer a.Unlock()
+	for topic := range a.topics {
+		if !topics[topic] {
+			a.pipe.OperationCh <- model.Operation{model.OperationUnsubscribe, topic}
+		}
+	}
 	for topic := range topics {
-		a.pipe.OperationCh <- model.Operation{model.OperationSubscribe, topic}
+		if !a.topics[topic] {
+			a.pipe.OperationCh <- model.Operation{model.OperationSubscribe, topic}
+		}
 	}
-	return topics
+	a.topics = topics
+}
+
+func (a *agent) subscribed(topic string) bool {
+	a.Lock()
+	defer a.Unlock()
+	return a.topics[topic]
 }
 
 func (a *agent) connected() {
@@ -236,15 +260,65 @@ func (a *agent) connected() {
 }
 
 func (a *agent) sendAdvertisement() {
-	t := model.TargetBase{
+	b, _ := json.Marshal(a.targetBase())
+	log.Printf("Sending adv: %s", b)
+	a.pipe.ResponseCh <- model.Message{model.ResponseAdvertisement, b}
+}
+
+func (a *agent) targetBase() model.TargetBase {
+	a.target.mutex.Lock()
+	defer a.target.mutex.Unlock()
+	base := model.TargetBase{
 		ID:        a.target.ID,
 		Tags:      a.target.Tags,
 		Location:  a.target.Location,
 		PublicKey: a.target.PublicKey,
+		Memory:    memory.TotalMemory(),
+		Arch:      runtime.GOARCH,
+	}
+	// the certified key lets the manager accept certificates issued before keys were bound to target ids
+	if cert, err := swarmio.LoadCert(); err == nil {
+		base.PublicKeySwarmio = cert.PublicKey
+	}
+	return base
+}
+
+// reloadConf reloads the config file and applies changes to tags and location without interrupting the tasks
+func (a *agent) reloadConf(path string) {
+	log.Println("Reloading configuration...")
+	if path != "" {
+		err := loadConfFile(path)
+		if err != nil {
+			log.Printf("Error reloading config: %s", err)
+			return
+		}
+	}
+
+	// the config may be updated by the manager and served by the local API in the meantime
+	a.target.mutex.Lock()
+	if a.target.ManagedConfig {
+		a.target.mutex.Unlock()
+		log.Println("Tags and location are managed by the manager. Ignoring local settings.")
+		return
+	}
+	changed, err := a.target.loadTagsAndLocation()
+	tags, location := a.target.Tags, a.target.Location
+	a.target.mutex.Unlock()
+	if err != nil {
+		log.Printf("Error reloading config: %s", err)
+		return
+	}
+	if !changed {
+		log.Println("No changes in tags or location. Other settings take effect after restart.")
+		return
+	}
+	log.Println("Reloaded tags and location:", tags, location)
+	a.target.saveState()
+
+	a.subscribe()
+	if Connected() {
+		a.sendAdvertisement()
 	}
-	b, _ := json.Marshal(t)
-	log.Printf("Sending adv: %s", b)
-	a.pipe.ResponseCh <- model.Message{model.ResponseAdvertisement, b}
 }
 
 func (a *agent) handleRequest(payload []byte) {
@@ -266,14 +340,36 @@ func (a *agent) handleRequest(payload []byte) {
 		a.executeCommand(w.Command)
 	case w.StopAll != nil:
 		a.stopAll()
+	case w.Config != nil:
+		a.updateConfig(w.Config)
+	case w.Secrets != nil:
+		a.receiveSecrets(w.Secrets)
 	default:
 		log.Printf("Invalid request: %s->%v", string(payload), w) // TODO send to manager
 	}
 }
 
+// updateConfig applies the tags and location set by the manager and acknowledges them
+//	The local settings are ignored from this point on
+func (a *agent) updateConfig(conf *model.TargetConfig) {
+	log.Println("Received config update:", conf.Tags, conf.Location)
+	a.target.mutex.Lock()
+	a.target.Tags = conf.Tags
+	a.target.Location = conf.Location
+	a.target.ManagedConfig = true
+	a.target.mutex.Unlock()
+	a.target.saveState()
+
+	a.subscribe()
+
+	b, _ := json.Marshal(a.targetBase())
+	log.Printf("Sending config acknowledgement: %s", b)
+	a.pipe.ResponseCh <- model.Message{model.ResponseConfigAck, b}
+}
+
 func (a *agent) handleAnnouncement(taskA *model.Announcement) {
 
-	if a.target.TaskHistory[taskA.ID] >= taskA.Type {
+	if a.target.TaskHistory[taskA.ID] >= taskA.Type && taskA.Retry <= a.target.TaskRetries[taskA.ID] {
 		// repeated because other agents expects it or manager hasn't received all acknowledgements
 		log.Printf("Dropped repeated announcement %s/%d", taskA.ID, taskA.Type)
 		return
@@ -290,8 +386,13 @@ func (a *agent) handleAnnouncement(taskA *model.Announcement) {
 	if len(a.target.TaskHistory) >= 10 {
 		log.Printf("Clearing task history.")
 		a.target.TaskHistory = make(map[string]uint8)
+		a.target.TaskRetries = make(map[string]model.UnixTimeType)
 	}
 	a.target.TaskHistory[taskA.ID] = taskA.Type
+	if taskA.Retry > 0 {
+		log.Printf("Retry of %s/%d requested", taskA.ID, taskA.Type)
+		a.target.TaskRetries[taskA.ID] = taskA.Retry
+	}
 	a.target.saveState()
 
 	//a.sendLog(taskA.ID, stage, model.StageStart, false, taskA.Debug)
@@ -308,7 +409,7 @@ func (a *agent) handleAnnouncement(taskA *model.Announcement) {
 }
 
 func (*agent) assessAnnouncement(ann *model.Announcement) bool {
-	sizeLimit := memory.TotalMemory() / 2 // TODO calculate this based on the available memory
+	sizeLimit := model.TaskSizeLimit(memory.TotalMemory())
 	return uint64(ann.Size) <= sizeLimit
 }
 
@@ -331,48 +432,129 @@ func (a *agent) handleTask(payload []byte) {
 
 	log.Printf("Received task: %s", task.ID)
 
+	// packages of other architectures are meant for other targets, keep waiting for the matching one
+	if task.Arch != "" && task.Arch != runtime.GOARCH {
+		log.Printf("Ignoring task %s for architecture %s", task.ID, task.Arch)
+		return
+	}
+	// deltas are meant for other targets, unless addressed to this one
+	if task.Delta != nil && !meantFor(task.Delta, a.target.ID) {
+		log.Printf("Ignoring delta of task %s against %s", task.ID, task.Delta.Base)
+		return
+	}
+
 	a.pipe.OperationCh <- model.Operation{model.OperationUnsubscribe, task.ID}
 	a.sendLog(task.ID, stage, "received task", false, true)
 
+	// the manager may not know the current deployment, e.g. after a failed deployment
+	if task.Delta != nil && task.Delta.Base != a.target.TaskID {
+		a.sendLogFatal(task.ID, stage, fmt.Sprintf("delta is against %s instead of the current deployment %s, retry to send full artifacts", task.Delta.Base, a.target.TaskID))
+		return
+	}
+
+	if task.Download != nil {
+		err = a.downloadArtifacts(&task)
+		if err != nil {
+			a.sendLogFatal(task.ID, stage, fmt.Sprintf("error downloading artifacts: %s", err))
+			return
+		}
+		a.sendLog(task.ID, stage, fmt.Sprintf("downloaded %d bytes of artifacts", len(task.Artifacts)), false, task.Debug)
+	}
+
+	err = verifyArtifacts(&task)
+	if err != nil {
+		a.sendLogFatal(task.ID, stage, fmt.Sprintf("error verifying task: %s", err))
+		return
+	}
+
 	err = a.saveArtifacts(task.Artifacts, task.ID, stage, task.Debug)
 	if err != nil {
 		a.sendLogFatal(task.ID, stage, err.Error())
 		return
 	}
 
+	if task.Delta != nil {
+		err = applyDelta(&task)
+		if err != nil {
+			a.sendLogFatal(task.ID, stage, fmt.Sprintf("error applying delta, retry to send full artifacts: %s", err))
+			return
+		}
+		a.sendLog(task.ID, stage, fmt.Sprintf("applied delta to %s", task.Delta.Base), false, task.Debug)
+	}
+
+	if len(task.Secrets) > 0 {
+		err = a.installSecrets(&task)
+		if err != nil {
+			a.sendLogFatal(task.ID, stage, fmt.Sprintf("error installing secrets: %s", err))
+			return
+		}
+	}
+
+	if task.Template != nil {
+		err = a.renderTask(&task)
+		if err != nil {
+			a.sendLogFatal(task.ID, stage, fmt.Sprintf("error rendering templates: %s", err))
+			return
+		}
+	}
+
 	if task.Build != nil {
-		a.build(task.Build, task.ID, task.Debug)
+		a.build(task.Build, task.ID, task.Archive, task.Secrets, task.Debug)
 		return
 	}
 	//a.sendLog(task.ID, model.StageEnd, false, task.Debug)
 
-	success := a.installer.install(task.Deploy.Install.Commands, model.StageInstall, task.ID, task.Debug)
+	success := a.installer.install(task.Deploy.PreInstall.Commands, model.StagePreInstall, task.ID, task.Debug)
+	if !success {
+		a.sendLogFatal(task.ID, model.StageInstall, "pre-install hook failed")
+		return
+	}
+	if task.Deploy.Container != nil {
+		success = newContainerExecutor(task.ID, a.logger.enqueue, task.Debug).pull(task.Deploy.Container)
+		if !success {
+			a.sendLogFatal(task.ID, model.StageInstall, "unable to pull container image")
+			return
+		}
+	}
+	success = a.installer.install(task.Deploy.Install.Commands, model.StageInstall, task.ID, task.Debug)
 	if success {
-		a.runner.stop()             // stop runner for old task
+		a.runner.stop()             // stop runner for old task, including its hooks
 		a.removeOtherTasks(task.ID) // remove old task files
+		a.target.mutex.Lock()
 		a.target.TaskRun = task.Deploy.Run.Commands
 		a.target.TaskRunAutoRestart = task.Deploy.Run.AutoRestart
+		a.target.TaskPostRun = task.Deploy.PostRun.Commands
+		a.target.TaskStop = task.Deploy.Stop.Commands
+		a.target.TaskContainer = task.Deploy.Container
 		a.target.TaskID = task.ID
 		a.target.TaskDebug = task.Debug
+		a.target.mutex.Unlock()
 		a.target.saveState()
 
-		go a.runner.run(task.Deploy.Run.Commands, task.ID, task.Debug)
+		go a.runner.run(a.target.runSpec(), task.ID, task.Debug)
 	}
 }
 
-func (a *agent) build(build *model.Build, taskID string, debug bool) {
+func (a *agent) build(build *model.Build, taskID, archive string, secrets []model.SecretRef, debug bool) {
 
 	success := a.installer.install(build.Commands, model.StageBuild, taskID, debug)
 	if success {
 		a.removeOtherTasks(taskID) // remove old task files
 
+		// secret files must not leave the build host with the package
+		err := removeSecretFiles(taskID, secrets)
+		if err != nil {
+			a.sendLogFatal(taskID, model.StageBuild, fmt.Sprintf("error removing secret files: %s", err))
+			return
+		}
+
 		wd := fmt.Sprintf("%s/tasks/%s/%s", WorkDir, taskID, source.SourceDir)
 		// make it relative to work directory
 		paths := make([]string, len(build.Artifacts))
 		for i := range build.Artifacts {
 			paths[i] = fmt.Sprintf("%s/%s", wd, build.Artifacts[i])
 		}
-		compressed, err := model.CompressFiles(paths...)
+		compressed, err := model.CompressFilesAs(archive, paths...)
 		if err != nil {
 			a.sendLogFatal(taskID, model.StageBuild, fmt.Sprintf("error compressing package: %s", err))
 			return
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"code.linksmart.eu/dt/deployment-tool/manager/model"
 	"code.linksmart.eu/dt/deployment-tool/manager/zeromq"
@@ -24,12 +25,19 @@ type target struct {
 	Registered       bool             `json:"registered"`
 	ZeromqServerConf zeromqServerConf `json:"zeromqServer"`
 	ManagerAddr      string           `json:"-"`
+	LogConf          logConf          `json:"-"`
+	ManagedConfig    bool             `json:"managedConfig,omitempty"` // tags and location are set by the manager
 	// active task
 	TaskID             string           `json:"taskID"`
 	TaskDebug          bool             `json:"taskDebug,omitempty"`
 	TaskRun            []string         `json:"taskRun,omitempty"`
 	TaskRunAutoRestart bool             `json:"taskRunAutoRestart,omitempty"`
+	TaskPostRun        []string         `json:"taskPostRun,omitempty"`
+	TaskStop           []string         `json:"taskStop,omitempty"`
+	TaskContainer      *model.Container `json:"taskContainer,omitempty"`
 	TaskHistory        map[string]uint8 `json:"taskHistory,omitempty"`
+	// time of the latest retry of tasks
+	TaskRetries map[string]model.UnixTimeType `json:"taskRetries,omitempty"`
 }
 
 type zeromqServerConf struct {
@@ -47,6 +55,9 @@ func loadConf() (*target, error) {
 	if t.TaskHistory == nil {
 		t.TaskHistory = make(map[string]uint8)
 	}
+	if t.TaskRetries == nil {
+		t.TaskRetries = make(map[string]model.UnixTimeType)
+	}
 
 	if os.Getenv(EnvManagerAddr) == "" {
 		return nil, fmt.Errorf("manager address not set")
@@ -68,7 +79,7 @@ func loadConf() (*target, error) {
 	// LOAD AND REPLACE WITH ENV VARIABLES
 	var changed bool
 
-	id := os.Getenv("ID")
+	id := os.Getenv(EnvID)
 	if id == "" && t.AutoGenID == "" {
 		t.AutoGenID = uuid.NewV4().String()
 		log.Println("Generated target ID:", t.AutoGenID)
@@ -86,8 +97,42 @@ func loadConf() (*target, error) {
 		changed = true
 	}
 
+	if t.ManagedConfig {
+		log.Println("Tags and location are managed by the manager. Ignoring local settings.")
+	} else {
+		tagsChanged, err := t.loadTagsAndLocation()
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || tagsChanged
+	}
+
+	t.LogConf, err = loadLogConf()
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		t.saveState()
+	}
+	return t, nil
+}
+
+// runSpec returns the run settings of the active task
+func (t *target) runSpec() runSpec {
+	return runSpec{
+		commands:  t.TaskRun,
+		container: t.TaskContainer,
+		postRun:   t.TaskPostRun,
+		stop:      t.TaskStop,
+	}
+}
+
+// loadTagsAndLocation sets tags and location from env variables and returns true if any of them has changed
+//	The caller must hold the mutex if the target is in use.
+func (t *target) loadTagsAndLocation() (changed bool, err error) {
 	var tags []string
-	tagsString := os.Getenv("TAGS")
+	tagsString := os.Getenv(EnvTags)
 	if tagsString != "" {
 		tags = strings.Split(tagsString, ",")
 		for i := 0; i < len(tags); i++ {
@@ -99,39 +144,70 @@ func loadConf() (*target, error) {
 		changed = true
 	}
 
-	latString := os.Getenv("LOCATION_LAT")
-	lonString := os.Getenv("LOCATION_LON")
+	latString := os.Getenv(EnvLocationLat)
+	lonString := os.Getenv(EnvLocationLon)
 	if latString != "" && lonString != "" {
 		lat, err := strconv.ParseFloat(latString, 64)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing lat: %s", err)
+			return false, fmt.Errorf("error parsing lat: %s", err)
 		}
 		lon, err := strconv.ParseFloat(lonString, 64)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing lon: %s", err)
+			return false, fmt.Errorf("error parsing lon: %s", err)
 		}
-		if t.Location == nil {
+		// replaced rather than modified, as copies of the target may still refer to it
+		if t.Location == nil || t.Location.Lat != lat || t.Location.Lon != lon {
 			t.Location = &model.Location{Lat: lat, Lon: lon}
 			changed = true
-		} else {
-			if t.Location.Lat != lat {
-				t.Location.Lat = lat
-				changed = true
-			}
-			if t.Location.Lon != lon {
-				t.Location.Lon = lon
-				changed = true
-			}
 		}
 	} else if t.Location != nil {
 		t.Location = nil
 		changed = true
 	}
 
-	if changed {
-		t.saveState()
+	return changed, nil
+}
+
+// loadLogConf reads logger settings from env variables, falling back to defaults
+func loadLogConf() (logConf, error) {
+	conf := logConf{
+		memoryCapacity: DefaultMemoryStorageCapacity,
+		bufferCapacity: DefaultOutgoingBufferCapacity,
+		flushInterval:  DefaultOutgoingFlushInterval,
 	}
-	return t, nil
+
+	if v := os.Getenv(EnvLogMemoryCapacity); v != "" {
+		capacity, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return conf, fmt.Errorf("error parsing %s: %s", EnvLogMemoryCapacity, err)
+		}
+		if capacity == 0 {
+			return conf, fmt.Errorf("%s must be positive", EnvLogMemoryCapacity)
+		}
+		conf.memoryCapacity = uint8(capacity)
+	}
+	if v := os.Getenv(EnvLogBufferCapacity); v != "" {
+		capacity, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return conf, fmt.Errorf("error parsing %s: %s", EnvLogBufferCapacity, err)
+		}
+		if capacity == 0 {
+			return conf, fmt.Errorf("%s must be positive", EnvLogBufferCapacity)
+		}
+		conf.bufferCapacity = uint8(capacity)
+	}
+	if v := os.Getenv(EnvLogFlushInterval); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return conf, fmt.Errorf("error parsing %s: %s", EnvLogFlushInterval, err)
+		}
+		if interval <= 0 {
+			return conf, fmt.Errorf("%s must be positive", EnvLogFlushInterval)
+		}
+		conf.flushInterval = interval
+	}
+
+	return conf, nil
 }
 
 func loadState() (*target, error) {
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// fileConf is the structure of the agent configuration file.
+// Each setting corresponds to an environment variable. Variables set explicitly take precedence.
+type fileConf struct {
+	ManagerAddr string   `yaml:"managerAddr"`
+	AuthToken   string   `yaml:"authToken"`
+	ID          string   `yaml:"id"`
+	Tags        []string `yaml:"tags"`
+	Location    *struct {
+		Lat float64 `yaml:"lat"`
+		Lon float64 `yaml:"lon"`
+	} `yaml:"location"`
+	PrivateKey string `yaml:"privateKey"`
+	PublicKey  string `yaml:"publicKey"`
+	LocalAPI   string `yaml:"localAPI"`
+	WorkDir    string `yaml:"workDir"`
+	Logs       struct {
+		MemoryCapacity uint8  `yaml:"memoryCapacity"`
+		BufferCapacity uint8  `yaml:"bufferCapacity"`
+		FlushInterval  string `yaml:"flushInterval"` // e.g. 5s
+	} `yaml:"logs"`
+}
+
+// confFileKeys is the set of env variables that have been set from the config file
+var confFileKeys = make(map[string]bool)
+
+// confFilePath returns the absolute path to the config file or empty string if there is none
+func confFilePath() (string, error) {
+	path := os.Getenv(EnvConfigFile)
+	if path == "" {
+		if _, err := os.Stat(DefaultConfigFile); os.IsNotExist(err) {
+			return "", nil
+		}
+		path = DefaultConfigFile
+	}
+	return filepath.Abs(path)
+}
+
+// loadConfFile reads the config file and sets the env variables which are not set explicitly
+func loadConfFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %s", err)
+	}
+
+	var conf fileConf
+	err = yaml.UnmarshalStrict(b, &conf)
+	if err != nil {
+		return fmt.Errorf("error parsing config file: %s", err)
+	}
+
+	for key, value := range conf.env() {
+		if _, found := os.LookupEnv(key); found && !confFileKeys[key] {
+			if value != "" {
+				log.Printf("Config file: %s is overridden by env variable.", key)
+			}
+			continue
+		}
+		if value == "" {
+			os.Unsetenv(key)
+			delete(confFileKeys, key)
+			continue
+		}
+		os.Setenv(key, value)
+		confFileKeys[key] = true
+	}
+	log.Println("Loaded config file:", path)
+
+	return nil
+}
+
+// env returns the settings as env variable key/values
+func (c *fileConf) env() map[string]string {
+	m := map[string]string{
+		EnvManagerAddr:       c.ManagerAddr,
+		EnvAuthToken:         c.AuthToken,
+		EnvID:                c.ID,
+		EnvTags:              strings.Join(c.Tags, ","),
+		EnvLocationLat:       "",
+		EnvLocationLon:       "",
+		EnvPrivateKey:        c.PrivateKey,
+		EnvPublicKey:         c.PublicKey,
+		EnvLocalAPI:          c.LocalAPI,
+		EnvWorkDir:           c.WorkDir,
+		EnvLogMemoryCapacity: "",
+		EnvLogBufferCapacity: "",
+		EnvLogFlushInterval:  c.Logs.FlushInterval,
+	}
+	if c.Location != nil {
+		m[EnvLocationLat] = strconv.FormatFloat(c.Location.Lat, 'f', -1, 64)
+		m[EnvLocationLon] = strconv.FormatFloat(c.Location.Lon, 'f', -1, 64)
+	}
+	if c.Logs.MemoryCapacity != 0 {
+		m[EnvLogMemoryCapacity] = strconv.Itoa(int(c.Logs.MemoryCapacity))
+	}
+	if c.Logs.BufferCapacity != 0 {
+		m[EnvLogBufferCapacity] = strconv.Itoa(int(c.Logs.BufferCapacity))
+	}
+	return m
+}
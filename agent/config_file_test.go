@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+)
+
+// setupConfTest works in a temporary directory and restores the work directory and the env variables of the config file
+func setupConfTest(t *testing.T) (dir string, restore func()) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting work directory: %s", err)
+	}
+	dir, err = ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("Error changing work directory: %s", err)
+	}
+
+	var conf fileConf
+	keys := []string{EnvConfigFile}
+	for key := range conf.env() {
+		keys = append(keys, key)
+	}
+	env := make(map[string]*string)
+	for _, key := range keys {
+		if value, found := os.LookupEnv(key); found {
+			env[key] = &value
+		} else {
+			env[key] = nil
+		}
+		os.Unsetenv(key)
+	}
+	fileKeys := confFileKeys
+	confFileKeys = make(map[string]bool)
+
+	return dir, func() {
+		for key, value := range env {
+			if value != nil {
+				os.Setenv(key, *value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+		confFileKeys = fileKeys
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func writeConfFile(t *testing.T, path, conf string) {
+	err := ioutil.WriteFile(path, []byte(conf), 0600)
+	if err != nil {
+		t.Fatalf("Error writing config file: %s", err)
+	}
+}
+
+func TestLoadConfFile(t *testing.T) {
+	dir, restore := setupConfTest(t)
+	defer restore()
+
+	path := filepath.Join(dir, "agent.yml")
+	writeConfFile(t, path, `
+managerAddr: http://manager:8080
+id: from-file
+tags: [a, b]
+location: {lat: 48.1, lon: 11.5}
+workDir: /var/lib/agent
+logs:
+  memoryCapacity: 50
+  flushInterval: 10s
+`)
+	// explicitly set variables take precedence
+	os.Setenv(EnvID, "explicit")
+
+	err := loadConfFile(path)
+	if err != nil {
+		t.Fatalf("Error loading config file: %s", err)
+	}
+	expected := map[string]string{
+		EnvManagerAddr:       "http://manager:8080",
+		EnvID:                "explicit",
+		EnvTags:              "a,b",
+		EnvLocationLat:       "48.1",
+		EnvLocationLon:       "11.5",
+		EnvWorkDir:           "/var/lib/agent",
+		EnvLogMemoryCapacity: "50",
+		EnvLogFlushInterval:  "10s",
+	}
+	for key, value := range expected {
+		if os.Getenv(key) != value {
+			t.Fatalf("Expected %s=%s, got %q", key, value, os.Getenv(key))
+		}
+	}
+	if _, found := os.LookupEnv(EnvLogBufferCapacity); found {
+		t.Fatalf("Expected %s to be unset", EnvLogBufferCapacity)
+	}
+
+	// settings removed from the file are unset, unless set explicitly
+	writeConfFile(t, path, "managerAddr: http://manager:8080\nid: other\n")
+	err = loadConfFile(path)
+	if err != nil {
+		t.Fatalf("Error reloading config file: %s", err)
+	}
+	for _, key := range []string{EnvTags, EnvLocationLat, EnvLocationLon, EnvWorkDir, EnvLogMemoryCapacity} {
+		if _, found := os.LookupEnv(key); found {
+			t.Fatalf("Expected %s to be unset after reload", key)
+		}
+	}
+	if os.Getenv(EnvID) != "explicit" {
+		t.Fatalf("Explicit %s is overridden: %s", EnvID, os.Getenv(EnvID))
+	}
+
+	writeConfFile(t, path, "unknown: setting\n")
+	if err := loadConfFile(path); err == nil {
+		t.Fatalf("Config file with unknown setting is loaded")
+	}
+}
+
+func TestConfFilePath(t *testing.T) {
+	dir, restore := setupConfTest(t)
+	defer restore()
+
+	path, err := confFilePath()
+	if err != nil || path != "" {
+		t.Fatalf("Expected no config file, got %q: %v", path, err)
+	}
+	writeConfFile(t, filepath.Join(dir, DefaultConfigFile), "")
+	path, err = confFilePath()
+	if err != nil || filepath.Base(path) != filepath.Base(DefaultConfigFile) || !filepath.IsAbs(path) {
+		t.Fatalf("Expected absolute path of default config file, got %q: %v", path, err)
+	}
+	// the path stays valid after changing to the work directory
+	os.Setenv(EnvConfigFile, "custom.yml")
+	path, err = confFilePath()
+	if err != nil || path != filepath.Join(dir, "custom.yml") {
+		t.Fatalf("Expected absolute path of custom config file, got %q: %v", path, err)
+	}
+}
+
+// TestReloadConf checks that tags and location are reloaded while the target is in use, unless managed by the manager
+func TestReloadConf(t *testing.T) {
+	dir, restore := setupConfTest(t)
+	defer restore()
+
+	path := filepath.Join(dir, "agent.yml")
+
+	a := &agent{
+		target: &target{},
+		pipe: model.Pipe{
+			ResponseCh:  make(chan model.Message, 10),
+			OperationCh: make(chan model.Operation, 10),
+		},
+	}
+	a.target.ID = "gw"
+
+	// the target is served while being reloaded
+	done, started := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		close(started)
+		for {
+			select {
+			case <-done:
+				return
+			case <-a.pipe.OperationCh:
+			default:
+				targetStatus(a.target)
+				a.targetBase()
+			}
+		}
+	}()
+	<-started
+	for i := 0; i < 10; i++ {
+		writeConfFile(t, path, "tags: [c]\nlocation: {lat: 3, lon: 4}\n")
+		a.reloadConf(path)
+		writeConfFile(t, path, "tags: [a]\nlocation: {lat: 1, lon: 2}\n")
+		a.reloadConf(path)
+	}
+	close(done)
+	wg.Wait()
+
+	status := targetStatus(a.target)
+	if !reflect.DeepEqual(status.Tags, []string{"a"}) || status.Location == nil || status.Location.Lat != 1 || status.Location.Lon != 2 {
+		t.Fatalf("Unexpected tags and location: %v %v", status.Tags, status.Location)
+	}
+	state, err := loadState()
+	if err != nil || !reflect.DeepEqual(state.Tags, []string{"a"}) {
+		t.Fatalf("Expected reloaded tags to be saved, got %v: %v", state, err)
+	}
+	if !a.subscribed(model.FormatTopicTag("a")) {
+		t.Fatalf("Expected subscription to reloaded tag")
+	}
+
+	// local settings are ignored once the config is managed
+	a.target.ManagedConfig = true
+	writeConfFile(t, path, "tags: [b]\n")
+	a.reloadConf(path)
+	status = targetStatus(a.target)
+	if !reflect.DeepEqual(status.Tags, []string{"a"}) || status.Location == nil {
+		t.Fatalf("Managed tags and location are replaced: %v %v", status.Tags, status.Location)
+	}
+}
+
+// TestUpdateConfig checks that the config set by the manager is acknowledged and replaces local settings, also after restart
+func TestUpdateConfig(t *testing.T) {
+	dir, restore := setupConfTest(t)
+	defer restore()
+
+	a := &agent{
+		target: &target{},
+		pipe: model.Pipe{
+			ResponseCh:  make(chan model.Message, 10),
+			OperationCh: make(chan model.Operation, 10),
+		},
+	}
+	a.target.ID = "gw"
+	a.target.Tags = []string{"local"}
+
+	a.updateConfig(&model.TargetConfig{Tags: []string{"managed"}, Location: &model.Location{Lat: 1, Lon: 2}})
+	select {
+	case message := <-a.pipe.ResponseCh:
+		var base model.TargetBase
+		json.Unmarshal(message.Payload, &base)
+		if message.Topic != model.ResponseConfigAck || !reflect.DeepEqual(base.Tags, []string{"managed"}) {
+			t.Fatalf("Expected acknowledgement of managed config, got %s: %s", message.Topic, message.Payload)
+		}
+	default:
+		t.Fatalf("Config update is not acknowledged")
+	}
+	if !a.subscribed(model.FormatTopicTag("managed")) || a.subscribed(model.FormatTopicTag("local")) {
+		t.Fatalf("Expected subscription to managed tag only")
+	}
+
+	// local settings are ignored on reload
+	path := filepath.Join(dir, "agent.yml")
+	writeConfFile(t, path, "tags: [local]\n")
+	a.reloadConf(path)
+	if status := targetStatus(a.target); !reflect.DeepEqual(status.Tags, []string{"managed"}) || status.Location == nil {
+		t.Fatalf("Managed config is replaced on reload: %v %v", status.Tags, status.Location)
+	}
+
+	// and after restart
+	err := loadConfFile(path)
+	if err != nil {
+		t.Fatalf("Error loading config file: %s", err)
+	}
+	os.Setenv(EnvManagerAddr, "http://manager:8080")
+	writeConfFile(t, filepath.Join(dir, DefaultPublicKeyPath), "key")
+	restarted, err := loadConf()
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+	if !restarted.ManagedConfig || !reflect.DeepEqual(restarted.Tags, []string{"managed"}) || restarted.Location == nil {
+		t.Fatalf("Managed config is replaced after restart: %v %v", restarted.Tags, restarted.Location)
+	}
+}
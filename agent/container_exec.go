@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"code.linksmart.eu/dt/deployment-tool/agent/docker"
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+)
+
+const (
+	ContainerPrefix = "deployment-" // prefix of application container names
+)
+
+// containerExecutor runs the application container of a task
+type containerExecutor struct {
+	sync.Mutex
+	task       string
+	logEnqueue enqueueFunc
+	debug      bool
+	engine     *docker.Engine
+	id         string   // id of the running container
+	redact     []string // secret values
+}
+
+func newContainerExecutor(task string, logEnqueue enqueueFunc, debug bool) *containerExecutor {
+	return &containerExecutor{
+		task:       task,
+		logEnqueue: logEnqueue,
+		debug:      debug,
+	}
+}
+
+func (e *containerExecutor) connect() error {
+	if e.engine != nil {
+		return nil
+	}
+	engine, err := docker.NewEngine("")
+	if err != nil {
+		return err
+	}
+	e.engine = engine
+	return nil
+}
+
+// pull pulls the container image
+func (e *containerExecutor) pull(spec *model.Container) (success bool) {
+	command := "docker pull " + spec.Ref()
+	e.sendLog(model.StageInstall, command, model.ExecStart, false)
+
+	err := e.connect()
+	if err != nil {
+		e.sendLogFatal(model.StageInstall, command, err.Error())
+		return false
+	}
+
+	err = e.engine.Pull(spec.Ref(), func(line string, stderr bool) {
+		e.sendLog(model.StageInstall, command, line, stderr)
+	})
+	if err != nil {
+		e.sendLogFatal(model.StageInstall, command, err.Error())
+		return false
+	}
+	e.sendLog(model.StageInstall, command, model.ExecEnd, false)
+	return true
+}
+
+// execute starts the container and forwards its logs until it exits
+func (e *containerExecutor) execute(spec *model.Container) (success bool) {
+	command := "docker run " + spec.Ref()
+	e.sendLog(model.StageRun, command, model.ExecStart, false)
+
+	err := e.connect()
+	if err != nil {
+		e.sendLogFatal(model.StageRun, command, err.Error())
+		return false
+	}
+
+	env, redact, err := loadTaskSecrets(e.task)
+	if err != nil {
+		e.sendLogFatal(model.StageRun, command, err.Error())
+		return false
+	}
+	if len(env) > 0 {
+		withSecrets := *spec
+		withSecrets.Env = append(append([]string{}, spec.Env...), env...)
+		spec = &withSecrets
+		e.redact = redact
+	}
+
+	id, err := e.engine.Start(ContainerPrefix+e.task, spec)
+	if err != nil {
+		e.sendLogFatal(model.StageRun, command, err.Error())
+		return false
+	}
+	e.Lock()
+	e.id = id
+	e.Unlock()
+	log.Printf("container: Started %s for task %s", id, e.task)
+
+	exitCode, err := e.engine.Follow(id, func(line string, stderr bool) {
+		e.sendLog(model.StageRun, command, line, stderr)
+	})
+	if err != nil {
+		e.sendLogFatal(model.StageRun, command, err.Error())
+		return false
+	}
+	if exitCode != 0 {
+		e.sendLogFatal(model.StageRun, command, fmt.Sprintf("exit status %d", exitCode))
+		return false
+	}
+	e.sendLog(model.StageRun, command, model.ExecEnd, false)
+	return true
+}
+
+func (e *containerExecutor) sendLog(stage, command, output string, error bool) {
+	if len(e.redact) > 0 {
+		output = redactSecrets(output, e.redact)
+	}
+	e.logEnqueue(&model.Log{e.task, stage, command, output, error, model.UnixTime(), e.debug})
+}
+
+func (e *containerExecutor) sendLogFatal(stage, command, output string) {
+	log.Println("container: Error:", output)
+	e.sendLog(stage, command, output, true)
+	e.sendLog(stage, command, model.ExecEnd, true)
+}
+
+// stop stops and removes the container
+func (e *containerExecutor) stop() (success bool) {
+	e.Lock()
+	defer e.Unlock()
+	if e.id == "" || e.engine == nil {
+		return true
+	}
+
+	err := e.engine.Remove(e.id)
+	if err != nil {
+		log.Printf("container: Error removing %s: %s", e.id, err)
+		return false
+	}
+	log.Println("container: Removed:", e.id)
+	e.id = ""
+	return true
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+)
+
+// applyDelta completes the extracted delta with unchanged files of the base deployment
+//	The full tree is verified against the manifest before the task is installed.
+func applyDelta(task *model.Task) error {
+	delta := task.Delta
+	baseTaskDir := filepath.Join(WorkDir, "tasks", delta.Base)
+	baseDir, found := source.ExecDir(baseTaskDir)
+	if !found {
+		return fmt.Errorf("files of current deployment %s not found", delta.Base)
+	}
+	return delta.Apply(filepath.Join(WorkDir, "tasks", task.ID, delta.Dir), filepath.Join(baseTaskDir, baseDir))
+}
+
+// meantFor tells if the delta is sent to the target, as deltas of a task are sent to all of its targets
+func meantFor(delta *model.Delta, targetID string) bool {
+	for _, id := range delta.Targets {
+		if id == targetID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+)
+
+// TestDeltaMismatch checks that a delta against another deployment than the current one is reported to the manager
+func TestDeltaMismatch(t *testing.T) {
+	a := &agent{
+		target: &target{TaskID: "current"},
+		pipe:   model.NewPipe(),
+		logger: &logger{queue: make(chan model.Log, 10)},
+	}
+	a.target.ID = "gw"
+	go func() {
+		for range a.pipe.OperationCh {
+		}
+	}()
+	defer close(a.pipe.OperationCh)
+
+	handle := func(delta *model.Delta) []model.Log {
+		b, _ := json.Marshal(model.Task{Header: model.Header{ID: "task"}, Deploy: &model.Deploy{}, Delta: delta})
+		a.handleTask(b)
+		var logs []model.Log
+		for {
+			select {
+			case l := <-a.logger.queue:
+				logs = append(logs, l)
+			case <-time.After(100 * time.Millisecond):
+				return logs
+			}
+		}
+	}
+
+	// deltas for other targets are ignored
+	logs := handle(&model.Delta{Base: "other", Targets: []string{"other-gw"}})
+	if len(logs) != 0 {
+		t.Fatalf("Expected delta of other targets to be ignored, got %+v", logs)
+	}
+
+	logs = handle(&model.Delta{Base: "previous", Targets: []string{"gw"}})
+	if len(logs) == 0 {
+		t.Fatalf("Expected delta against previous deployment to be reported")
+	}
+	last := logs[len(logs)-1]
+	if !last.Error || last.Output != model.StageEnd {
+		t.Fatalf("Expected task to fail, got %+v", logs)
+	}
+	var reported bool
+	for _, l := range logs {
+		reported = reported || l.Error && strings.Contains(l.Output, "delta is against previous")
+	}
+	if !reported {
+		t.Fatalf("Expected mismatch to be logged, got %+v", logs)
+	}
+}
@@ -0,0 +1,223 @@
+// Package docker runs application containers through the Docker Engine API
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	StopTimeout = 10 * time.Second // grace period before the container is killed
+)
+
+// LineFunc receives a line of output. stderr is true for lines from standard error
+type LineFunc func(line string, stderr bool)
+
+type Engine struct {
+	cli *client.Client
+	ctx context.Context
+}
+
+// NewEngine returns a client for the Docker Engine at host e.g. unix:///var/run/docker.sock
+//	If host is empty, the DOCKER_HOST env variable or the platform default is used.
+func NewEngine(host string) (*Engine, error) {
+	ops := []func(*client.Client) error{client.FromEnv}
+	if host != "" {
+		ops = append(ops, client.WithHost(host))
+	}
+	cli, err := client.NewClientWithOpts(ops...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating docker client: %s", err)
+	}
+
+	e := &Engine{
+		cli: cli,
+		ctx: context.Background(),
+	}
+	// downgrade the API version for older engines
+	cli.NegotiateAPIVersion(e.ctx)
+
+	return e, nil
+}
+
+// Pull pulls the image and passes the progress to lineFunc
+func (e *Engine) Pull(ref string, lineFunc LineFunc) error {
+	reader, err := e.cli.ImagePull(e.ctx, ref, types.ImagePullOptions{})
+	if err != nil {
+		return fmt.Errorf("error pulling image: %s", err)
+	}
+	defer reader.Close()
+
+	// the response is a stream of json messages
+	decoder := json.NewDecoder(reader)
+	for {
+		var message struct {
+			ID       string `json:"id"`
+			Status   string `json:"status"`
+			Progress string `json:"progress"`
+			Error    string `json:"error"`
+		}
+		err := decoder.Decode(&message)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error decoding pull progress: %s", err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("error pulling image: %s", message.Error)
+		}
+		// skip intermediate progress reports
+		if message.Progress != "" {
+			continue
+		}
+		if message.ID != "" {
+			lineFunc(message.ID+": "+message.Status, false)
+		} else {
+			lineFunc(message.Status, false)
+		}
+	}
+}
+
+// Start creates and starts a container with the given name, replacing any existing container with the same name
+func (e *Engine) Start(name string, spec *model.Container) (id string, err error) {
+	err = e.Remove(name)
+	if err != nil {
+		return "", err
+	}
+
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(spec.Ports)
+	if err != nil {
+		return "", fmt.Errorf("error parsing ports: %s", err)
+	}
+
+	config := &container.Config{
+		Image:        spec.Ref(),
+		Env:          spec.Env,
+		ExposedPorts: exposedPorts,
+	}
+	hostConfig := &container.HostConfig{
+		Binds:         spec.Volumes,
+		PortBindings:  portBindings,
+		RestartPolicy: container.RestartPolicy{Name: spec.Restart},
+	}
+
+	created, err := e.cli.ContainerCreate(e.ctx, config, hostConfig, nil, name)
+	if err != nil {
+		return "", fmt.Errorf("error creating container: %s", err)
+	}
+
+	err = e.cli.ContainerStart(e.ctx, created.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error starting container: %s", err)
+	}
+	return created.ID, nil
+}
+
+// Follow passes the container logs to lineFunc until the container is stopped, removed,
+// or has exited without being restarted. It returns the last exit code of the container.
+func (e *Engine) Follow(id string, lineFunc LineFunc) (exitCode int, err error) {
+	var since string
+	for {
+		start := time.Now()
+		err = e.streamLogs(id, since, lineFunc)
+		if err != nil {
+			return 0, err
+		}
+		since = strconv.FormatInt(start.Unix(), 10)
+
+		inspect, err := e.cli.ContainerInspect(e.ctx, id)
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				return 0, fmt.Errorf("container was removed")
+			}
+			return 0, fmt.Errorf("error inspecting container: %s", err)
+		}
+		if inspect.State == nil {
+			return 0, fmt.Errorf("container has no state")
+		}
+		if !inspect.State.Running && !inspect.State.Restarting {
+			return inspect.State.ExitCode, nil
+		}
+		// restarted by the engine, follow the new logs
+		time.Sleep(time.Second)
+	}
+}
+
+func (e *Engine) streamLogs(id, since string, lineFunc LineFunc) error {
+	reader, err := e.cli.ContainerLogs(e.ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Since:      since,
+	})
+	if err != nil {
+		return fmt.Errorf("error getting container logs: %s", err)
+	}
+	defer reader.Close()
+
+	outReader, outWriter := io.Pipe()
+	errReader, errWriter := io.Pipe()
+
+	var wg sync.WaitGroup
+	scan := func(r io.Reader, stderr bool) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lineFunc(scanner.Text(), stderr)
+		}
+		// drain to avoid blocking the demultiplexer
+		io.Copy(ioutil.Discard, r)
+	}
+	wg.Add(2)
+	go scan(outReader, false)
+	go scan(errReader, true)
+
+	// demultiplex stdout and stderr streams
+	_, err = stdcopy.StdCopy(outWriter, errWriter, reader)
+	outWriter.Close()
+	errWriter.Close()
+	wg.Wait()
+	if err != nil {
+		return fmt.Errorf("error reading container logs: %s", err)
+	}
+	return nil
+}
+
+// Remove stops and removes the container with the given name or ID. It is not an error if the container does not exist
+func (e *Engine) Remove(nameOrID string) error {
+	// stop does not report missing containers as not found, inspect first
+	inspect, err := e.cli.ContainerInspect(e.ctx, nameOrID)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error inspecting container: %s", err)
+	}
+	if inspect.State != nil && (inspect.State.Running || inspect.State.Restarting) {
+		timeout := StopTimeout
+		err = e.cli.ContainerStop(e.ctx, nameOrID, &timeout)
+		if err != nil {
+			return fmt.Errorf("error stopping container: %s", err)
+		}
+	}
+	err = e.cli.ContainerRemove(e.ctx, nameOrID, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("error removing container: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// fakeEngine emulates the parts of Docker Engine API used by the agent
+type fakeEngine struct {
+	sync.Mutex
+	pulled     []string
+	created    map[string]container.Config // name: config
+	hostConfig map[string]container.HostConfig
+	removed    []string
+}
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	path := apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case path == "/_ping":
+		w.Header().Set("API-Version", "1.38")
+		w.Write([]byte("OK"))
+	case path == "/images/create" && r.Method == http.MethodPost:
+		f.pulled = append(f.pulled, r.URL.Query().Get("fromImage")+":"+r.URL.Query().Get("tag"))
+		w.Write([]byte(`{"status":"Pulling from library/app","id":"latest"}` + "\n"))
+		w.Write([]byte(`{"status":"Downloading","progress":"[=>   ]","id":"abc"}` + "\n"))
+		w.Write([]byte(`{"status":"Status: Downloaded newer image"}` + "\n"))
+	case path == "/containers/create" && r.Method == http.MethodPost:
+		var body struct {
+			container.Config
+			HostConfig container.HostConfig
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		name := r.URL.Query().Get("name")
+		f.created[name] = body.Config
+		f.hostConfig[name] = body.HostConfig
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"` + name + `-id"}`))
+	case len(parts) == 3 && parts[2] == "start":
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[2] == "logs":
+		stdout := stdcopy.NewStdWriter(w, stdcopy.Stdout)
+		stderr := stdcopy.NewStdWriter(w, stdcopy.Stderr)
+		stdout.Write([]byte("hello 1\nhello 2\n"))
+		stderr.Write([]byte("warning\n"))
+	case len(parts) == 3 && parts[2] == "json":
+		if _, found := f.created[strings.TrimSuffix(parts[1], "-id")]; !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    parts[1],
+			State: &types.ContainerState{Status: "exited", ExitCode: 3},
+		}})
+	case len(parts) == 3 && parts[2] == "stop":
+		if _, found := f.created[strings.TrimSuffix(parts[1], "-id")]; !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if _, found := f.created[strings.TrimSuffix(parts[1], "-id")]; !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container"}`))
+			return
+		}
+		f.removed = append(f.removed, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestDockerEngine(t *testing.T) {
+	fake := &fakeEngine{
+		created:    make(map[string]container.Config),
+		hostConfig: make(map[string]container.HostConfig),
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	engine, err := NewEngine("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Error creating engine: %s", err)
+	}
+
+	spec := &model.Container{
+		Image:   "app",
+		Tag:     "1.0",
+		Env:     []string{"A=1"},
+		Ports:   []string{"8080:80"},
+		Volumes: []string{"/data:/data:ro"},
+		Restart: "on-failure",
+	}
+
+	t.Run("pull", func(t *testing.T) {
+		var lines []string
+		err := engine.Pull(spec.Ref(), func(line string, stderr bool) {
+			lines = append(lines, line)
+		})
+		if err != nil {
+			t.Fatalf("Error pulling: %s", err)
+		}
+		if len(fake.pulled) != 1 || fake.pulled[0] != "app:1.0" {
+			t.Fatalf("Expected app:1.0 to be pulled, got: %v", fake.pulled)
+		}
+		expected := []string{"latest: Pulling from library/app", "Status: Downloaded newer image"}
+		if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+			t.Fatalf("Unexpected pull progress: %q", lines)
+		}
+	})
+
+	t.Run("start", func(t *testing.T) {
+		id, err := engine.Start("deployment-test", spec)
+		if err != nil {
+			t.Fatalf("Error starting: %s", err)
+		}
+		if id != "deployment-test-id" {
+			t.Fatalf("Unexpected container id: %s", id)
+		}
+		config := fake.created["deployment-test"]
+		if config.Image != "app:1.0" || len(config.Env) != 1 || config.Env[0] != "A=1" {
+			t.Fatalf("Unexpected container config: %+v", config)
+		}
+		hostConfig := fake.hostConfig["deployment-test"]
+		if hostConfig.RestartPolicy.Name != "on-failure" || len(hostConfig.Binds) != 1 || len(hostConfig.PortBindings) != 1 {
+			t.Fatalf("Unexpected host config: %+v", hostConfig)
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		var stdout, stderr []string
+		exitCode, err := engine.Follow("deployment-test-id", func(line string, isErr bool) {
+			if isErr {
+				stderr = append(stderr, line)
+			} else {
+				stdout = append(stdout, line)
+			}
+		})
+		if err != nil {
+			t.Fatalf("Error following: %s", err)
+		}
+		if exitCode != 3 {
+			t.Fatalf("Expected exit code 3, got %d", exitCode)
+		}
+		if strings.Join(stdout, ",") != "hello 1,hello 2" || strings.Join(stderr, ",") != "warning" {
+			t.Fatalf("Unexpected logs: stdout=%q stderr=%q", stdout, stderr)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		err := engine.Remove("deployment-test-id")
+		if err != nil {
+			t.Fatalf("Error removing: %s", err)
+		}
+		if len(fake.removed) != 1 || fake.removed[0] != "deployment-test-id" {
+			t.Fatalf("Expected container to be removed, got: %v", fake.removed)
+		}
+		err = engine.Remove("missing")
+		if err != nil {
+			t.Fatalf("Expected no error for missing container, got: %s", err)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/swarmio"
+)
+
+const (
+	DownloadAttempts      = 5
+	DownloadRetryInterval = 10 * time.Second
+)
+
+// downloadArtifacts fetches the artifacts of the task from the manager, resuming interrupted downloads
+func (a *agent) downloadArtifacts(task *model.Task) error {
+	cert, err := swarmio.LoadCert()
+	if err != nil {
+		return fmt.Errorf("error loading certificate: %s", err)
+	}
+
+	err = os.MkdirAll(fmt.Sprintf("%s/tasks", WorkDir), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating tasks directory: %s", err)
+	}
+	path := fmt.Sprintf("%s/tasks/%s.download", WorkDir, task.ID)
+	url := a.target.ManagerAddr + task.Download.URL
+
+	for attempt := 1; ; attempt++ {
+		err = a.download(url, path, task.Download.Size, cert)
+		if err == nil {
+			break
+		}
+		if attempt == DownloadAttempts {
+			return err
+		}
+		log.Printf("Download attempt %d/%d failed: %s", attempt, DownloadAttempts, err)
+		time.Sleep(DownloadRetryInterval)
+	}
+
+	task.Artifacts, err = ioutil.ReadFile(path)
+	os.Remove(path) // start over if the artifacts are invalid
+	if err != nil {
+		return fmt.Errorf("error reading artifacts: %s", err)
+	}
+	if !bytes.Equal(task.Digest, task.TaskDigest()) {
+		return fmt.Errorf("digest of downloaded artifacts does not match")
+	}
+	return nil
+}
+
+// download appends the remaining bytes to the partially downloaded file
+func (a *agent) download(url, path string, size int64, cert swarmio.Cert) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset == size {
+		return nil
+	}
+	if offset > size {
+		err = f.Truncate(0)
+		if err != nil {
+			return err
+		}
+		offset, _ = f.Seek(0, io.SeekStart)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %s", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	err = swarmio.SignRequest(req, a.target.ID, cert)
+	if err != nil {
+		return fmt.Errorf("error signing request: %s", err)
+	}
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		log.Printf("Resuming download at %d/%d bytes", offset, size)
+	case http.StatusOK:
+		// range is not supported, start over
+		err = f.Truncate(0)
+		if err != nil {
+			return err
+		}
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("error downloading artifacts: %s", resp.Status)
+	}
+
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		return fmt.Errorf("error downloading artifacts: %s", err)
+	}
+	return nil
+}
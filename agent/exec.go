@@ -21,6 +21,10 @@ type executor struct {
 	logEnqueue enqueueFunc
 	cmd        *exec.Cmd
 	debug      bool
+	// secrets of the task
+	env        []string
+	redact     []string
+	secretsErr error
 }
 
 func newExecutor(task, stage string, logEnqueue enqueueFunc, debug bool) *executor {
@@ -36,20 +40,31 @@ func newExecutor(task, stage string, logEnqueue enqueueFunc, debug bool) *execut
 	// force Python std streams to be unbuffered
 	os.Setenv("PYTHONUNBUFFERED", "1")
 
-	return &executor{
+	e := &executor{
 		workDir:    wd,
 		task:       task,
 		stage:      stage,
 		logEnqueue: logEnqueue,
 		debug:      debug,
 	}
+	if task != model.TaskTerminal {
+		e.env, e.redact, e.secretsErr = loadTaskSecrets(task)
+	}
+	return e
 }
 
 // execute executes a command
 func (e *executor) execute(command string) (success bool) {
 	e.sendLog(command, model.ExecStart, false)
+	if e.secretsErr != nil {
+		e.sendLogFatal(command, e.secretsErr.Error())
+		return false
+	}
 
 	e.cmd = exec.Command("/bin/sh", "-c", command)
+	if len(e.env) > 0 {
+		e.cmd.Env = append(os.Environ(), e.env...)
+	}
 
 	defer func() { e.cmd = nil }()
 
@@ -102,6 +117,9 @@ func (e *executor) execute(command string) (success bool) {
 }
 
 func (e *executor) sendLog(command, output string, error bool) {
+	if len(e.redact) > 0 {
+		command, output = redactSecrets(command, e.redact), redactSecrets(output, e.redact)
+	}
 	e.logEnqueue(&model.Log{e.task, e.stage, command, output, error, model.UnixTime(), e.debug})
 }
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"sync/atomic"
 
 	"code.linksmart.eu/dt/deployment-tool/manager/model"
 )
@@ -9,6 +10,7 @@ import (
 type installer struct {
 	logEnqueue enqueueFunc
 	executor   *executor
+	active     int32 // accessed atomically
 }
 
 func newInstaller(logEnqueue enqueueFunc) installer {
@@ -23,11 +25,23 @@ func (i *installer) install(commands []string, mode, taskID string, debug bool)
 	// nothing to execute
 	if len(commands) == 0 {
 		log.Printf("installer: Nothing to execute.")
-		//i.sendLog(mode, taskID, model.StageEnd, false, debug)
+		// close the stage started by the manager
+		if mode == model.StageInstall {
+			i.sendLog(mode, taskID, model.StageEnd, false, debug)
+		}
 		return true
 	}
 
-	log.Printf("installer: Installing task: %s", taskID)
+	log.Printf("installer: Installing task: %s (%s)", taskID, mode)
+
+	// start of hooks are logged by the agent, other stages by the manager
+	hook := mode != model.StageInstall && mode != model.StageBuild
+	if hook {
+		i.sendLog(mode, taskID, model.StageStart, false, debug)
+	}
+
+	atomic.StoreInt32(&i.active, 1)
+	defer atomic.StoreInt32(&i.active, 0)
 
 	// execute sequentially, return if one fails
 	i.executor = newExecutor(taskID, mode, i.logEnqueue, debug)
@@ -40,12 +54,17 @@ func (i *installer) install(commands []string, mode, taskID string, debug bool)
 	}
 
 	log.Printf("installer: Install ended.")
-	if mode == model.StageInstall {
+	if mode == model.StageInstall || hook {
 		i.sendLog(mode, taskID, model.StageEnd, false, debug)
 	}
 	return true
 }
 
+// installing returns true if an install or build command is being executed
+func (i *installer) installing() bool {
+	return atomic.LoadInt32(&i.active) == 1
+}
+
 func (i *installer) sendLog(mode, task, output string, error bool, debug bool) {
 	i.logEnqueue(&model.Log{task, mode, model.CommandByAgent, output, error, model.UnixTime(), debug})
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+)
+
+const (
+	UnixSocketPrefix = "unix:" // prefix of local API address for binding to a unix socket
+	// Run states
+	RunStateIdle       = "idle"
+	RunStateInstalling = "installing"
+	RunStateRunning    = "running"
+)
+
+// localAPI serves the agent status to local clients, without involving the manager
+type localAPI struct {
+	agent    *agent
+	listener net.Listener
+}
+
+type localStatus struct {
+	model.TargetBase
+	Registered  bool   `json:"registered"`
+	ManagerAddr string `json:"managerAddr"`
+	Connected   bool   `json:"connected"`
+	RunState    string `json:"runState"`
+	Task        struct {
+		ID          string   `json:"id"`
+		Debug       bool     `json:"debug"`
+		Run         []string `json:"run"`
+		AutoRestart bool     `json:"autoRestart"`
+	} `json:"task"`
+}
+
+// startLocalAPI binds to a TCP address (e.g. localhost:8081) or a unix socket (e.g. unix:/var/run/agent.sock)
+func startLocalAPI(addr string, agent *agent) (*localAPI, error) {
+	var err error
+	a := &localAPI{
+		agent: agent,
+	}
+
+	if strings.HasPrefix(addr, UnixSocketPrefix) {
+		path := strings.TrimPrefix(addr, UnixSocketPrefix)
+		// remove the socket file left over from a previous run
+		if _, err := os.Stat(path); err == nil {
+			err = os.Remove(path)
+			if err != nil {
+				return nil, fmt.Errorf("error removing old socket: %s", err)
+			}
+		}
+		a.listener, err = net.Listen("unix", path)
+	} else {
+		a.listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %s", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", a.getStatus)
+	mux.HandleFunc("/logs", a.getLogs)
+	mux.HandleFunc("/stop", a.stop)
+	mux.HandleFunc("/advertise", a.advertise)
+
+	log.Println("localapi: Binding to", addr)
+	go func() {
+		err := http.Serve(a.listener, mux)
+		if err != nil {
+			log.Printf("localapi: %s", err)
+		}
+	}()
+
+	return a, nil
+}
+
+func (a *localAPI) getStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		localResponseError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := targetStatus(a.agent.target)
+	status.Connected = Connected()
+
+	switch {
+	case a.agent.installer.installing():
+		status.RunState = RunStateInstalling
+	case a.agent.runner.running():
+		status.RunState = RunStateRunning
+	default:
+		status.RunState = RunStateIdle
+	}
+
+	localResponse(w, http.StatusOK, status)
+}
+
+// targetStatus copies the fields of the target, which are updated by the agent while being served
+func targetStatus(t *target) localStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var status localStatus
+	status.ID = t.ID
+	status.Tags = t.Tags
+	status.Location = t.Location
+	status.PublicKey = t.PublicKey
+	status.Registered = t.Registered
+	status.ManagerAddr = t.ManagerAddr
+	status.Task.ID = t.TaskID
+	status.Task.Debug = t.TaskDebug
+	status.Task.Run = t.TaskRun
+	status.Task.AutoRestart = t.TaskRunAutoRestart
+	return status
+}
+
+func (a *localAPI) getLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		localResponseError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	localResponse(w, http.StatusOK, a.agent.logger.buffer.Collect())
+}
+
+func (a *localAPI) stop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		localResponseError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	log.Println("localapi: Received stop request")
+	a.agent.stopAll()
+	localResponse(w, http.StatusOK, map[string]string{"message": "stopped"})
+}
+
+func (a *localAPI) advertise(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		localResponseError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if !Connected() {
+		localResponseError(w, http.StatusServiceUnavailable, "not connected to manager")
+		return
+	}
+	log.Println("localapi: Received advertisement request")
+	a.agent.sendAdvertisement()
+	localResponse(w, http.StatusOK, map[string]string{"message": "sent advertisement"})
+}
+
+func (a *localAPI) close() {
+	log.Println("localapi: Shutting down...")
+	err := a.listener.Close()
+	if err != nil {
+		log.Printf("localapi: Error closing listener: %s", err)
+	}
+}
+
+func localResponse(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		localResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err = w.Write(b)
+	if err != nil {
+		log.Printf("localapi: Error writing response: %s", err)
+	}
+}
+
+// localResponseError writes an error response. If no message is provided, the status text will be used
+func localResponseError(w http.ResponseWriter, code int, message ...interface{}) {
+	if len(message) == 0 {
+		message = []interface{}{http.StatusText(code)}
+	}
+	b, _ := json.Marshal(map[string]string{"error": fmt.Sprint(message...)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
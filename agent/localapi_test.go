@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+)
+
+func TestLocalStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(wd string) { WorkDir = wd }(WorkDir)
+	WorkDir = dir
+	os.MkdirAll(dir+"/tasks/task/src", 0755)
+
+	enqueue := func(*model.Log) {}
+	a := &agent{
+		target:    &target{},
+		installer: newInstaller(enqueue),
+		runner:    newRunner(enqueue),
+	}
+	a.target.ID = "gw"
+	a.target.TaskID = "previous"
+	api := &localAPI{agent: a}
+
+	getStatus := func() localStatus {
+		w := httptest.NewRecorder()
+		api.getStatus(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+		}
+		var status localStatus
+		err := json.Unmarshal(w.Body.Bytes(), &status)
+		if err != nil {
+			t.Fatalf("Error parsing status: %s", err)
+		}
+		return status
+	}
+
+	status := getStatus()
+	if status.ID != "gw" || status.Task.ID != "previous" || status.RunState != RunStateIdle || status.Connected {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+	w := httptest.NewRecorder()
+	api.getStatus(w, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405, got %d", w.Code)
+	}
+
+	// status is served while a task is installed and the target is updated
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a.installer.install([]string{"sleep 0.5"}, model.StageInstall, "task", false)
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			a.target.mutex.Lock()
+			a.target.TaskID = fmt.Sprintf("task-%d", i)
+			a.target.TaskRun = []string{"./app"}
+			a.target.Tags = []string{fmt.Sprintf("tag-%d", i)}
+			a.target.mutex.Unlock()
+		}
+	}()
+	var installing bool
+	for start := time.Now(); time.Since(start) < 2*time.Second && !installing; {
+		installing = getStatus().RunState == RunStateInstalling
+	}
+	if !installing {
+		t.Fatalf("Expected installing state")
+	}
+	wg.Wait()
+
+	status = getStatus()
+	if status.RunState != RunStateIdle || status.Task.ID != "task-99" || len(status.Task.Run) != 1 || status.Tags[0] != "tag-99" {
+		t.Fatalf("Unexpected status after install: %+v", status)
+	}
+}
@@ -12,13 +12,20 @@ import (
 )
 
 const (
-	MemoryStorageCapacity  = 100             // number of logs kept in memory that can be queried
-	OutgoingBufferCapacity = 255             // number of logs collected before the next flush timeout
-	OutgoingFlushInterval  = 5 * time.Second // frequency of logs submissions to server
+	DefaultMemoryStorageCapacity  = 100             // number of logs kept in memory that can be queried
+	DefaultOutgoingBufferCapacity = 255             // number of logs collected before the next flush timeout
+	DefaultOutgoingFlushInterval  = 5 * time.Second // frequency of logs submissions to server
 )
 
+type logConf struct {
+	memoryCapacity uint8
+	bufferCapacity uint8
+	flushInterval  time.Duration
+}
+
 type logger struct {
 	targetID   string
+	conf       logConf
 	responseCh chan<- model.Message
 
 	buffer     buffer.Buffer
@@ -27,11 +34,12 @@ type logger struct {
 	tickerQuit chan struct{}
 }
 
-func newLogger(targetID string, responseCh chan<- model.Message) *logger {
+func newLogger(targetID string, conf logConf, responseCh chan<- model.Message) *logger {
 	l := &logger{
 		targetID:   targetID,
+		conf:       conf,
 		responseCh: responseCh,
-		buffer:     buffer.NewBuffer(MemoryStorageCapacity),
+		buffer:     buffer.NewBuffer(conf.memoryCapacity),
 		tickerQuit: make(chan struct{}),
 		queue:      make(chan model.Log),
 	}
@@ -42,8 +50,8 @@ func newLogger(targetID string, responseCh chan<- model.Message) *logger {
 }
 
 func (l *logger) startTicker() {
-	l.ticker = time.NewTicker(OutgoingFlushInterval)
-	tickBuffer := buffer.NewBuffer(OutgoingBufferCapacity)
+	l.ticker = time.NewTicker(l.conf.flushInterval)
+	tickBuffer := buffer.NewBuffer(l.conf.bufferCapacity)
 	for {
 		select {
 		case logM := <-l.queue:
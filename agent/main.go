@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"code.linksmart.eu/dt/deployment-tool/manager/env"
 	"code.linksmart.eu/dt/deployment-tool/manager/zeromq"
@@ -12,11 +13,25 @@ import (
 
 const (
 	// Environment keys
-	EnvPrivateKey  = "PRIVATE_KEY"  // path to private key of agent
-	EnvPublicKey   = "PUBLIC_KEY"   // path to public key of agent
+	EnvPrivateKey  = "PRIVATE_KEY" // path to private key of agent
+	EnvPublicKey   = "PUBLIC_KEY"  // path to public key of agent
 	EnvManagerAddr = "MANAGER_ADDR"
 	EnvAuthToken   = "AUTH_TOKEN"
+	EnvLocalAPI    = "LOCAL_API_ADDR" // optional local API address e.g. localhost:8081 or unix:/var/run/agent.sock
+	EnvConfigFile  = "CONFIG_FILE"    // path to agent config file
+	EnvWorkDir     = "WORKDIR"        // work directory of the agent
+	EnvID          = "ID"
+	EnvTags        = "TAGS" // comma-separated
+	EnvLocationLat = "LOCATION_LAT"
+	EnvLocationLon = "LOCATION_LON"
+	// Logger settings
+	EnvLogMemoryCapacity = "LOG_MEMORY_CAPACITY" // number of logs kept in memory
+	EnvLogBufferCapacity = "LOG_BUFFER_CAPACITY" // number of logs collected between flushes
+	EnvLogFlushInterval  = "LOG_FLUSH_INTERVAL"  // duration between log submissions e.g. 5s
+	// Security settings
+	EnvAllowUnsigned = "ALLOW_UNSIGNED_TASKS" // 1 to accept tasks when the manager key is unknown
 	// Default values
+	DefaultConfigFile     = "./agent.yml"  // optional config file
 	DefaultStateFile      = "./state.json" // path to agent state file
 	DefaultPrivateKeyPath = "./agent.key"
 	DefaultPublicKeyPath  = "./agent.pub"
@@ -25,6 +40,23 @@ const (
 var WorkDir = "."
 
 func main() {
+	confFile, err := confFilePath()
+	if err != nil {
+		log.Fatalf("Error resolving config file path: %s.", err)
+	}
+	if confFile != "" {
+		err = loadConfFile(confFile)
+		if err != nil {
+			log.Fatalf("Error loading config file: %s.", err)
+		}
+	}
+	if dir := os.Getenv(EnvWorkDir); dir != "" {
+		err = os.Chdir(dir)
+		if err != nil {
+			log.Fatalf("Error changing work directory: %s.", err)
+		}
+	}
+
 	parseFlags()
 
 	log.Println("STARTED DEPLOYMENT AGENT")
@@ -49,10 +81,27 @@ func main() {
 		log.Fatalf("Error starting ZeroMQ client: %s.", err)
 	}
 
+	var api *localAPI
+	if addr := os.Getenv(EnvLocalAPI); addr != "" {
+		api, err = startLocalAPI(addr, agent)
+		if err != nil {
+			log.Fatalf("Error starting local API: %s.", err)
+		}
+	}
+
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, os.Kill)
-	<-sig
+	signal.Notify(sig, os.Interrupt, os.Kill, syscall.SIGHUP)
+	for s := range sig {
+		if s == syscall.SIGHUP {
+			agent.reloadConf(confFile)
+			continue
+		}
+		break
+	}
 
+	if api != nil {
+		api.close()
+	}
 	agent.close()
 	zmqClient.close()
 }
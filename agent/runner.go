@@ -3,36 +3,69 @@ package main
 import (
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"code.linksmart.eu/dt/deployment-tool/manager/model"
 )
 
+const (
+	PostRunTimeout = 30 * time.Second // max wait for the post-run hook when stopping
+)
+
+// runSpec holds what is run for a task along with the hooks
+type runSpec struct {
+	commands  []string
+	container *model.Container
+	postRun   []string
+	stop      []string
+}
+
 type runner struct {
 	logEnqueue enqueueFunc
 	executors  []*executor
+	container  *containerExecutor
 	wg         sync.WaitGroup
+	active     int32 // accessed atomically
+	// hooks of the active task, with an installer each as they may run concurrently
+	stopHook     installer
+	postRunHook  installer
+	taskID       string
+	debug        bool
+	stopCommands []string
+	done         chan struct{} // closed when run returns
 }
 
 func newRunner(logEnqueue enqueueFunc) runner {
 	return runner{
-		logEnqueue: logEnqueue,
+		logEnqueue:  logEnqueue,
+		stopHook:    newInstaller(logEnqueue),
+		postRunHook: newInstaller(logEnqueue),
 	}
 }
 
-func (r *runner) run(commands []string, taskID string, debug bool) {
-	r.executors = make([]*executor, len(commands))
+func (r *runner) run(spec runSpec, taskID string, debug bool) {
+	r.executors = make([]*executor, len(spec.commands))
+	r.container = nil
 
 	// nothing to run
-	if len(commands) == 0 {
+	if len(spec.commands) == 0 && spec.container == nil {
 		return
 	}
 
+	r.taskID = taskID
+	r.debug = debug
+	r.stopCommands = spec.stop
+	r.done = make(chan struct{})
+	defer close(r.done)
+
 	log.Printf("runner: Running task: %s", taskID)
+	atomic.StoreInt32(&r.active, 1)
 	r.sendLog(taskID, model.StageStart, false, debug)
 
-	successCh := make(chan bool, len(commands))
+	successCh := make(chan bool, len(spec.commands)+1)
 	// run in parallel and wait for them to finish
-	for i, command := range commands {
+	for i, command := range spec.commands {
 		r.executors[i] = newExecutor(taskID, model.StageRun, r.logEnqueue, debug)
 		r.wg.Add(1)
 		go func(c string, e *executor) {
@@ -40,8 +73,17 @@ func (r *runner) run(commands []string, taskID string, debug bool) {
 			successCh <- e.execute(c)
 		}(command, r.executors[i])
 	}
+	if spec.container != nil {
+		r.container = newContainerExecutor(taskID, r.logEnqueue, debug)
+		r.wg.Add(1)
+		go func(c *model.Container, e *containerExecutor) {
+			defer r.wg.Done()
+			successCh <- e.execute(c)
+		}(spec.container, r.container)
+	}
 	r.wg.Wait()
 	close(successCh)
+	atomic.StoreInt32(&r.active, 0)
 
 	var endErr bool
 	for success := range successCh {
@@ -53,23 +95,47 @@ func (r *runner) run(commands []string, taskID string, debug bool) {
 
 	r.sendLog(taskID, model.StageEnd, endErr, debug)
 	log.Println("runner: All processes are ended.")
+
+	r.postRunHook.install(spec.postRun, model.StagePostRun, taskID, debug)
+}
+
+// running returns true if run commands of a task are being executed
+func (r *runner) running() bool {
+	return atomic.LoadInt32(&r.active) == 1
 }
 
 func (r *runner) sendLog(task, output string, error bool, debug bool) {
 	r.logEnqueue(&model.Log{task, model.StageRun, model.CommandByAgent, output, error, model.UnixTime(), debug})
 }
 
+// stop executes the stop hook, interrupts the processes and waits for the post-run hook
 func (r *runner) stop() (success bool) {
-	if len(r.executors) == 0 {
+	if len(r.executors) == 0 && r.container == nil {
 		return true
 	}
 	log.Println("runner: Shutting down...")
+	if r.running() {
+		r.stopHook.install(r.stopCommands, model.StageStop, r.taskID, r.debug)
+	}
+
 	success = true
 	for i := range r.executors {
 		if !r.executors[i].stop() {
 			success = false
 		}
 	}
+	if r.container != nil && !r.container.stop() {
+		success = false
+	}
 	log.Println("runner: Shutdown success:", success)
+
+	if r.done != nil {
+		select {
+		case <-r.done:
+		case <-time.After(PostRunTimeout):
+			log.Println("runner: Timeout waiting for post-run hook.")
+			r.postRunHook.stop()
+		}
+	}
 	return success
 }
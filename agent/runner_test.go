@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+)
+
+// TestRunnerHooks checks that the stop and post-run hooks keep their own stage when they overlap
+func TestRunnerHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(wd string) { WorkDir = wd }(WorkDir)
+	WorkDir = dir
+	os.MkdirAll(dir+"/tasks/task/src", 0755)
+
+	var mutex sync.Mutex
+	var logs []model.Log
+	r := newRunner(func(l *model.Log) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		logs = append(logs, *l)
+	})
+
+	// the process exits while the stop hook is executed, which starts the post-run hook
+	go r.run(runSpec{
+		commands: []string{"sleep 0.2"},
+		postRun:  []string{"sleep 0.3", "echo post-run"},
+		stop:     []string{"sleep 0.3", "echo stop"},
+	}, "task", false)
+	time.Sleep(100 * time.Millisecond)
+	if !r.running() {
+		t.Fatalf("Expected runner to be running")
+	}
+	r.stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	stages := make(map[string]string)
+	for _, l := range logs {
+		if l.Command == "echo stop" || l.Command == "echo post-run" {
+			stages[l.Command] = l.Stage
+		}
+	}
+	if stages["echo stop"] != model.StageStop || stages["echo post-run"] != model.StagePostRun {
+		t.Fatalf("Expected commands of hooks in their stages, got %v", stages)
+	}
+}
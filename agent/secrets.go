@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/secret"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/zeromq"
+)
+
+const (
+	SecretsFile     = "secrets.json" // in task directory, with values encrypted to the key of the agent
+	RedactedSecret  = "[REDACTED]"
+	maxSecretsCache = 10
+)
+
+// taskSecrets is stored in the task directory and opened by executors of the task
+type taskSecrets struct {
+	Refs   []model.SecretRef `json:"refs"`
+	Sealed []byte            `json:"sealed"`
+}
+
+// receiveSecrets keeps the encrypted secrets until the task arrives
+func (a *agent) receiveSecrets(s *model.TaskSecrets) {
+	log.Printf("Received secrets for task %s", s.Task)
+	a.secretsLocker.Lock()
+	defer a.secretsLocker.Unlock()
+	if len(a.secrets) >= maxSecretsCache {
+		a.secrets = make(map[string][]byte)
+	}
+	a.secrets[s.Task] = s.Sealed
+}
+
+// installSecrets stores the secrets of the task and writes the secret files into the task's execution directory
+func (a *agent) installSecrets(task *model.Task) error {
+	a.secretsLocker.Lock()
+	sealed, found := a.secrets[task.ID]
+	delete(a.secrets, task.ID)
+	a.secretsLocker.Unlock()
+	if !found {
+		return fmt.Errorf("secrets not received")
+	}
+
+	values, err := openSecrets(sealed)
+	if err != nil {
+		return err
+	}
+
+	taskDir := fmt.Sprintf("%s/tasks/%s", WorkDir, task.ID)
+	b, _ := json.Marshal(taskSecrets{Refs: task.Secrets, Sealed: sealed})
+	err = ioutil.WriteFile(fmt.Sprintf("%s/%s", taskDir, SecretsFile), b, 0600)
+	if err != nil {
+		return fmt.Errorf("error storing secrets: %s", err)
+	}
+
+	dir, _ := source.ExecDir(taskDir)
+	for _, ref := range task.Secrets {
+		if ref.File == "" {
+			continue
+		}
+		value, found := values[ref.Name]
+		if !found {
+			return fmt.Errorf("secret not received: %s", ref.Name)
+		}
+		path := secretFilePath(taskDir, dir, ref)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return fmt.Errorf("error creating directory for %s: %s", ref.File, err)
+		}
+		err = ioutil.WriteFile(path, []byte(value), 0600)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %s", ref.File, err)
+		}
+	}
+	return nil
+}
+
+// removeSecretFiles deletes the secret files from the task's execution directory, e.g. before sending a built package
+func removeSecretFiles(taskID string, refs []model.SecretRef) error {
+	taskDir := fmt.Sprintf("%s/tasks/%s", WorkDir, taskID)
+	dir, _ := source.ExecDir(taskDir)
+	for _, ref := range refs {
+		if ref.File == "" {
+			continue
+		}
+		err := os.Remove(secretFilePath(taskDir, dir, ref))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s: %s", ref.File, err)
+		}
+	}
+	return nil
+}
+
+func secretFilePath(taskDir, execDir string, ref model.SecretRef) string {
+	return fmt.Sprintf("%s/%s/%s", taskDir, execDir, filepath.Clean(ref.File))
+}
+
+// loadTaskSecrets returns the environment variables and values to redact for the task, if the task has secrets
+func loadTaskSecrets(taskID string) (env []string, redact []string, err error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("%s/tasks/%s/%s", WorkDir, taskID, SecretsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("error reading secrets: %s", err)
+	}
+	var s taskSecrets
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing secrets: %s", err)
+	}
+	values, err := openSecrets(s.Sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, ref := range s.Refs {
+		if ref.Env != "" {
+			env = append(env, ref.Env+"="+values[ref.Name])
+		}
+	}
+	for _, value := range values {
+		if value != "" {
+			redact = append(redact, value)
+		}
+	}
+	// replace longer values first, in case they contain others
+	sort.Slice(redact, func(i, j int) bool { return len(redact[i]) > len(redact[j]) })
+	return env, redact, nil
+}
+
+// openSecrets decrypts the secrets with the private key of the agent
+func openSecrets(sealed []byte) (map[string]string, error) {
+	encoded, err := zeromq.ReadKeyFile(os.Getenv(EnvPrivateKey), DefaultPrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key file: %s", err)
+	}
+	key, err := secret.ParseCurveKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding private key: %s", err)
+	}
+	b, err := secret.Open(key, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting secrets: %s", err)
+	}
+	var values map[string]string
+	err = json.Unmarshal(b, &values)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing secrets: %s", err)
+	}
+	return values, nil
+}
+
+func redactSecrets(s string, values []string) string {
+	for _, value := range values {
+		s = strings.Replace(s, value, RedactedSecret, -1)
+	}
+	return s
+}
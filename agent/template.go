@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+)
+
+// templateData holds the variables available in templates
+type templateData struct {
+	TargetID string
+	Tags     []string
+	Location model.Location
+	OrderID  string
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"hasTag": func(tags []string, tag string) bool {
+		for i := range tags {
+			if tags[i] == tag {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+func (a *agent) templateData(orderID string) templateData {
+	data := templateData{
+		TargetID: a.target.ID,
+		Tags:     a.target.Tags,
+		OrderID:  orderID,
+	}
+	if a.target.Location != nil {
+		data.Location = *a.target.Location
+	}
+	return data
+}
+
+// renderTask renders the commands and files of the task with variables of this target
+func (a *agent) renderTask(task *model.Task) error {
+	data := a.templateData(task.ID)
+
+	if task.Template.Commands {
+		var err error
+		if task.Build != nil {
+			task.Build.Commands, err = renderStrings(task.Build.Commands, data)
+			if err != nil {
+				return err
+			}
+		}
+		if d := task.Deploy; d != nil {
+			for _, commands := range []*[]string{&d.PreInstall.Commands, &d.Install.Commands, &d.Run.Commands, &d.PostRun.Commands, &d.Stop.Commands} {
+				*commands, err = renderStrings(*commands, data)
+				if err != nil {
+					return err
+				}
+			}
+			if d.Container != nil {
+				d.Container.Env, err = renderStrings(d.Container.Env, data)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if task.Deploy != nil && len(task.Template.Files) > 0 {
+		taskDir := fmt.Sprintf("%s/tasks/%s", WorkDir, task.ID)
+		dir, _ := source.ExecDir(taskDir)
+		for _, path := range task.Template.Files {
+			err := renderFile(fmt.Sprintf("%s/%s/%s", taskDir, dir, filepath.Clean(path)), data)
+			if err != nil {
+				return fmt.Errorf("error rendering %s: %s", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func renderStrings(in []string, data templateData) ([]string, error) {
+	out := make([]string, len(in))
+	for i := range in {
+		s, err := render(in[i], data)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+func render(text string, data templateData) (string, error) {
+	t, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing template: %s", err)
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", fmt.Errorf("error executing template: %s", err)
+	}
+	return b.String(), nil
+}
+
+// renderFile replaces the file with the rendered one, keeping the permissions
+func renderFile(path string, data templateData) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rendered, err := render(string(b), data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(rendered), info.Mode())
+}
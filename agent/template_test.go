@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+)
+
+func TestRender(t *testing.T) {
+	data := templateData{
+		TargetID: "gw-1",
+		Tags:     []string{"gateway", "floor-2"},
+		Location: model.Location{Lat: 52.5, Lon: 13.4},
+		OrderID:  "order",
+	}
+	cases := map[string]string{
+		"./app --id {{.TargetID}}":                          "./app --id gw-1",
+		"{{join .Tags \",\"}}":                              "gateway,floor-2",
+		"{{if hasTag .Tags \"gateway\"}}gw{{else}}x{{end}}": "gw",
+		"{{if hasTag .Tags \"sensor\"}}s{{else}}x{{end}}":   "x",
+		"{{.Location.Lat}},{{.Location.Lon}}":               "52.5,13.4",
+		"order={{.OrderID}}":                                "order=order",
+		"no template":                                       "no template",
+	}
+	for text, expected := range cases {
+		rendered, err := render(text, data)
+		if err != nil {
+			t.Fatalf("Error rendering %s: %s", text, err)
+		}
+		if rendered != expected {
+			t.Fatalf("Rendered %s as %q instead of %q", text, rendered, expected)
+		}
+	}
+
+	for _, text := range []string{"{{.Unknown}}", "{{.TargetID", "{{unknown .Tags}}"} {
+		if _, err := render(text, data); err == nil {
+			t.Fatalf("Expected error rendering %s", text)
+		}
+	}
+}
+
+func TestRenderFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := dir + "/run.sh"
+	ioutil.WriteFile(path, []byte("./app --id {{.TargetID}}"), 0750)
+	os.Chmod(path, 0750)
+	err = renderFile(path, templateData{TargetID: "gw-1"})
+	if err != nil {
+		t.Fatalf("Error rendering file: %s", err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != "./app --id gw-1" {
+		t.Fatalf("Unexpected rendered file: %s", b)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0750 {
+		t.Fatalf("Mode changed to %s", info.Mode().Perm())
+	}
+
+	// invalid templates leave the file as it is
+	ioutil.WriteFile(path, []byte("{{.Unknown}}"), 0750)
+	if err := renderFile(path, templateData{}); err == nil {
+		t.Fatalf("Expected error for unknown variable")
+	}
+	b, _ = ioutil.ReadFile(path)
+	if string(b) != "{{.Unknown}}" {
+		t.Fatalf("File is modified after error: %s", b)
+	}
+	if err := renderFile(dir+"/missing", templateData{}); err == nil {
+		t.Fatalf("Expected error for missing file")
+	}
+}
+
+func TestRenderTask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(wd string) { WorkDir = wd }(WorkDir)
+	WorkDir = dir
+
+	a := &agent{target: &target{}}
+	a.target.ID = "gw-1"
+	a.target.Tags = []string{"gateway"}
+	a.target.Location = &model.Location{Lat: 1, Lon: 2}
+
+	taskDir := fmt.Sprintf("%s/tasks/task/%s", dir, source.SourceDir)
+	os.MkdirAll(taskDir+"/conf", 0755)
+	ioutil.WriteFile(taskDir+"/conf/app.yml", []byte("id: {{.TargetID}}\nlocation: {{.Location.Lat}}"), 0644)
+	ioutil.WriteFile(taskDir+"/static.yml", []byte("id: {{.TargetID}}"), 0644)
+
+	task := &model.Task{
+		Header:   model.Header{ID: "task"},
+		Deploy:   &model.Deploy{},
+		Template: &model.Template{Commands: true, Files: []string{"./conf/app.yml"}},
+	}
+	task.Deploy.Install.Commands = []string{"./install.sh {{.TargetID}}"}
+	task.Deploy.Run.Commands = []string{"./app --order {{.OrderID}}"}
+	task.Deploy.Stop.Commands = []string{"echo {{join .Tags \" \"}}"}
+	err = a.renderTask(task)
+	if err != nil {
+		t.Fatalf("Error rendering task: %s", err)
+	}
+	if task.Deploy.Install.Commands[0] != "./install.sh gw-1" || task.Deploy.Run.Commands[0] != "./app --order task" || task.Deploy.Stop.Commands[0] != "echo gateway" {
+		t.Fatalf("Unexpected commands: %+v", task.Deploy)
+	}
+	b, _ := ioutil.ReadFile(taskDir + "/conf/app.yml")
+	if string(b) != "id: gw-1\nlocation: 1" {
+		t.Fatalf("Unexpected rendered file: %s", b)
+	}
+	b, _ = ioutil.ReadFile(taskDir + "/static.yml")
+	if string(b) != "id: {{.TargetID}}" {
+		t.Fatalf("File not listed in template.files is rendered: %s", b)
+	}
+
+	// commands are only rendered when enabled
+	task.Template = &model.Template{}
+	task.Deploy.Run.Commands = []string{"echo {{.TargetID}}"}
+	err = a.renderTask(task)
+	if err != nil || task.Deploy.Run.Commands[0] != "echo {{.TargetID}}" {
+		t.Fatalf("Commands are rendered without template.commands: %v %s", task.Deploy.Run.Commands, err)
+	}
+
+	// build commands
+	build := &model.Task{
+		Header:   model.Header{ID: "build"},
+		Build:    &model.Build{Commands: []string{"make TARGET={{.TargetID}}"}},
+		Template: &model.Template{Commands: true},
+	}
+	err = a.renderTask(build)
+	if err != nil || build.Build.Commands[0] != "make TARGET=gw-1" {
+		t.Fatalf("Unexpected build commands: %v %s", build.Build.Commands, err)
+	}
+
+	task.Template = &model.Template{Files: []string{"missing.yml"}}
+	if err := a.renderTask(task); err == nil {
+		t.Fatalf("Expected error for missing template file")
+	}
+}
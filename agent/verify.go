@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/swarmio"
+)
+
+// verifyArtifacts checks the digest of the task and artifacts and its signature by the manager
+//	Tasks are refused when the manager key is unknown, e.g. for targets registered without token, unless unsigned tasks are allowed.
+func verifyArtifacts(task *model.Task) error {
+	cert, err := swarmio.LoadCert()
+	if os.IsNotExist(err) || err == nil && len(cert.CA) == 0 {
+		if os.Getenv(EnvAllowUnsigned) == "1" {
+			log.Printf("Unable to verify %s: manager key is unknown. Accepting unsigned task.", task.ID)
+			return nil
+		}
+		return fmt.Errorf("manager key is unknown. Register with a token or set %s=1 to accept unsigned tasks", EnvAllowUnsigned)
+	}
+	if err != nil {
+		return fmt.Errorf("error loading manager key: %s", err)
+	}
+
+	if len(task.Signature) == 0 {
+		return fmt.Errorf("task is not signed")
+	}
+	if !bytes.Equal(task.Digest, task.TaskDigest()) {
+		return fmt.Errorf("digest of task does not match")
+	}
+	if !swarmio.Verify(cert.CA, task.Digest, task.Signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
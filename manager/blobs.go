@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+)
+
+const (
+	GCInterval = time.Hour
+	// order directories without order are kept this long, as orders are stored after fetching the source
+	orphanGracePeriod = time.Hour
+)
+
+// retention is the policy for keeping files of stored orders
+//	Files of the latest orders, orders created within the period, and deployments of targets are kept.
+//	Files of all stored orders are kept if neither orders nor period is set.
+type retention struct {
+	orders int
+	period time.Duration
+}
+
+// loadRetention reads the retention policy from env variables
+func loadRetention() (retention, error) {
+	var r retention
+	if v := os.Getenv(EnvRetainOrders); v != "" {
+		orders, err := strconv.Atoi(v)
+		if err != nil {
+			return r, fmt.Errorf("error parsing %s: %s", EnvRetainOrders, err)
+		}
+		if orders <= 0 {
+			return r, fmt.Errorf("%s must be positive", EnvRetainOrders)
+		}
+		r.orders = orders
+	}
+	if v := os.Getenv(EnvRetainPeriod); v != "" {
+		period, err := time.ParseDuration(v)
+		if err != nil {
+			return r, fmt.Errorf("error parsing %s: %s", EnvRetainPeriod, err)
+		}
+		if period <= 0 {
+			return r, fmt.Errorf("%s must be positive", EnvRetainPeriod)
+		}
+		r.period = period
+	}
+	return r, nil
+}
+
+// gcReport summarizes a garbage collection
+type gcReport struct {
+	Orders int   `json:"orders"` // removed directories of orders which are not retained
+	Blobs  int   `json:"blobs"`  // removed unreferenced blobs
+	Freed  int64 `json:"freed"`  // bytes of removed blobs
+}
+
+// importFiles moves the files under dir into the blob store, deduplicating identical ones
+func (m *manager) importFiles(orderID, dir string) {
+	stats, err := m.blobs.Import(dir)
+	if err != nil {
+		log.Printf("Error importing files of %s into blob store: %s", orderID, err)
+		return
+	}
+	if stats.Deduplicated > 0 {
+		log.Printf("Imported %d files of %s into blob store, %d already stored (%d bytes saved)", stats.Files, orderID, stats.Deduplicated, stats.Saved)
+	}
+}
+
+// replaceDir writes the files into a new directory which then replaces dir
+//	Files under dir may be hard links to blobs, which must not be written in place.
+func replaceDir(dir string, write func(tempDir string) error) error {
+	err := os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return err
+	}
+	tempDir, err := ioutil.TempDir(filepath.Dir(dir), "."+filepath.Base(dir))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	err = os.Chmod(tempDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = write(tempDir)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempDir, dir)
+}
+
+// removeOrderFiles removes the order directory, releasing its references to blobs
+func (m *manager) removeOrderFiles(orderID string) error {
+	return os.RemoveAll(fmt.Sprintf("%s/%s", source.OrdersDir, orderID))
+}
+
+// gcRunner periodically removes files which are no longer used by orders
+func (m *manager) gcRunner() {
+	for ; true; <-time.Tick(GCInterval) {
+		_, err := m.collectGarbage()
+		if err != nil {
+			log.Printf("Error collecting garbage: %s", err)
+		}
+	}
+}
+
+// retainedOrders returns the ids of the latest orders, the deployments of targets and the scheduled orders
+//	Returns nil if files of all stored orders are retained.
+func (m *manager) retainedOrders() (map[string]bool, error) {
+	if m.retention.orders == 0 && m.retention.period == 0 {
+		return nil, nil
+	}
+	retained := make(map[string]bool)
+	if m.retention.orders > 0 {
+		orders, _, err := m.storage.GetOrders("", false, 0, m.retention.orders)
+		if err != nil {
+			return nil, fmt.Errorf("error querying orders: %s", err)
+		}
+		for _, order := range orders {
+			retained[order.ID] = true
+		}
+	}
+	// deployments may be redeployed or rolled back to
+	const pageSize = 100
+	for from := 0; ; from += pageSize {
+		targets, total, err := m.storage.GetTargets(nil, from, pageSize)
+		if err != nil {
+			return nil, fmt.Errorf("error querying targets: %s", err)
+		}
+		for _, target := range targets {
+			for _, id := range target.Deployments {
+				retained[id] = true
+			}
+		}
+		if len(targets) == 0 || int64(from+pageSize) >= total {
+			break
+		}
+	}
+	// orders which are not yet sent to all targets
+	orders, err := m.storage.GetScheduledOrders()
+	if err != nil {
+		return nil, fmt.Errorf("error querying scheduled orders: %s", err)
+	}
+	for _, order := range orders {
+		retained[order.ID] = true
+	}
+	return retained, nil
+}
+
+// collectGarbage removes directories of orders which are not retained, and then the unreferenced blobs
+func (m *manager) collectGarbage() (*gcReport, error) {
+	m.gcLocker.Lock()
+	defer m.gcLocker.Unlock()
+
+	var report gcReport
+	dirs, err := ioutil.ReadDir(source.OrdersDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading orders directory: %s", err)
+	}
+	retained, err := m.retainedOrders()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if time.Since(dir.ModTime()) < orphanGracePeriod {
+			continue
+		}
+		order, err := m.storage.GetOrder(dir.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error querying order: %s", err)
+		}
+		if order != nil && (retained == nil || retained[order.ID] ||
+			time.Since(time.Unix(0, int64(order.Created)*1e6)) < m.retention.period) {
+			continue
+		}
+		err = m.removeOrderFiles(dir.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error removing files of %s: %s", dir.Name(), err)
+		}
+		report.Orders++
+	}
+
+	report.Blobs, report.Freed, err = m.blobs.GC()
+	if err != nil {
+		return nil, fmt.Errorf("error removing blobs: %s", err)
+	}
+	if report.Orders+report.Blobs > 0 {
+		log.Printf("Garbage collection: removed %d order directories and %d blobs (%d bytes)", report.Orders, report.Blobs, report.Freed)
+	}
+	return &report, nil
+}
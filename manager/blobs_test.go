@@ -0,0 +1,184 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+// TestStorePackageAgain checks that a package received again does not write through the links to blobs
+func TestStorePackageAgain(t *testing.T) {
+	m, _, stop := startTestManager(t)
+	defer stop()
+
+	archive := func(format, content string) []byte {
+		dir, err := ioutil.TempDir("", "package")
+		if err != nil {
+			t.Fatalf("Error creating temp dir: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		ioutil.WriteFile(dir+"/app", []byte(content), 0755)
+		b, err := model.CompressFilesAs(format, dir+"/app")
+		if err != nil {
+			t.Fatalf("Error compressing: %s", err)
+		}
+		return b
+	}
+	pkgFile := func(orderID string) string {
+		return filepath.Join(m.archWorkDir(orderID, ""), source.PackageDir, "app")
+	}
+
+	for _, format := range []string{model.ArchiveZip, model.ArchiveTarGz} {
+		t.Run(format, func(t *testing.T) {
+			first, second := "first-"+format, "second-"+format
+			for _, orderID := range []string{first, second} {
+				err := m.storePackage(orderID, "", archive(format, "v1"))
+				if err != nil {
+					t.Fatalf("Error storing package: %s", err)
+				}
+			}
+			digest := m.blobs.Digest(pkgFile(first))
+			if digest == "" || digest != m.blobs.Digest(pkgFile(second)) {
+				t.Fatalf("Expected packages to share a blob, got %q and %q", digest, m.blobs.Digest(pkgFile(second)))
+			}
+
+			// e.g. a build host sends the package again after a retry
+			err := m.storePackage(first, "", archive(format, "v2"))
+			if err != nil {
+				t.Fatalf("Error storing package again: %s", err)
+			}
+			for orderID, expected := range map[string]string{first: "v2", second: "v1"} {
+				b, err := ioutil.ReadFile(pkgFile(orderID))
+				if err != nil || string(b) != expected {
+					t.Fatalf("Expected %s in package of %s, got %s %v", expected, orderID, b, err)
+				}
+			}
+			if m.blobs.Digest(pkgFile(second)) != digest {
+				t.Fatalf("Blob of the other package is modified")
+			}
+			if m.blobs.Digest(pkgFile(first)) == "" {
+				t.Fatalf("New package is not imported")
+			}
+			files, _ := ioutil.ReadDir(m.archWorkDir(first, ""))
+			if len(files) != 1 {
+				t.Fatalf("Expected only the package in the work directory, got %d files", len(files))
+			}
+		})
+	}
+
+	// reused packages replace the package in the same way
+	err := m.copyPackage("first-"+model.ArchiveZip, "second-"+model.ArchiveZip, "")
+	if err != nil {
+		t.Fatalf("Error copying package: %s", err)
+	}
+	b, _ := ioutil.ReadFile(pkgFile("second-" + model.ArchiveZip))
+	if string(b) != "v2" {
+		t.Fatalf("Expected copied package, got %s", b)
+	}
+	b, _ = ioutil.ReadFile(pkgFile("second-" + model.ArchiveTarGz))
+	if string(b) != "v1" {
+		t.Fatalf("Blob of the other package is modified by the copy: %s", b)
+	}
+}
+
+// TestGarbageRetention checks that files of orders are removed unless retained by the policy
+func TestGarbageRetention(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	addOrder := func(id string, created time.Time) {
+		if created != (time.Time{}) {
+			order := storage.Order{Created: model.UnixTimeType(created.UnixNano() / 1e6)}
+			order.ID = id
+			s.AddOrder(&order)
+		}
+		dir := filepath.Join(source.OrdersDir, id, source.SourceDir)
+		os.MkdirAll(dir, 0755)
+		ioutil.WriteFile(filepath.Join(dir, "app"), []byte(id), 0755)
+		os.Chtimes(filepath.Join(source.OrdersDir, id), old, old)
+	}
+	kept := func() []string {
+		var ids []string
+		dirs, _ := ioutil.ReadDir(source.OrdersDir)
+		for _, dir := range dirs {
+			ids = append(ids, dir.Name())
+		}
+		return ids
+	}
+	addOrder("first", time.Now().Add(-72*time.Hour))
+	addOrder("deployed", time.Now().Add(-48*time.Hour))
+	addOrder("latest", time.Now().Add(-24*time.Hour))
+	addOrder("orphan", time.Time{})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw"}, Deployments: []string{"deployed"}})
+
+	// without retention policy, files of all stored orders are kept
+	_, err := m.collectGarbage()
+	if err != nil {
+		t.Fatalf("Error collecting garbage: %s", err)
+	}
+	if ids := kept(); !reflect.DeepEqual(ids, []string{"deployed", "first", "latest"}) {
+		t.Fatalf("Expected files of stored orders, got %v", ids)
+	}
+
+	// latest orders and deployments of targets
+	m.retention = retention{orders: 1}
+	report, err := m.collectGarbage()
+	if err != nil {
+		t.Fatalf("Error collecting garbage: %s", err)
+	}
+	if ids := kept(); !reflect.DeepEqual(ids, []string{"deployed", "latest"}) {
+		t.Fatalf("Expected files of latest and deployed orders, got %v", ids)
+	}
+	if report.Orders != 1 || report.Blobs != 0 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if order, _ := s.GetOrder("first"); order == nil {
+		t.Fatalf("Order is removed along with its files")
+	}
+
+	// orders created within the period
+	addOrder("recent", time.Now())
+	m.retention = retention{period: time.Hour}
+	_, err = m.collectGarbage()
+	if err != nil {
+		t.Fatalf("Error collecting garbage: %s", err)
+	}
+	if ids := kept(); !reflect.DeepEqual(ids, []string{"deployed", "recent"}) {
+		t.Fatalf("Expected files of recent and deployed orders, got %v", ids)
+	}
+}
+
+func TestLoadRetention(t *testing.T) {
+	defer os.Setenv(EnvRetainOrders, os.Getenv(EnvRetainOrders))
+	defer os.Setenv(EnvRetainPeriod, os.Getenv(EnvRetainPeriod))
+
+	for _, c := range []struct {
+		orders, period string
+		expected       retention
+		valid          bool
+	}{
+		{"", "", retention{}, true},
+		{"10", "720h", retention{orders: 10, period: 720 * time.Hour}, true},
+		{"0", "", retention{}, false},
+		{"", "-1h", retention{}, false},
+		{"ten", "", retention{}, false},
+	} {
+		os.Setenv(EnvRetainOrders, c.orders)
+		os.Setenv(EnvRetainPeriod, c.period)
+		r, err := loadRetention()
+		if c.valid && (err != nil || r != c.expected) {
+			t.Errorf("Expected %+v for %q %q, got %+v: %v", c.expected, c.orders, c.period, r, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("Expected error for %q %q", c.orders, c.period)
+		}
+	}
+}
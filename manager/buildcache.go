@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	copier "github.com/otiai10/copy"
+)
+
+const buildKeyFile = "build.sha256" // next to the package, in the work directory of the architecture
+
+// reuseBuilds copies packages built previously from identical source and build spec into the order
+//	and returns the build hosts which still need to build.
+//	Orders with secrets are always built, as the secret values may have changed in the meantime.
+func (m *manager) reuseBuilds(order *storage.Order) (pending []string) {
+	for _, platform := range buildPlatforms(order) {
+		arch, host := platform.Arch, platform.Host
+		if len(order.Secrets) > 0 {
+			pending = append(pending, host)
+			continue
+		}
+
+		key, err := m.buildKey(order, arch)
+		if err != nil {
+			m.storeLog(order.ID, model.StageBuild, fmt.Sprintf("error computing build cache key: %s", err), true, host)
+			pending = append(pending, host)
+			continue
+		}
+
+		cachedID := m.findBuild(order.ID, arch, key)
+		if cachedID == "" {
+			pending = append(pending, host)
+			continue
+		}
+		err = m.copyPackage(cachedID, order.ID, arch)
+		if err != nil {
+			m.storeLog(order.ID, model.StageBuild, fmt.Sprintf("error reusing package of %s: %s", cachedID, err), true, host)
+			os.RemoveAll(filepath.Join(m.archWorkDir(order.ID, arch), source.PackageDir))
+			pending = append(pending, host)
+			continue
+		}
+		m.writeBuildKey(order.ID, arch, key)
+		m.storeLog(order.ID, model.StageBuild, fmt.Sprintf("build cache hit: reusing package of %s", cachedID), false, host)
+		m.storeLog(order.ID, model.StageBuild, model.StageEnd, false, host)
+	}
+	return pending
+}
+
+// recordBuild marks the stored package of the order as reusable by orders with identical source and build spec
+//	Should be called once the package is complete, so that failed or partial builds are never reused.
+func (m *manager) recordBuild(order *storage.Order, arch string) {
+	if len(order.Secrets) > 0 {
+		return
+	}
+	key, err := m.buildKey(order, arch)
+	if err != nil {
+		log.Printf("Error computing build cache key of %s: %s", order.ID, err)
+		return
+	}
+	m.writeBuildKey(order.ID, arch, key)
+}
+
+// writeBuildKey writes the build cache key next to the package
+func (m *manager) writeBuildKey(orderID, arch, key string) {
+	err := ioutil.WriteFile(filepath.Join(m.archWorkDir(orderID, arch), buildKeyFile), []byte(key), 0644)
+	if err != nil {
+		log.Printf("Error writing build cache key of %s: %s", orderID, err)
+	}
+}
+
+// buildPlatforms returns the build matrix, or the single build host without architecture
+func buildPlatforms(order *storage.Order) []storage.BuildArch {
+	if len(order.Build.Matrix) == 0 {
+		return []storage.BuildArch{{Host: order.Build.Host}}
+	}
+	return order.Build.Matrix
+}
+
+// buildKey hashes the source of the order together with the build spec and the platform it is built on
+func (m *manager) buildKey(order *storage.Order, arch string) (string, error) {
+	spec := struct {
+		Build    model.Build
+		Template *model.Template
+		Platform string
+	}{order.Build.Build, order.Template, arch}
+	if arch == "" {
+		spec.Platform = "host/" + order.Build.Host
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write(b)
+
+	path := fmt.Sprintf("%s/%s/%s", source.OrdersDir, order.ID, source.SourceDir)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return hex.EncodeToString(hash.Sum(nil)), nil // built without source
+	}
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		var content string
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			content, err = os.Readlink(file)
+		case info.Mode().IsRegular():
+			content = m.blobs.Digest(file)
+			if content == "" {
+				var digest []byte
+				digest, err = model.FileDigest(file)
+				content = hex.EncodeToString(digest)
+			}
+		}
+		fmt.Fprintf(hash, "%s\x00%o\x00%s\n", filepath.ToSlash(rel), info.Mode(), content)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error hashing source: %s", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findBuild returns the id of another order with a package built for the key, or empty if not found
+func (m *manager) findBuild(orderID, arch, key string) string {
+	dirs, err := ioutil.ReadDir(source.OrdersDir)
+	if err != nil {
+		return ""
+	}
+	for _, dir := range dirs {
+		if dir.Name() == orderID || !dir.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(m.archWorkDir(dir.Name(), arch), buildKeyFile))
+		if err != nil || string(b) != key {
+			continue
+		}
+		if m.packageExists(dir.Name(), arch) {
+			return dir.Name()
+		}
+	}
+	return ""
+}
+
+// copyPackage copies the package built for the architecture from one order to another
+func (m *manager) copyPackage(fromID, toID, arch string) error {
+	dir := filepath.Join(m.archWorkDir(toID, arch), source.PackageDir)
+	err := replaceDir(dir, func(tempDir string) error {
+		return copier.Copy(filepath.Join(m.archWorkDir(fromID, arch), source.PackageDir), tempDir)
+	})
+	if err != nil {
+		return err
+	}
+	m.importFiles(toID, dir)
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// buildOrder adds an order with the given source files and spec
+func buildOrder(t *testing.T, s *memStorage, id, spec string, files map[string]string) *storage.Order {
+	var order storage.Order
+	err := yaml.Unmarshal([]byte(spec), &order)
+	if err != nil {
+		t.Fatalf("Error parsing order: %s", err)
+	}
+	order.ID = id
+	if order.Build != nil {
+		order.Status = storage.NewOrderStatus(order.Build.Hosts())
+	}
+	for rel, content := range files {
+		path := filepath.Join(source.OrdersDir, id, source.SourceDir, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+	s.AddOrder(&order)
+	return &order
+}
+
+// storeTestPackage stores a package as if received from the build host
+func storeTestPackage(t *testing.T, m *manager, orderID, arch, content string) {
+	order, err := m.storage.GetOrder(orderID)
+	if err != nil || order == nil {
+		t.Fatalf("Error getting order: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "package")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/app", []byte(content), 0755)
+	b, err := model.CompressFiles(dir + "/app")
+	if err != nil {
+		t.Fatalf("Error compressing: %s", err)
+	}
+	err = m.storePackage(orderID, arch, b)
+	if err != nil {
+		t.Fatalf("Error storing package: %s", err)
+	}
+	m.recordBuild(order, arch)
+}
+
+func TestBuildKey(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	const spec = `
+build:
+  commands: [make]
+  artifacts: [app]
+  host: builder
+`
+	files := map[string]string{"main.go": "package main", "lib/lib.go": "package lib"}
+	key := func(order *storage.Order, arch string) string {
+		k, err := m.buildKey(order, arch)
+		if err != nil {
+			t.Fatalf("Error computing key: %s", err)
+		}
+		return k
+	}
+
+	first := buildOrder(t, s, "first", spec, files)
+	second := buildOrder(t, s, "second", spec, files)
+	base := key(first, "")
+	if len(base) != 64 {
+		t.Fatalf("Unexpected key: %s", base)
+	}
+	// stable for the same order and for identical orders
+	if key(first, "") != base || key(second, "") != base {
+		t.Fatalf("Key is not stable")
+	}
+	// blob links do not change the key
+	m.importFiles(first.ID, filepath.Join(source.OrdersDir, first.ID))
+	if key(first, "") != base {
+		t.Fatalf("Key changed after importing into blob store")
+	}
+
+	expectChange := func(name string, order *storage.Order, arch string) {
+		if k := key(order, arch); k == base {
+			t.Fatalf("Key does not change with %s", name)
+		}
+	}
+	expectChange("architecture", first, "arm64")
+
+	commands := buildOrder(t, s, "commands", spec, files)
+	commands.Build.Commands = []string{"make release"}
+	expectChange("commands", commands, "")
+
+	artifacts := buildOrder(t, s, "artifacts", spec, files)
+	artifacts.Build.Artifacts = []string{"app", "config"}
+	expectChange("artifacts", artifacts, "")
+
+	host := buildOrder(t, s, "host", spec, files)
+	host.Build.Host = "other-builder"
+	expectChange("build host", host, "")
+
+	template := buildOrder(t, s, "template", spec, files)
+	template.Template = &model.Template{Commands: true}
+	expectChange("template", template, "")
+
+	content := buildOrder(t, s, "content", spec, map[string]string{"main.go": "package main // v2", "lib/lib.go": "package lib"})
+	expectChange("content", content, "")
+
+	renamed := buildOrder(t, s, "renamed", spec, map[string]string{"main.go": "package main", "lib/other.go": "package lib"})
+	expectChange("file name", renamed, "")
+
+	mode := buildOrder(t, s, "mode", spec, files)
+	os.Chmod(filepath.Join(source.OrdersDir, mode.ID, source.SourceDir, "main.go"), 0755)
+	expectChange("file mode", mode, "")
+
+	link := buildOrder(t, s, "link", spec, files)
+	os.Symlink("main.go", filepath.Join(source.OrdersDir, link.ID, source.SourceDir, "run"))
+	expectChange("symlink", link, "")
+}
+
+func TestReuseBuilds(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	const spec = `
+build:
+  commands: [make]
+  artifacts: [app]
+  matrix:
+    - {arch: amd64, host: builder-amd64}
+    - {arch: arm, host: builder-arm}
+`
+	files := map[string]string{"main.go": "package main"}
+
+	// nothing built yet
+	first := buildOrder(t, s, "first", spec, files)
+	pending := m.reuseBuilds(first)
+	if len(pending) != 2 {
+		t.Fatalf("Expected both hosts to build, got %v", pending)
+	}
+	if id := m.findBuild("second", "amd64", "unknown"); id != "" {
+		t.Fatalf("Expected no build for unknown key, got %s", id)
+	}
+
+	// only the amd64 package is received
+	storeTestPackage(t, m, first.ID, "amd64", "amd64 binary")
+	second := buildOrder(t, s, "second", spec, files)
+	key, _ := m.buildKey(second, "amd64")
+	if id := m.findBuild(second.ID, "amd64", key); id != first.ID {
+		t.Fatalf("Expected build of first order, got %q", id)
+	}
+	pending = m.reuseBuilds(second)
+	if len(pending) != 1 || pending[0] != "builder-arm" {
+		t.Fatalf("Expected only the arm host to build, got %v", pending)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(m.archWorkDir(second.ID, "amd64"), source.PackageDir, "app"))
+	if err != nil || string(b) != "amd64 binary" {
+		t.Fatalf("Expected copied package, got %s %v", b, err)
+	}
+	if !m.packageExists(second.ID, "amd64") || m.packageExists(second.ID, "arm") {
+		t.Fatalf("Expected only the amd64 package to exist")
+	}
+	if s.Outputs(second.ID) == "" {
+		t.Fatalf("Expected cache hit to be logged")
+	}
+
+	// orders with secrets are always built
+	secrets := buildOrder(t, s, "secrets", spec, files)
+	secrets.Secrets = []model.SecretRef{{Name: "token", Env: "TOKEN"}}
+	if pending := m.reuseBuilds(secrets); len(pending) != 2 {
+		t.Fatalf("Expected order with secrets to be built, got %v", pending)
+	}
+
+	// a full hit completes without a build task
+	storeTestPackage(t, m, first.ID, "arm", "arm binary")
+	third := buildOrder(t, s, "third", spec, files)
+	m.pipe = model.NewPipe()
+	done := make(chan struct{})
+	go func() {
+		m.composeTask(third)
+		close(done)
+	}()
+	select {
+	case message := <-m.pipe.RequestCh:
+		t.Fatalf("Expected no build task, got request to %s", message.Topic)
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout composing task")
+	}
+	if !m.packagesComplete(third) {
+		t.Fatalf("Expected packages of all architectures to be reused")
+	}
+}
+
+// TestFailedBuildNotReused checks that only complete packages are reused
+func TestFailedBuildNotReused(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	const spec = `
+build:
+  commands: [make]
+  artifacts: [app]
+  host: builder
+`
+	files := map[string]string{"main.go": "package main"}
+	keyFile := func(orderID string) string {
+		return filepath.Join(m.archWorkDir(orderID, ""), buildKeyFile)
+	}
+	dir, err := ioutil.TempDir("", "package")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/app", []byte("binary"), 0755)
+	payload, err := model.CompressFiles(dir + "/app")
+	if err != nil {
+		t.Fatalf("Error compressing: %s", err)
+	}
+
+	// the build fails without a package
+	failed := buildOrder(t, s, "failed", spec, files)
+	if pending := m.reuseBuilds(failed); len(pending) != 1 {
+		t.Fatalf("Expected build, got %v", pending)
+	}
+	if _, err := os.Stat(keyFile(failed.ID)); !os.IsNotExist(err) {
+		t.Fatalf("Build key is written before the build")
+	}
+	// the package is invalid
+	invalid := buildOrder(t, s, "invalid", spec, files)
+	m.reuseBuilds(invalid)
+	m.processPackage(&model.Package{Task: invalid.ID, Assembler: "builder", Payload: []byte("invalid")})
+	if _, err := os.Stat(keyFile(invalid.ID)); !os.IsNotExist(err) {
+		t.Fatalf("Build key is written for an invalid package")
+	}
+	retry := buildOrder(t, s, "retry", spec, files)
+	if pending := m.reuseBuilds(retry); len(pending) != 1 {
+		t.Fatalf("Expected failed builds not to be reused, got %v", pending)
+	}
+
+	// the received package is reused
+	m.processPackage(&model.Package{Task: retry.ID, Assembler: "builder", Payload: payload})
+	reused := buildOrder(t, s, "reused", spec, files)
+	if pending := m.reuseBuilds(reused); len(pending) != 0 {
+		t.Fatalf("Expected complete build to be reused, got %v", pending)
+	}
+	// and the copy can be reused in turn
+	if _, err := os.Stat(keyFile(reused.ID)); err != nil {
+		t.Fatalf("Build key is not written for the reused package: %s", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MaxDeltaSends is the number of delta groups which are prepared and sent concurrently
+const MaxDeltaSends = 4
+
+// sendDeltas sends targets only the files which changed since their current deployment
+//	Targets are grouped by current deployment. Targets without one, and retries, get the full artifacts.
+//	The full artifacts are also sent when the delta is not smaller, e.g. when most files changed.
+func (m *manager) sendDeltas(order *storage.Order, task *model.Task, match storage.Match) {
+	if task.Retry != 0 || len(task.Artifacts) == 0 {
+		m.sendTask(task, match)
+		return
+	}
+
+	groups := make(map[string][]string) // base order id: targets
+	var full []string
+	for _, id := range match.List {
+		target, err := m.storage.GetTarget(id)
+		if err != nil {
+			log.Printf("Error getting target: %s", err)
+		}
+		if err != nil || target == nil || len(target.Deployments) == 0 {
+			full = append(full, id)
+			continue
+		}
+		current := target.Deployments[len(target.Deployments)-1]
+		if current == order.ID {
+			full = append(full, id)
+			continue
+		}
+		groups[current] = append(groups[current], id)
+	}
+	if len(groups) == 0 {
+		m.sendTask(task, match)
+		return
+	}
+
+	// groups are sent concurrently, as each send waits for delivery
+	var wg sync.WaitGroup
+	if len(full) > 0 {
+		wg.Add(1)
+		go func(ids []string) {
+			defer wg.Done()
+			m.sendTask(task, storage.Match{IDs: ids, List: ids})
+		}(full)
+	}
+	var fallback []string // targets of groups without a smaller delta
+	var fallbackLocker sync.Mutex
+	sem := make(chan struct{}, MaxDeltaSends)
+	for base, ids := range groups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(base string, ids []string) {
+			defer func() { <-sem; wg.Done() }()
+			deltaTask, err := m.deltaTask(order, task, base, ids)
+			if err != nil {
+				log.Printf("Sending full artifacts of %s instead of delta to %s: %s", order.ID, base, err)
+			}
+			if err != nil || len(deltaTask.Artifacts) >= len(task.Artifacts) {
+				fallbackLocker.Lock()
+				fallback = append(fallback, ids...)
+				fallbackLocker.Unlock()
+				return
+			}
+			m.storeLog(order.ID, model.StageInstall, fmt.Sprintf("delta to %s compressed to %d bytes", base, len(deltaTask.Artifacts)), false, ids...)
+			m.sendTask(deltaTask, storage.Match{IDs: ids, List: ids})
+		}(base, ids)
+	}
+	wg.Wait()
+	if len(fallback) > 0 {
+		m.sendTask(task, storage.Match{IDs: fallback, List: fallback})
+	}
+}
+
+// deltaTask returns a copy of the task for the targets, with artifacts that only include files which differ from the base order
+func (m *manager) deltaTask(order *storage.Order, task *model.Task, baseID string, targets []string) (*model.Task, error) {
+	path, found := m.sourcePath(order.ID)
+	if !found {
+		return nil, fmt.Errorf("artifacts not found")
+	}
+	basePath, found := m.sourcePath(baseID)
+	if !found {
+		return nil, fmt.Errorf("artifacts of base not found")
+	}
+	base, err := m.storage.GetOrder(baseID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying base order: %s", err)
+	}
+	if base == nil {
+		return nil, fmt.Errorf("base order not found")
+	}
+	// files which are modified on targets are always sent
+	modified := make(map[string]bool)
+	if base.Template != nil {
+		for _, file := range base.Template.Files {
+			modified[filepath.Clean(file)] = true
+		}
+	}
+	for _, secret := range base.Secrets {
+		if secret.File != "" {
+			modified[filepath.Clean(secret.File)] = true
+		}
+	}
+
+	delta := model.Delta{Base: baseID, Dir: filepath.Base(path), Targets: targets}
+	var changed []string
+	delta.Files, changed, err = model.DiffTree(path, basePath, modified)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing files: %s", err)
+	}
+
+	// link the changed files into a temporary directory to compress them with the same layout
+	tempDir := fmt.Sprintf("%s/%s/delta-%s", source.OrdersDir, order.ID, uuid.NewV4().String())
+	defer os.RemoveAll(tempDir)
+	for _, rel := range changed {
+		dest := filepath.Join(tempDir, delta.Dir, rel)
+		err = os.MkdirAll(filepath.Dir(dest), 0755)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Link(filepath.Join(path, rel), dest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = os.MkdirAll(filepath.Join(tempDir, delta.Dir), 0755)
+	if err != nil {
+		return nil, err
+	}
+	compressedArchive, err := model.CompressFilesAs(order.Archive, filepath.Join(tempDir, delta.Dir))
+	if err != nil {
+		return nil, fmt.Errorf("error compressing delta: %s", err)
+	}
+
+	deltaTask := *task
+	deltaTask.Artifacts = compressedArchive
+	deltaTask.Delta = &delta
+	return &deltaTask, nil
+}
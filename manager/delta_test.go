@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+func TestDeltaTask(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	write := func(orderID, rel, content string, mode os.FileMode) {
+		path := filepath.Join(source.OrdersDir, orderID, source.SourceDir, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), mode)
+		os.Chmod(path, mode)
+	}
+	write("base", "app.sh", "echo v1", 0755)
+	write("base", "config.yml", "id: {{.TargetID}}", 0644)
+	write("base", "lib.txt", "same", 0644)
+	write("next", "app.sh", "echo v2", 0755)
+	write("next", "config.yml", "id: {{.TargetID}}", 0644)
+	write("next", "lib.txt", "same", 0644)
+
+	base := storage.Order{Template: &model.Template{Files: []string{"config.yml"}}}
+	base.ID = "base"
+	s.AddOrder(&base)
+	order := storage.Order{}
+	order.ID = "next"
+	task := &model.Task{Header: order.Header, Deploy: &model.Deploy{}, Artifacts: []byte("full")}
+
+	delta, err := m.deltaTask(&order, task, "base", []string{"gw"})
+	if err != nil {
+		t.Fatalf("Error composing delta: %s", err)
+	}
+	if delta.Delta.Base != "base" || delta.Delta.Dir != source.SourceDir || len(delta.Delta.Targets) != 1 || delta.Delta.Targets[0] != "gw" {
+		t.Fatalf("Unexpected delta: %+v", delta.Delta)
+	}
+	if len(delta.Delta.Files) != 3 {
+		t.Fatalf("Expected manifest of 3 files, got %+v", delta.Delta.Files)
+	}
+	if task.Delta != nil || string(task.Artifacts) != "full" {
+		t.Fatalf("Task is modified")
+	}
+
+	// only the changed file and the template rendered on targets are sent
+	dir := filepath.Join(source.OrdersDir, "extracted")
+	err = model.DecompressFiles(delta.Artifacts, dir)
+	if err != nil {
+		t.Fatalf("Error decompressing delta: %s", err)
+	}
+	var sent []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(filepath.Join(dir, source.SourceDir), path)
+			sent = append(sent, rel)
+		}
+		return err
+	})
+	if len(sent) != 2 || sent[0] != "app.sh" || sent[1] != "config.yml" {
+		t.Fatalf("Expected app.sh and config.yml in delta, got %v", sent)
+	}
+}
+
+// TestSendDeltasConcurrently checks that groups of targets with different bases do not wait for each other's delivery
+func TestSendDeltasConcurrently(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	for _, id := range []string{"base1", "base2", "next"} {
+		path := filepath.Join(source.OrdersDir, id, source.SourceDir, "app.sh")
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("echo "+id), 0755)
+		order := storage.Order{}
+		order.ID = id
+		s.AddOrder(&order)
+	}
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw1"}, Deployments: []string{"base1"}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw2"}, Deployments: []string{"base2"}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw3"}})
+
+	// replace the pipe to receive the requests, which are sent until delivery attempts are over
+	m.pipe = model.Pipe{RequestCh: make(chan model.Message, 100)}
+	order, _ := s.GetOrder("next")
+	task := &model.Task{Header: order.Header, Deploy: &model.Deploy{}, Artifacts: []byte(strings.Repeat("full", 1000))}
+	go m.sendDeltas(order, task, storage.Match{IDs: []string{"gw1", "gw2", "gw3"}, List: []string{"gw1", "gw2", "gw3"}})
+
+	// each send waits for delivery for several seconds after the announcement
+	announced := make(map[string]bool)
+	timeout := time.After(3 * time.Second)
+	for len(announced) < 3 {
+		select {
+		case message := <-m.pipe.RequestCh:
+			for _, id := range []string{"gw1", "gw2", "gw3"} {
+				if message.Topic == model.FormatTopicID(id) {
+					announced[id] = true
+				}
+			}
+		case <-timeout:
+			t.Fatalf("Expected announcements to all groups, got %v", announced)
+		}
+	}
+	if logs := s.Outputs(order.ID); strings.Count(logs, "delta to base") != 2 {
+		t.Fatalf("Expected deltas to both bases, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"code.linksmart.eu/dt/deployment-tool/manager/swarmio"
+)
+
+const ArtifactsDir = "artifacts"
+
+// storeArtifacts writes the artifacts to the order directory, named by their digest,
+//	and returns the reference for targets to download them
+func (m *manager) storeArtifacts(orderID string, digest, artifacts []byte) (*model.Download, error) {
+	name := hex.EncodeToString(digest)
+	dir := fmt.Sprintf("%s/%s/%s", source.OrdersDir, orderID, ArtifactsDir)
+	path := filepath.Join(dir, name)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		// write to a temporary file, as the task may be sent concurrently
+		f, err := ioutil.TempFile(dir, name+".*")
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(artifacts)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), path)
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &model.Download{
+		URL:  fmt.Sprintf("/orders/%s/%s/%s", orderID, ArtifactsDir, name),
+		Size: int64(len(artifacts)),
+	}, nil
+}
+
+// artifactsPath returns the path of stored artifacts
+//	The artifacts are permitted only to targets of the order, as they may include sealed secrets.
+func (m *manager) artifactsPath(orderID, digest, targetID string) (path string, found, permitted bool) {
+	if b, err := hex.DecodeString(digest); err != nil || len(b) == 0 {
+		return "", false, false
+	}
+	order, err := m.storage.GetOrder(orderID)
+	if err != nil || order == nil {
+		return "", false, false
+	}
+	if !m.orderTarget(order, targetID) {
+		return "", true, false
+	}
+	path = fmt.Sprintf("%s/%s/%s/%s", source.OrdersDir, order.ID, ArtifactsDir, digest)
+	if _, err := os.Stat(path); err != nil {
+		return "", false, true
+	}
+	return path, true, true
+}
+
+// orderTarget returns true if the target is addressed by the order
+func (m *manager) orderTarget(order *storage.Order, targetID string) bool {
+	if inBatch(targetID, m.getTargetList(order)) {
+		return true
+	}
+	if order.Status != nil {
+		for _, t := range order.Status.Targets {
+			if t.ID == targetID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authenticateTarget verifies the signature of the request and returns the registered target
+func (m *manager) authenticateTarget(r *http.Request) (targetID string, err error) {
+	cert, err := swarmio.LoadCert()
+	if err != nil {
+		return "", fmt.Errorf("error loading certificate: %s", err)
+	}
+	id := r.Header.Get(swarmio.HeaderTarget)
+	if id == "" {
+		return "", fmt.Errorf("request is not signed")
+	}
+	target, err := m.storage.GetTarget(id)
+	if err != nil {
+		return "", fmt.Errorf("error querying target: %s", err)
+	}
+	if target == nil {
+		return "", fmt.Errorf("target is not registered: %s", id)
+	}
+	// keys certified before binding to target ids are accepted if registered for the target
+	return swarmio.VerifyRequest(r, cert.PublicKey, target.PublicKeySwarmio)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"code.linksmart.eu/dt/deployment-tool/manager/swarmio"
+	"gopkg.in/yaml.v2"
+)
+
+// TestArtifactsAccess checks that artifacts are served only to targets of the order with certified keys
+func TestArtifactsAccess(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	a := restAPI{manager: m}
+	a.setupRouter()
+
+	defer os.Setenv(swarmio.EnvCommCert, os.Getenv(swarmio.EnvCommCert))
+	wd, _ := os.Getwd()
+	os.Setenv(swarmio.EnvCommCert, filepath.Join(wd, "swarmio.json"))
+	caPublic, caPrivate, _ := ed25519.GenerateKey(nil)
+	err := swarmio.StoreCert(swarmio.Cert{PrivateKey: caPrivate, PublicKey: caPublic})
+	if err != nil {
+		t.Fatalf("Error storing cert: %s", err)
+	}
+
+	// a target with a key certified for its id, and one with a key certified before binding keys to ids
+	newCert := func(targetID string, legacy bool) swarmio.Cert {
+		public, private, _ := ed25519.GenerateKey(nil)
+		cert := swarmio.Cert{PublicKey: public, PrivateKey: private, CA: caPublic}
+		if legacy {
+			cert.Signature, _ = swarmio.Sign(caPrivate, public)
+		} else {
+			cert.Signature, _ = swarmio.CertifyKey(caPrivate, targetID, public)
+		}
+		return cert
+	}
+	certs := map[string]swarmio.Cert{
+		"gw":     newCert("gw", false),
+		"other":  newCert("other", false),
+		"legacy": newCert("legacy", true),
+	}
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw"}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "other"}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "legacy"}})
+
+	var order storage.Order
+	yaml.Unmarshal([]byte("deploy: {run: {commands: [./app]}}"), &order)
+	order.ID = "order"
+	order.Deploy.Match = storage.Match{IDs: []string{"gw", "legacy"}, List: []string{"gw", "legacy"}}
+	s.AddOrder(&order)
+	digest := []byte{0x0a, 0x1b}
+	download, err := m.storeArtifacts(order.ID, digest, []byte("artifacts"))
+	if err != nil {
+		t.Fatalf("Error storing artifacts: %s", err)
+	}
+
+	request := func(url, targetID string) int {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		err := swarmio.SignRequest(r, targetID, certs[targetID])
+		if err != nil {
+			t.Fatalf("Error signing request: %s", err)
+		}
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request(download.URL, "gw"); code != http.StatusOK {
+		t.Fatalf("Expected 200 for target of the order, got %d", code)
+	}
+	if code := request(download.URL, "other"); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for another target, got %d", code)
+	}
+	if code := request("/orders/order/artifacts/"+hex.EncodeToString([]byte("missing")), "gw"); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for missing artifacts, got %d", code)
+	}
+
+	// legacy certificates are accepted once the key is recorded for the target
+	if code := request(download.URL, "legacy"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for legacy certificate without recorded key, got %d", code)
+	}
+	m.processTarget(&storage.Target{TargetBase: model.TargetBase{ID: "legacy", PublicKeySwarmio: certs["legacy"].PublicKey}}, false)
+	if code := request(download.URL, "legacy"); code != http.StatusOK {
+		t.Fatalf("Expected 200 for legacy certificate of recorded key, got %d", code)
+	}
+	// the recorded key is not replaced by advertisements
+	m.processTarget(&storage.Target{TargetBase: model.TargetBase{ID: "legacy", PublicKeySwarmio: certs["other"].PublicKey}}, false)
+	target, _ := s.GetTarget("legacy")
+	if string(target.PublicKeySwarmio) != string(certs["legacy"].PublicKey) {
+		t.Fatalf("Recorded key is replaced by advertisement")
+	}
+}
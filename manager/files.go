@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+)
+
+// orderFiles lists the source and package trees of an order
+type orderFiles struct {
+	Source   []fileEntry            `json:"source"`
+	Package  []fileEntry            `json:"package,omitempty"`
+	Packages map[string][]fileEntry `json:"packages,omitempty"` // per architecture of the build matrix
+}
+
+type fileEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256,omitempty"` // of regular files
+	Link   string `json:"link,omitempty"`   // target of symlinks
+}
+
+// getOrderFiles returns the file trees of the order, or nil if the order is not found
+func (m *manager) getOrderFiles(id string) (*orderFiles, error) {
+	order, err := m.storage.GetOrder(id)
+	if err != nil {
+		return nil, fmt.Errorf("error querying order: %s", err)
+	}
+	if order == nil {
+		return nil, nil
+	}
+
+	var files orderFiles
+	files.Source, err = m.listFiles(fmt.Sprintf("%s/%s/%s", source.OrdersDir, order.ID, source.SourceDir))
+	if err != nil {
+		return nil, fmt.Errorf("error listing source: %s", err)
+	}
+	if order.Build == nil {
+		return &files, nil
+	}
+	if len(order.Build.Matrix) == 0 {
+		files.Package, err = m.listFiles(fmt.Sprintf("%s/%s", m.archWorkDir(order.ID, ""), source.PackageDir))
+		if err != nil {
+			return nil, fmt.Errorf("error listing package: %s", err)
+		}
+		return &files, nil
+	}
+	files.Packages = make(map[string][]fileEntry)
+	for _, arch := range order.Build.Archs() {
+		files.Packages[arch], err = m.listFiles(fmt.Sprintf("%s/%s", m.archWorkDir(order.ID, arch), source.PackageDir))
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s package: %s", arch, err)
+		}
+	}
+	return &files, nil
+}
+
+// listFiles walks the directory, hashing regular files unless their digest is known to the blob store
+//	Directories which do not exist have no files.
+func (m *manager) listFiles(dir string) ([]fileEntry, error) {
+	entries := []fileEntry{}
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		return entries, nil
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		entry := fileEntry{
+			Path: filepath.ToSlash(rel),
+			Size: info.Size(),
+			Mode: info.Mode().String(),
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.Link, err = os.Readlink(path)
+		case info.Mode().IsRegular():
+			entry.SHA256 = m.blobs.Digest(path)
+			if entry.SHA256 == "" {
+				var digest []byte
+				digest, err = model.FileDigest(path)
+				entry.SHA256 = hex.EncodeToString(digest)
+			}
+		}
+		entries = append(entries, entry)
+		return err
+	})
+	return entries, err
+}
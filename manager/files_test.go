@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+)
+
+func TestOrderFiles(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	a := restAPI{manager: m}
+	a.setupRouter()
+
+	request := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	digest := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	single := buildOrder(t, s, "single", `
+build:
+  commands: [make]
+  artifacts: [app]
+  host: builder
+archive: tar.gz
+`, map[string]string{"main.go": "package main", "lib/lib.go": "package lib"})
+	os.Symlink("lib/lib.go", filepath.Join(source.OrdersDir, single.ID, source.SourceDir, "link"))
+	matrix := buildOrder(t, s, "matrix", `
+build:
+  commands: [make]
+  artifacts: [app]
+  matrix:
+    - {arch: amd64, host: builder-amd64}
+    - {arch: arm, host: builder-arm}
+`, map[string]string{"main.go": "package main"})
+	deployOnly := buildOrder(t, s, "deploy", `
+deploy:
+  install: {commands: [./install.sh]}
+`, map[string]string{"install.sh": "echo"})
+
+	// file trees
+	w := request("/orders/single/files")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var files orderFiles
+	json.Unmarshal(w.Body.Bytes(), &files)
+	entries := make(map[string]fileEntry)
+	for _, entry := range files.Source {
+		entries[entry.Path] = entry
+	}
+	if len(files.Source) != 4 || entries["main.go"].SHA256 != digest("package main") || entries["main.go"].Size != int64(len("package main")) {
+		t.Fatalf("Unexpected source tree: %+v", files.Source)
+	}
+	if entries["lib"].Mode[0] != 'd' || entries["lib/lib.go"].SHA256 != digest("package lib") {
+		t.Fatalf("Unexpected source tree: %+v", files.Source)
+	}
+	if entries["link"].Link != "lib/lib.go" || entries["link"].SHA256 != "" || entries["link"].Mode[0] != 'L' {
+		t.Fatalf("Unexpected symlink entry: %+v", entries["link"])
+	}
+	if len(files.Package) != 0 || files.Packages != nil {
+		t.Fatalf("Expected empty package tree before the build, got %+v %+v", files.Package, files.Packages)
+	}
+
+	storeTestPackage(t, m, single.ID, "", "binary")
+	// digests of imported files are taken from the blob store
+	m.importFiles(single.ID, filepath.Join(source.OrdersDir, single.ID))
+	files = orderFiles{}
+	json.Unmarshal(request("/orders/single/files").Body.Bytes(), &files)
+	if len(files.Package) != 1 || files.Package[0].Path != "app" || files.Package[0].SHA256 != digest("binary") {
+		t.Fatalf("Unexpected package tree: %+v", files.Package)
+	}
+	if len(files.Source) != 4 {
+		t.Fatalf("Unexpected source tree after import: %+v", files.Source)
+	}
+
+	storeTestPackage(t, m, matrix.ID, "arm", "arm binary")
+	files = orderFiles{}
+	json.Unmarshal(request("/orders/matrix/files").Body.Bytes(), &files)
+	if files.Package != nil || len(files.Packages) != 2 || len(files.Packages["amd64"]) != 0 ||
+		len(files.Packages["arm"]) != 1 || files.Packages["arm"][0].SHA256 != digest("arm binary") {
+		t.Fatalf("Unexpected packages: %+v", files.Packages)
+	}
+
+	files = orderFiles{}
+	json.Unmarshal(request("/orders/deploy/files").Body.Bytes(), &files)
+	if len(files.Source) != 1 || files.Package != nil || files.Packages != nil {
+		t.Fatalf("Unexpected files of %s: %+v", deployOnly.ID, files)
+	}
+
+	if w := request("/orders/missing/files"); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for missing order, got %d", w.Code)
+	}
+
+	// packages
+	for path, code := range map[string]int{
+		"/orders/missing/package":             http.StatusNotFound,
+		"/orders/deploy/package":              http.StatusNotFound, // no build
+		"/orders/single/package?arch=arm":     http.StatusBadRequest,
+		"/orders/single/package?format=rar":   http.StatusBadRequest,
+		"/orders/matrix/package":              http.StatusBadRequest,
+		"/orders/matrix/package?arch=386":     http.StatusBadRequest,
+		"/orders/matrix/package?arch=amd64":   http.StatusNotFound, // not built yet
+		"/orders/matrix/package?arch=arm":     http.StatusOK,
+		"/orders/single/package":              http.StatusOK,
+		"/orders/single/package?format=zip":   http.StatusOK,
+		"/orders/matrix/package?arch=arm&x=1": http.StatusOK,
+	} {
+		if w := request(path); w.Code != code {
+			t.Fatalf("Expected %d for %s, got %d: %s", code, path, w.Code, w.Body)
+		}
+	}
+
+	// the format defaults to that of the order and can be overridden
+	for path, expected := range map[string]struct{ format, name string }{
+		"/orders/single/package":                        {model.ArchiveTarGz, "single.tar.gz"},
+		"/orders/single/package?format=zip":             {model.ArchiveZip, "single.zip"},
+		"/orders/matrix/package?arch=arm":               {model.ArchiveZip, "matrix-arm.zip"},
+		"/orders/matrix/package?arch=arm&format=tar.gz": {model.ArchiveTarGz, "matrix-arm.tar.gz"},
+	} {
+		w := request(path)
+		if format := model.DetectArchive(w.Body.Bytes()); format != expected.format {
+			t.Fatalf("Expected %s archive for %s, got %q", expected.format, path, format)
+		}
+		if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="`+expected.name+`"` {
+			t.Fatalf("Unexpected content disposition for %s: %s", path, disposition)
+		}
+		dir := filepath.Join(source.OrdersDir, "extracted", expected.name)
+		err := model.DecompressFiles(w.Body.Bytes(), dir)
+		if err != nil {
+			t.Fatalf("Error extracting package of %s: %s", path, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, source.PackageDir, "app")); err != nil {
+			t.Fatalf("Package of %s does not contain the artifact: %s", path, err)
+		}
+	}
+}
@@ -21,6 +21,8 @@ const (
 	EnvZeromqPubPort  = "ZEROMQ_PUB_PORT" // Changes are not propagated to existing agents
 	EnvZeromqSubPort  = "ZEROMQ_SUB_PORT" // Changes are not propagated to existing agents
 	EnvHTTPServerPort = "HTTP_SERVER_PORT"
+	EnvRetainOrders   = "RETAIN_ORDERS" // number of latest orders whose files are kept. Files of all orders are kept if not set
+	EnvRetainPeriod   = "RETAIN_PERIOD" // files of orders created within this period are kept e.g. 720h
 	// Defaults
 	DefaultStorageDSN     = "http://localhost:9200"
 	DefaultZeromqPubPort  = "5556"
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"code.linksmart.eu/dt/deployment-tool/manager/blob"
 	"code.linksmart.eu/dt/deployment-tool/manager/model"
 	"code.linksmart.eu/dt/deployment-tool/manager/source"
 	"code.linksmart.eu/dt/deployment-tool/manager/storage"
@@ -21,17 +23,40 @@ type manager struct {
 	responseBuffer chan *model.Response
 	events         *pubsub.PubSub
 	zmqConf        model.ZeromqServerInfo
+	statusLocker   orderLocker              // per order, as responses of different orders are independent
+	rollouts       map[string]chan struct{} // order id: stop channel
+	rolloutLocker  sync.Mutex
+	scheduleLocker sync.Mutex
+	secretKeys     *secretKeys
+	// secret values of recent orders, to be redacted from logs
+	redactionCache  map[string]*redaction
+	redactionOrders []string // cached order ids, oldest first
+	redactionLocker sync.Mutex
+	// deployments of targets, for rollbacks and replacing targets
+	deploymentLocker sync.Mutex
+	pipelineLocker   sync.Mutex
+	// received packages of build matrices
+	packageLocker sync.Mutex
+	// content-addressed files of orders
+	blobs     *blob.Store
+	gcLocker  sync.Mutex
+	retention retention
+	// private key for signing task artifacts
+	signingKey []byte
 }
 
 const (
-	EventLogs          = "logs"
-	EventTargetAdded   = "targetAdded"
-	EventTargetUpdated = "targetUpdated"
-	EventChannelCap    = 10
-	ResponseBufferCap  = 100
-	TokenLength        = 12
-	TokenValidityDays  = 7
-	TokenPurgeInterval = time.Hour
+	EventLogs           = "logs"
+	EventTargetAdded    = "targetAdded"
+	EventTargetUpdated  = "targetUpdated"
+	EventOrderStatus    = "orderStatus"
+	EventPipelineStatus = "pipelineStatus"
+	EventChannelCap     = 10
+	ResponseBufferCap   = 100
+	TokenLength         = 12
+	TokenValidityDays   = 7
+	TokenPurgeInterval  = time.Hour
+	RedactionCacheSize  = 100 // orders
 )
 
 type event struct {
@@ -46,15 +71,35 @@ func startManager(pipe model.Pipe, zmqConf model.ZeromqServerInfo, storageClient
 		responseBuffer: make(chan *model.Response, ResponseBufferCap),
 		events:         pubsub.New(EventChannelCap),
 		zmqConf:        zmqConf,
+		rollouts:       make(map[string]chan struct{}),
+		redactionCache: make(map[string]*redaction),
 	}
 
-	// create ca keys for swarmio
-	_, _, err := swarmio.CreateKeys(true)
+	var err error
+	m.secretKeys, err = loadSecretKeys()
+	if err != nil {
+		return nil, fmt.Errorf("error loading keys for secrets: %s", err)
+	}
+
+	m.blobs, err = blob.New(blob.DefaultDir)
+	if err != nil {
+		return nil, err
+	}
+	m.retention, err = loadRetention()
+	if err != nil {
+		return nil, fmt.Errorf("error loading retention policy: %s", err)
+	}
+
+	// create ca keys for swarmio, also used to sign artifacts
+	_, m.signingKey, err = swarmio.CreateKeys(true)
 	if err != nil {
 		return nil, fmt.Errorf("error creating keys for CA: %s", err)
 	}
 
 	go m.purgeExpiredTokens()
+	go m.scheduler()
+	go m.pipelineRunner()
+	go m.gcRunner()
 	go m.manageResponses()
 	return m, nil
 }
@@ -63,22 +108,72 @@ func (m *manager) addOrder(order *storage.Order) error {
 
 	order.Created = model.UnixTime()
 
+	err := m.prepareOrder(order)
+	if err != nil {
+		return err
+	}
+
+	order.Status = storage.NewOrderStatus(m.getTargetList(order))
+	if order.Schedule != nil {
+		order.Schedule.Started = order.Schedule.Due(time.Now())
+		order.Schedule.Pending = nil
+	}
+
+generateID:
+	retry := 0
+	order.ID = m.newTaskID(retry)
+	if tempOrder, err := m.storage.GetOrder(order.ID); err != nil {
+		return fmt.Errorf("error checking if order ID is unique: %s", err)
+	} else if tempOrder != nil { // found order with this id
+		retry++
+		goto generateID
+	}
+
+	// place into work directory
+	order.Commit, err = m.fetchSource(order.ID, order.Source)
+	if err != nil {
+		return fmt.Errorf("error fetching source files: %s", err)
+	}
+	m.importFiles(order.ID, fmt.Sprintf("%s/%s", source.OrdersDir, order.ID))
+
+	order.Source = nil
+	_, err = m.storage.AddOrder(order)
+	if err != nil {
+		return fmt.Errorf("error storing order: %s", err)
+	}
+	log.Println("Added order:", order.ID)
+
+	if order.Schedule != nil && !order.Schedule.Started {
+		m.logScheduled(order)
+		return nil
+	}
+	go m.composeTask(order)
+	return nil
+}
+
+// prepareOrder removes empty stages, checks the build host and secrets, and matches the deploy targets
+func (m *manager) prepareOrder(order *storage.Order) error {
 	// cleanup
-	if order.Build != nil && len(order.Build.Commands)+len(order.Build.Artifacts)+len(order.Build.Host) == 0 {
+	if order.Build != nil && len(order.Build.Commands)+len(order.Build.Artifacts)+len(order.Build.Host)+len(order.Build.Matrix) == 0 {
 		order.Build = nil
 	}
-	if order.Deploy != nil && len(order.Deploy.Install.Commands)+len(order.Deploy.Run.Commands) == 0 {
+	if order.Deploy != nil && len(order.Deploy.Install.Commands)+len(order.Deploy.Run.Commands) == 0 && order.Deploy.Container == nil {
 		order.Deploy = nil
 	}
 
-	// check if build host exists
+	// check if build hosts exist
 	if order.Build != nil {
-		target, err := m.storage.GetTarget(order.Build.Host)
-		if err != nil {
-			return fmt.Errorf("error getting build host: %s", err)
-		}
-		if target == nil {
-			return fmt.Errorf("build host not found: %s", order.Build.Host)
+		for _, host := range order.Build.Hosts() {
+			target, err := m.storage.GetTarget(host)
+			if err != nil {
+				return fmt.Errorf("error getting build host: %s", err)
+			}
+			if target == nil {
+				return fmt.Errorf("build host not found: %s", host)
+			}
+			if arch := order.Build.ArchOf(host); arch != "" && target.Arch != "" && target.Arch != arch {
+				return fmt.Errorf("build host %s has arch %s instead of %s", host, target.Arch, arch)
+			}
 		}
 	}
 
@@ -97,30 +192,20 @@ func (m *manager) addOrder(order *storage.Order) error {
 		order.Deploy.Match.List = receivers
 	}
 
-generateID:
-	retry := 0
-	order.ID = m.newTaskID(retry)
-	if tempOrder, err := m.storage.GetOrder(order.ID); err != nil {
-		return fmt.Errorf("error checking if order ID is unique: %s", err)
-	} else if tempOrder != nil { // found order with this id
-		retry++
-		goto generateID
-	}
-
-	// place into work directory
-	err := m.fetchSource(order.ID, order.Source)
-	if err != nil {
-		return fmt.Errorf("error fetching source files: %s", err)
+	// check if secrets exist
+	if len(order.Secrets) > 0 {
+		err := m.checkSecrets(order.Secrets)
+		if err != nil {
+			return err
+		}
 	}
-
-	order.Source = nil
-	_, err = m.storage.AddOrder(order)
-	if err != nil {
-		return fmt.Errorf("error storing order: %s", err)
+	if order.Source != nil && order.Source.URL != nil && order.Source.URL.Auth != nil {
+		err := m.checkSecrets([]model.SecretRef{{Name: order.Source.URL.Auth.Secret}})
+		if err != nil {
+			return fmt.Errorf("source: %s", err)
+		}
 	}
-	log.Println("Added order:", order.ID)
 
-	go m.composeTask(order)
 	return nil
 }
 
@@ -159,6 +244,8 @@ func (m *manager) getOrder(id string) (*storage.Order, error) {
 }
 
 func (m *manager) deleteOrder(id string) (found bool, err error) {
+	m.stopRollout(id)
+	m.forgetRedactions(id)
 	// remove logs
 	err = m.storage.DeleteLogs("", id)
 	if err != nil {
@@ -169,6 +256,11 @@ func (m *manager) deleteOrder(id string) (found bool, err error) {
 	if err != nil {
 		return false, fmt.Errorf("error querying order: %s", err)
 	}
+	// remove the files, blobs which are no longer referenced are removed by the next garbage collection
+	err = m.removeOrderFiles(id)
+	if err != nil {
+		return found, fmt.Errorf("error removing order files: %s", err)
+	}
 	return found, nil
 }
 
@@ -180,6 +272,11 @@ func (m *manager) stopOrder(id string) (found bool, list []string, err error) {
 	if order == nil {
 		return false, nil, nil
 	}
+	m.stopRollout(id)
+	err = m.stopSchedule(id)
+	if err != nil {
+		return true, nil, err
+	}
 
 	list = m.getTargetList(order)
 	for i := range list {
@@ -188,21 +285,82 @@ func (m *manager) stopOrder(id string) (found bool, list []string, err error) {
 	return true, list, nil
 }
 
+// retryOrder resends the order to targets that have failed or to which the task was not delivered
+//	A failed build is repeated, otherwise the deploy task is resent using the stored source or package of the order.
+//	Maintenance windows and rollout batches are not applied to retries.
+func (m *manager) retryOrder(id string) (found bool, targets []string, err error) {
+	order, err := m.storage.GetOrder(id)
+	if err != nil {
+		return false, nil, fmt.Errorf("error querying order: %s", err)
+	}
+	if order == nil {
+		return false, nil, nil
+	}
+	if order.Status == nil {
+		return true, nil, nil
+	}
+	if order.Schedule != nil && !order.Schedule.Started {
+		return true, nil, nil
+	}
+	targets = order.Status.Retriable()
+	if len(targets) == 0 {
+		return true, nil, nil
+	}
+
+	// repeat the failed builds, which continue to deploy once the packages are received
+	var hosts []string
+	if order.Build != nil {
+		for _, host := range order.Build.Hosts() {
+			if inBatch(host, targets) && !m.packageExists(id, order.Build.ArchOf(host)) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if len(hosts) > 0 {
+		log.Printf("Retrying build of %s on %s", id, hosts)
+		m.storeLog(id, model.StageBuild, "retrying", false, hosts...)
+		go func() {
+			defer recovery()
+			task, ok := m.buildTask(order)
+			if !ok {
+				return
+			}
+			task.Retry = model.UnixTime()
+			m.sendTask(task, storage.Match{IDs: hosts, List: hosts})
+		}()
+		return true, hosts, nil
+	}
+	if order.Deploy == nil {
+		return true, nil, nil
+	}
+
+	// only resend to the deploy targets
+	var list []string
+	for _, target := range targets {
+		if inBatch(target, order.Deploy.Match.List) {
+			list = append(list, target)
+		}
+	}
+	if len(list) == 0 {
+		return true, nil, nil
+	}
+	log.Printf("Retrying %s on %d target(s)", id, len(list))
+	m.storeLog(id, model.StageInstall, "retrying", false, list...)
+	go m.redeploy(order, list)
+	return true, list, nil
+}
+
 func (m *manager) getTargetList(order *storage.Order) []string {
 	var list []string
 	if order.Deploy != nil {
 		list = append(list, order.Deploy.Match.List...)
 	}
 	if order.Build != nil {
-		var exist bool
-		for i := range list {
-			if order.Build.Host == list[i] {
-				exist = true
+		for _, host := range order.Build.Hosts() {
+			if !inBatch(host, list) {
+				list = append(list, host)
 			}
 		}
-		if !exist {
-			list = append(list, order.Build.Host)
-		}
 	}
 	return list
 }
@@ -248,7 +406,11 @@ func (m *manager) stopTargetOrders(targetID string) (found bool, err error) {
 	return true, nil
 }
 
+// updateTarget replaces the target and pushes changes in tags or location to the target
 func (m *manager) updateTarget(id string, target *storage.Target) (found bool, err error) {
+	m.deploymentLocker.Lock()
+	defer m.deploymentLocker.Unlock()
+
 	t, err := m.storage.GetTarget(id)
 	if err != nil {
 		return false, fmt.Errorf("error getting target: %s", err)
@@ -258,15 +420,24 @@ func (m *manager) updateTarget(id string, target *storage.Target) (found bool, e
 	}
 	// read-only fields remain the same
 	target.ID = t.ID
+	target.PublicKeySwarmio = t.PublicKeySwarmio
 	target.LogRequestAt = t.LogRequestAt
 	target.CreatedAt = t.CreatedAt
+	target.Deployments = t.Deployments
 
 	target.UpdatedAt = model.UnixTime()
 
+	configChanged := !target.SameConfig(t)
+	target.ConfigPending = t.ConfigPending || configChanged
+
 	found, err = m.storage.IndexTarget(target)
 	if err != nil {
 		return false, fmt.Errorf("error updating target: %s", err)
 	}
+
+	if configChanged {
+		m.requestConfigUpdate(target)
+	}
 	return found, nil
 }
 
@@ -379,8 +550,8 @@ func (m *manager) deleteTokenSet(name string) error {
 	return nil
 }
 
-func (m *manager) signPublicKey(publicKey []byte) (*swarmio.Cert, error) {
-	return swarmio.SignPublicKey(publicKey)
+func (m *manager) signPublicKey(targetID string, publicKey []byte) (*swarmio.Cert, error) {
+	return swarmio.SignPublicKey(targetID, publicKey)
 }
 
 func (m *manager) registerTarget(target *storage.Target, secret string) (authorized, conflict bool, err error) {
@@ -458,18 +629,29 @@ type tokenSetSecret struct {
 	Tokens []string `json:"tokens"`
 }
 
-func (m *manager) fetchSource(orderID string, src *source.Source) error {
+// fetchSource places the source into order directory and returns the commit of git sources
+func (m *manager) fetchSource(orderID string, src *source.Source) (commit string, err error) {
 	switch {
 	case src == nil:
-		return nil
+		return "", nil
 	case src.Paths != nil:
-		return src.Paths.Copy(orderID)
+		return "", src.Paths.Copy(orderID)
 	case src.Zip != nil:
-		return src.Zip.Store(orderID)
+		return "", src.Zip.Store(orderID)
 	case src.Order != nil:
-		return src.Order.Fetch(orderID)
+		return "", src.Order.Fetch(orderID)
+	case src.Git != nil:
+		return src.Git.Clone(orderID)
+	case src.URL != nil:
+		credential, err := m.urlCredential(src.URL)
+		if err != nil {
+			return "", err
+		}
+		return "", src.URL.Download(orderID, credential)
+	case src.Upload != nil:
+		return "", src.Upload.Extract(orderID)
 	}
-	return nil
+	return "", nil
 }
 
 func (m *manager) processPackage(p *model.Package) {
@@ -484,30 +666,45 @@ func (m *manager) processPackage(p *model.Package) {
 	log.Println("processPackage", p.Task, p.Assembler, len(p.Payload))
 	m.storeLog(p.Task, model.StageBuild, fmt.Sprintf("received package sized %d bytes", len(p.Payload)), false, p.Assembler)
 
-	err := m.storePackage(p.Task, p.Payload)
+	order, err := m.storage.GetOrder(p.Task)
 	if err != nil {
-		m.storeLogFatal(p.Task, model.StageBuild, "error decompressing assembled package", p.Assembler)
+		m.storeLogFatal(p.Task, model.StageBuild, fmt.Sprintf("error querying order: %s", err), p.Assembler)
+		return
+	}
+	if order == nil || order.Build == nil {
+		log.Printf("Dropping package of %s: order or build is not found", p.Task)
 		return
 	}
 
-	order, err := m.storage.GetOrder(p.Task)
+	// packages of a build matrix are stored per architecture and deployed once all are received
+	arch := order.Build.ArchOf(p.Assembler)
+	if arch == "" && len(order.Build.Matrix) > 0 {
+		log.Printf("Dropping package of %s: %s is not a build host", p.Task, p.Assembler)
+		return
+	}
+	m.packageLocker.Lock()
+	complete := m.packagesComplete(order)
+	err = m.storePackage(p.Task, arch, p.Payload)
 	if err != nil {
-		m.storeLogFatal(p.Task, model.StageBuild, fmt.Sprintf("error querying order: %s", err), p.Assembler)
+		m.packageLocker.Unlock()
+		m.storeLogFatal(p.Task, model.StageBuild, "error decompressing assembled package", p.Assembler)
 		return
 	}
+	m.recordBuild(order, arch)
+	ready := arch == "" || !complete && m.packagesComplete(order)
+	m.packageLocker.Unlock()
 
 	m.storeLog(p.Task, model.StageBuild, model.StageEnd, false, p.Assembler)
 
 	// continue to deploy, if required
-	if order.Deploy != nil {
-		order.Build = nil
-		m.composeTask(order)
+	if order.Deploy != nil && ready {
+		m.composeDeploy(order)
 	}
 }
 
-func (m *manager) compressSource(orderID string) ([]byte, error) {
+func (m *manager) compressSource(orderID, format string) ([]byte, error) {
 	if path, found := m.sourcePath(orderID); found {
-		compressedArchive, err := model.CompressFiles(path)
+		compressedArchive, err := model.CompressFilesAs(format, path)
 		if err != nil {
 			return nil, err
 		}
@@ -516,73 +713,134 @@ func (m *manager) compressSource(orderID string) ([]byte, error) {
 	return nil, nil
 }
 
-// decompress and write to package directory
-func (m *manager) storePackage(orderID string, archive []byte) error {
-	return model.DecompressFiles(archive, fmt.Sprintf("%s/%s/%s", source.OrdersDir, orderID, source.PackageDir))
+// decompress and write to package directory of the architecture
+func (m *manager) storePackage(orderID, arch string, archive []byte) error {
+	dir := fmt.Sprintf("%s/%s", m.archWorkDir(orderID, arch), source.PackageDir)
+	err := replaceDir(dir, func(tempDir string) error {
+		return model.DecompressFiles(archive, tempDir)
+	})
+	if err != nil {
+		return err
+	}
+	m.importFiles(orderID, dir)
+	return nil
 }
 
 func (m *manager) composeTask(order *storage.Order) {
 	defer recovery()
 	// a single order can result in two tasks: build and deploy
 	if order.Build != nil {
-		m.storeLog(order.ID, model.StageBuild, model.StageStart, false, order.Build.Host)
-
-		compressedArchive, err := m.compressSource(order.ID)
-		if err != nil {
-			m.storeLogFatal(order.ID, model.StageBuild, fmt.Sprintf("error compressing files: %s", err), order.Build.Host)
+		hosts := order.Build.Hosts() // one device per architecture
+		m.storeLog(order.ID, model.StageBuild, model.StageStart, false, hosts...)
+
+		hosts = m.reuseBuilds(order)
+		if len(hosts) == 0 {
+			// continue to deploy, if required
+			if order.Deploy != nil {
+				m.composeDeploy(order)
+			}
 			return
 		}
-		if len(compressedArchive) > 0 {
-			m.storeLog(order.ID, model.StageBuild, fmt.Sprintf("compressed to %d bytes", len(compressedArchive)), false, order.Build.Host)
-		}
 
-		task := model.Task{
-			Header:    order.Header,
-			Build:     &order.Build.Build,
-			Artifacts: compressedArchive,
-		}
-		err = task.Validate()
-		if err != nil {
-			m.storeLogFatal(order.ID, model.StageBuild, fmt.Sprintf("invalid task: %s", err), order.Build.Host)
+		task, ok := m.buildTask(order)
+		if !ok {
 			return
 		}
 
-		match := storage.Match{IDs: []string{order.Build.Host}, List: []string{order.Build.Host}} // just one device
-		m.sendTask(&task, match)
+		m.sendTask(task, storage.Match{IDs: hosts, List: hosts})
 	} else {
-		m.storeLog(order.ID, model.StageInstall, model.StageStart, false, order.Deploy.Match.List...)
+		m.composeDeploy(order)
+	}
+
+}
+
+// composeDeploy sends the deploy task of the order, after the build if any
+func (m *manager) composeDeploy(order *storage.Order) {
+	m.storeLog(order.ID, model.StageInstall, model.StageStart, false, order.Deploy.Match.List...)
+
+	task, ok := m.deployTask(order, order.Deploy.Match.List)
+	if !ok {
+		return
+	}
+
+	switch {
+	case order.Schedule != nil && len(order.Schedule.Windows) > 0:
+		m.dispatchInWindows(order, task, order.Deploy.Match.List)
+	case order.Deploy.Rollout != nil:
+		m.rollout(task, order)
+	default:
+		m.sendDeploy(order, task, order.Deploy.Match)
+	}
+}
 
-		compressedArchive, err := m.compressSource(order.ID)
+// buildTask composes the build task of the order, logging errors for the build hosts
+func (m *manager) buildTask(order *storage.Order) (*model.Task, bool) {
+	hosts := order.Build.Hosts()
+	compressedArchive, err := m.compressSource(order.ID, order.Archive)
+	if err != nil {
+		m.storeLogFatal(order.ID, model.StageBuild, fmt.Sprintf("error compressing files: %s", err), hosts...)
+		return nil, false
+	}
+	if len(compressedArchive) > 0 {
+		m.storeLog(order.ID, model.StageBuild, fmt.Sprintf("compressed to %d bytes", len(compressedArchive)), false, hosts...)
+	}
+
+	task := model.Task{
+		Header:    order.Header,
+		Build:     &order.Build.Build,
+		Template:  order.Template,
+		Secrets:   order.Secrets,
+		Artifacts: compressedArchive,
+		Archive:   order.Archive,
+		Pull:      order.Pull,
+	}
+	err = task.Validate()
+	if err != nil {
+		m.storeLogFatal(order.ID, model.StageBuild, fmt.Sprintf("invalid task: %s", err), hosts...)
+		return nil, false
+	}
+	return &task, true
+}
+
+// deployTask composes the deploy task of the order, logging errors for the given targets
+//	The artifacts of orders with build matrix are added per architecture by sendDeploy.
+func (m *manager) deployTask(order *storage.Order, targets []string) (*model.Task, bool) {
+	var compressedArchive []byte
+	if order.Build == nil || len(order.Build.Matrix) == 0 {
+		var err error
+		compressedArchive, err = m.compressSource(order.ID, order.Archive)
 		if err != nil {
-			m.storeLogFatal(order.ID, model.StageInstall, fmt.Sprintf("error compressing files: %s", err), order.Deploy.Match.List...)
+			m.storeLogFatal(order.ID, model.StageInstall, fmt.Sprintf("error compressing files: %s", err), targets...)
+			return nil, false
 		}
 		if len(compressedArchive) > 0 {
-			m.storeLog(order.ID, model.StageInstall, fmt.Sprintf("compressed to %d bytes", len(compressedArchive)), false, order.Deploy.Match.List...)
+			m.storeLog(order.ID, model.StageInstall, fmt.Sprintf("compressed to %d bytes", len(compressedArchive)), false, targets...)
 		}
-
-		task := model.Task{
-			Header:    order.Header,
-			Deploy:    &order.Deploy.Deploy,
-			Artifacts: compressedArchive,
-		}
-		err = task.Validate()
-		if err != nil {
-			m.storeLogFatal(order.ID, model.StageInstall, fmt.Sprintf("invalid task: %s", err), order.Deploy.Match.List...)
-			return
-		}
-
-		m.sendTask(&task, order.Deploy.Match)
 	}
 
+	task := model.Task{
+		Header:    order.Header,
+		Deploy:    &order.Deploy.Deploy,
+		Template:  order.Template,
+		Secrets:   order.Secrets,
+		Artifacts: compressedArchive,
+		Archive:   order.Archive,
+		Pull:      order.Pull,
+	}
+	err := task.Validate()
+	if err != nil {
+		m.storeLogFatal(order.ID, model.StageInstall, fmt.Sprintf("invalid task: %s", err), targets...)
+		return nil, false
+	}
+	return &task, true
 }
 
 func (m *manager) sendTask(task *model.Task, match storage.Match) {
 
-	receiverTopics := m.targetTopics(match.IDs, match.Tags)
-
 	ann := model.Announcement{
 		Header: task.Header,
 		Size:   len(task.Artifacts),
+		Retry:  task.Retry,
 	}
 
 	var stage string
@@ -598,11 +856,73 @@ func (m *manager) sendTask(task *model.Task, match storage.Match) {
 	const maxAttempt = 3
 	pending := make([]string, len(match.List))
 	copy(pending, match.List)
+	m.setTargetStates(task.ID, storage.StateSent, match.List...)
 	m.storeLog(task.ID, stage, "sending task", false, match.List...)
 
+	// encrypt secrets to each target
+	var secretMessages map[string][]byte
+	if len(task.Secrets) > 0 {
+		values, err := m.secretValues(task.Secrets)
+		if err != nil {
+			m.setTargetStates(task.ID, storage.StateFailed, match.List...)
+			m.storeLogFatal(task.ID, stage, fmt.Sprintf("error getting secrets: %s", err), match.List...)
+			return
+		}
+		var failed map[string]string
+		secretMessages, failed = m.sealSecrets(task.ID, values, match.List)
+		for target, message := range failed {
+			m.setTargetStates(task.ID, storage.StateFailed, target)
+			m.storeLogFatal(task.ID, stage, message, target)
+		}
+		if len(failed) > 0 {
+			// address the remaining targets by id, as tags may also cover the failed ones
+			var list []string
+			for _, target := range match.List {
+				if _, found := failed[target]; !found {
+					list = append(list, target)
+				}
+			}
+			if len(list) == 0 {
+				return
+			}
+			match = storage.Match{IDs: list, List: list}
+			pending = append([]string{}, list...)
+		}
+	}
+	receiverTopics := m.targetTopics(match.IDs, match.Tags)
+
+	// sign the task and artifacts to be verified by the targets, on a copy as the task may be sent concurrently
+	signed := *task
+	signed.Digest = task.TaskDigest()
+	signature, err := swarmio.Sign(m.signingKey, signed.Digest)
+	if err != nil {
+		m.setTargetStates(task.ID, storage.StateFailed, match.List...)
+		m.storeLogFatal(task.ID, stage, fmt.Sprintf("error signing task: %s", err), match.List...)
+		return
+	}
+	signed.Signature = signature
+
+	// leave the artifacts to be downloaded by the targets
+	if task.Pull && len(task.Artifacts) > 0 {
+		signed.Download, err = m.storeArtifacts(task.ID, signed.Digest, task.Artifacts)
+		if err != nil {
+			m.setTargetStates(task.ID, storage.StateFailed, match.List...)
+			m.storeLogFatal(task.ID, stage, fmt.Sprintf("error storing artifacts for download: %s", err), match.List...)
+			return
+		}
+		signed.Artifacts = nil
+	}
+
 	for attempt := 1; attempt <= maxAttempt; attempt++ {
 		//log.Printf("Sending task %s/%d to %s Attempt %d/%d", task.ID, ann.Type, receiverTopics, attempt, maxAttempt)
 
+		// send secrets ahead of the task, only to the respective targets
+		for _, target := range pending {
+			if b, found := secretMessages[target]; found {
+				m.pipe.RequestCh <- model.Message{model.FormatTopicID(target), b}
+			}
+		}
+
 		// send announcement
 		w := model.RequestWrapper{Announcement: &ann}
 		b, _ := json.Marshal(w)
@@ -614,7 +934,7 @@ func (m *manager) sendTask(task *model.Task, match storage.Match) {
 		time.Sleep(time.Second)
 
 		// send actual task
-		b, err := json.Marshal(&task)
+		b, err := json.Marshal(&signed)
 		if err != nil {
 			m.storeLogFatal(task.ID, stage, fmt.Sprintf("error serializing task: %s", err), match.List...)
 			return
@@ -647,6 +967,7 @@ func (m *manager) sendTask(task *model.Task, match storage.Match) {
 		m.storeLog(task.ID, stage, fmt.Sprintf("not delivered. Attempt %d/%d", attempt, maxAttempt), false, pending...)
 	}
 	if len(pending) > 0 {
+		m.setTargetStates(task.ID, storage.StateUndeliverable, pending...)
 		m.storeLogFatal(task.ID, stage, "unable to deliver", pending...)
 	}
 	log.Printf("Task %s/%d received by %d/%d.", task.ID, ann.Type, len(match.List)-len(pending), len(match.List))
@@ -687,6 +1008,19 @@ func (m *manager) terminalCommand(targetID, command string) error {
 	return nil
 }
 
+// requestConfigUpdate pushes the tags and location of the target to the target
+func (m *manager) requestConfigUpdate(target *storage.Target) {
+	w := model.RequestWrapper{
+		Time: model.UnixTime(),
+		Config: &model.TargetConfig{
+			Tags:     target.Tags,
+			Location: target.Location,
+		},
+	}
+	b, _ := json.Marshal(&w)
+	m.pipe.RequestCh <- model.Message{model.FormatTopicID(target.ID), b}
+}
+
 func (m *manager) requestStopAll(targetID string) {
 	stopAll := true
 	b, _ := json.Marshal(&model.RequestWrapper{StopAll: &stopAll})
@@ -698,7 +1032,7 @@ func (m *manager) manageResponses() {
 	go m.responseSorter()
 	for resp := range m.pipe.ResponseCh {
 		switch resp.Topic {
-		case model.ResponseAdvertisement:
+		case model.ResponseAdvertisement, model.ResponseConfigAck:
 			var target storage.Target
 			err := json.Unmarshal(resp.Payload, &target)
 			if err != nil {
@@ -706,7 +1040,7 @@ func (m *manager) manageResponses() {
 				log.Printf("payload was: %s", string(resp.Payload))
 				continue
 			}
-			go m.processTarget(&target)
+			go m.processTarget(&target, resp.Topic == model.ResponseConfigAck)
 		case model.ResponsePackage:
 			var pkg model.Package
 			err := json.Unmarshal(resp.Payload, &pkg)
@@ -730,17 +1064,55 @@ func (m *manager) manageResponses() {
 	}
 }
 
-func (m *manager) processTarget(target *storage.Target) {
+// processTarget updates the target with information reported in advertisement or config acknowledgement
+func (m *manager) processTarget(target *storage.Target, ack bool) {
 	defer recovery()
 	log.Println("Target adv:", target.ID, target.Tags, target.Location)
 
-	found, err := m.updateTarget(target.ID, target)
+	// the target is replaced, so deployments must not be recorded in the meantime
+	m.deploymentLocker.Lock()
+	defer m.deploymentLocker.Unlock()
+
+	t, err := m.storage.GetTarget(target.ID)
 	if err != nil {
-		log.Printf("Error updating target: %s", err)
+		log.Printf("Error getting target: %s", err)
 		return
 	}
-	if !found {
+	if t == nil {
 		log.Printf("Unable to update %s: not found.", target.ID)
+		return
+	}
+	// read-only fields remain the same
+	target.LogRequestAt = t.LogRequestAt
+	target.CreatedAt = t.CreatedAt
+	target.Deployments = t.Deployments
+	target.UpdatedAt = model.UnixTime()
+	// the advertised key is recorded only for targets registered before keys were stored
+	if len(t.PublicKeySwarmio) > 0 {
+		target.PublicKeySwarmio = t.PublicKeySwarmio
+	} else if len(target.PublicKeySwarmio) > 0 {
+		log.Printf("Recorded swarmio key of %s.", target.ID)
+	}
+
+	if t.ConfigPending {
+		if target.SameConfig(t) {
+			log.Printf("Target %s applied the config update.", target.ID)
+		} else {
+			// keep the centrally managed config and push it again
+			log.Printf("Target %s has not applied the config update.", target.ID)
+			target.Tags = t.Tags
+			target.Location = t.Location
+			target.ConfigPending = true
+			m.requestConfigUpdate(target)
+		}
+	} else if ack {
+		log.Printf("Unexpected config acknowledgement from %s.", target.ID)
+	}
+
+	_, err = m.storage.IndexTarget(target)
+	if err != nil {
+		log.Printf("Error updating target: %s", err)
+		return
 	}
 
 	m.publishEvent(EventTargetUpdated, target)
@@ -782,12 +1154,14 @@ func (m *manager) processResponse(response *model.Response) {
 	for i := range response.Logs {
 		logs[i] = storage.Log{Log: response.Logs[i], Target: response.TargetID}
 	}
+	m.redactLogs(logs)
 	err := m.storage.AddLogs(logs)
 	if err != nil {
 		log.Printf("Error storing logs: %s", err)
 		return
 	}
 	m.publishEvent(EventLogs, logs)
+	m.updateOrderStatus(logs)
 }
 
 func (m *manager) storeLog(order, stage, message string, error bool, targets ...string) {
@@ -809,6 +1183,7 @@ func (m *manager) storeLog(order, stage, message string, error bool, targets ...
 		return
 	}
 	m.publishEvent(EventLogs, logs)
+	m.updateOrderStatus(logs)
 }
 
 func (m *manager) storeLogFatal(order, stage, message string, targets ...string) {
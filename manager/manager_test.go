@@ -0,0 +1,443 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/blob"
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/secret"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"github.com/cskr/pubsub"
+	"golang.org/x/crypto/curve25519"
+)
+
+// memStorage keeps documents in memory, as serialized in the elastic storage
+//	Methods which are not needed by the tests are left to the nil interface.
+type memStorage struct {
+	storage.Storage
+	sync.Mutex
+	orders    map[string][]byte
+	targets   map[string][]byte
+	pipelines map[string][]byte
+	secrets   map[string][]byte
+	logs      []storage.Log
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{
+		orders:    make(map[string][]byte),
+		targets:   make(map[string][]byte),
+		pipelines: make(map[string][]byte),
+		secrets:   make(map[string][]byte),
+	}
+}
+
+func (s *memStorage) AddOrder(order *storage.Order) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if _, found := s.orders[order.ID]; found {
+		return true, nil
+	}
+	s.orders[order.ID], _ = json.Marshal(order)
+	return false, nil
+}
+
+func (s *memStorage) GetOrder(id string) (*storage.Order, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, found := s.orders[id]
+	if !found {
+		return nil, nil
+	}
+	var order storage.Order
+	return &order, json.Unmarshal(b, &order)
+}
+
+func (s *memStorage) GetOrders(descr string, sortAsc bool, from, size int) ([]storage.Order, int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	var orders []storage.Order
+	for _, b := range s.orders {
+		var order storage.Order
+		json.Unmarshal(b, &order)
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return (orders[i].Created < orders[j].Created) == sortAsc
+	})
+	total := int64(len(orders))
+	if from > len(orders) {
+		from = len(orders)
+	}
+	if from+size < len(orders) {
+		orders = orders[:from+size]
+	}
+	return orders[from:], total, nil
+}
+
+func (s *memStorage) UpdateOrderStatus(id string, status *storage.OrderStatus) (bool, error) {
+	return s.update(s.orders, id, map[string]interface{}{"status": status})
+}
+
+func (s *memStorage) UpdateOrderSchedule(id string, schedule *storage.Schedule) (bool, error) {
+	return s.update(s.orders, id, map[string]interface{}{"schedule": schedule})
+}
+
+func (s *memStorage) GetScheduledOrders() ([]storage.Order, error) {
+	s.Lock()
+	defer s.Unlock()
+	var orders []storage.Order
+	for _, b := range s.orders {
+		var order storage.Order
+		json.Unmarshal(b, &order)
+		if order.Schedule != nil && !order.Schedule.Stopped && (!order.Schedule.Started || len(order.Schedule.Pending) > 0) {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+// update merges the partial document into the stored one, as done by elastic partial updates:
+//	objects are merged recursively and other values are replaced
+func (s *memStorage) update(docs map[string][]byte, id string, partial interface{}) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, found := docs[id]
+	if !found {
+		return false, nil
+	}
+	var doc, fields map[string]interface{}
+	json.Unmarshal(b, &doc)
+	b, err := json.Marshal(partial)
+	if err != nil {
+		return false, err
+	}
+	json.Unmarshal(b, &fields)
+	mergeFields(doc, fields)
+	docs[id], err = json.Marshal(doc)
+	return true, err
+}
+
+func mergeFields(doc, fields map[string]interface{}) {
+	for k, v := range fields {
+		if obj, ok := v.(map[string]interface{}); ok {
+			if existing, ok := doc[k].(map[string]interface{}); ok {
+				mergeFields(existing, obj)
+				continue
+			}
+		}
+		doc[k] = v
+	}
+}
+
+func (s *memStorage) AddTarget(target *storage.Target) {
+	s.Lock()
+	defer s.Unlock()
+	s.targets[target.ID], _ = json.Marshal(target)
+}
+
+func (s *memStorage) GetTarget(id string) (*storage.Target, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, found := s.targets[id]
+	if !found {
+		return nil, nil
+	}
+	var target storage.Target
+	return &target, json.Unmarshal(b, &target)
+}
+
+func (s *memStorage) GetTargets(tags []string, from, size int) ([]storage.Target, int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	var targets []storage.Target
+	for _, b := range s.targets {
+		var target storage.Target
+		json.Unmarshal(b, &target)
+		if len(tags) == 0 || firstCommon(tags, target.Tags) != "" {
+			targets = append(targets, target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].ID < targets[j].ID })
+	total := int64(len(targets))
+	if from > len(targets) {
+		from = len(targets)
+	}
+	if from+size < len(targets) {
+		targets = targets[:from+size]
+	}
+	return targets[from:], total, nil
+}
+
+func (s *memStorage) IndexTarget(target *storage.Target) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	_, found := s.targets[target.ID]
+	s.targets[target.ID], _ = json.Marshal(target)
+	return found, nil
+}
+
+func (s *memStorage) PatchTarget(id string, target *storage.Target) (bool, error) {
+	return s.update(s.targets, id, target)
+}
+
+func (s *memStorage) UpdateTargetDeployments(id string, deployments []string) (bool, error) {
+	return s.update(s.targets, id, map[string]interface{}{"deployments": deployments})
+}
+
+func (s *memStorage) MatchTargets(ids, tags []string) (allIDs, hitIDs, hitTags []string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	for id, b := range s.targets {
+		var target storage.Target
+		json.Unmarshal(b, &target)
+		if tag := firstCommon(tags, target.Tags); tag != "" {
+			allIDs = append(allIDs, id)
+			if firstCommon([]string{tag}, hitTags) == "" {
+				hitTags = append(hitTags, tag)
+			}
+		} else if firstCommon(ids, []string{id}) != "" {
+			allIDs = append(allIDs, id)
+			hitIDs = append(hitIDs, id)
+		}
+	}
+	return allIDs, hitIDs, hitTags, nil
+}
+
+func firstCommon(a, b []string) string {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return x
+			}
+		}
+	}
+	return ""
+}
+
+func (s *memStorage) AddLogs(logs []storage.Log) error {
+	s.Lock()
+	defer s.Unlock()
+	s.logs = append(s.logs, logs...)
+	return nil
+}
+
+func (s *memStorage) DeliveredTask(target, task string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, l := range s.logs {
+		if l.Target == target && l.Task == task && l.Command == model.CommandByAgent {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memStorage) AddLog(log *storage.Log) error {
+	return s.AddLogs([]storage.Log{*log})
+}
+
+// Outputs returns the logged outputs of the task
+func (s *memStorage) Outputs(task string) string {
+	s.Lock()
+	defer s.Unlock()
+	var outputs []string
+	for _, l := range s.logs {
+		if l.Task == task {
+			outputs = append(outputs, l.Output)
+		}
+	}
+	return strings.Join(outputs, "\n")
+}
+
+func (s *memStorage) IndexSecret(secret *storage.Secret) error {
+	s.Lock()
+	defer s.Unlock()
+	s.secrets[secret.Name], _ = json.Marshal(secret)
+	return nil
+}
+
+func (s *memStorage) GetSecret(name string) (*storage.Secret, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, found := s.secrets[name]
+	if !found {
+		return nil, nil
+	}
+	var secret storage.Secret
+	return &secret, json.Unmarshal(b, &secret)
+}
+
+func (s *memStorage) DeleteSecret(name string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	_, found := s.secrets[name]
+	delete(s.secrets, name)
+	return found, nil
+}
+
+func (s *memStorage) AddPipeline(pipeline *storage.Pipeline) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.pipelines[pipeline.ID], _ = json.Marshal(pipeline)
+	return false, nil
+}
+
+func (s *memStorage) GetPipeline(id string) (*storage.Pipeline, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, found := s.pipelines[id]
+	if !found {
+		return nil, nil
+	}
+	var pipeline storage.Pipeline
+	return &pipeline, json.Unmarshal(b, &pipeline)
+}
+
+func (s *memStorage) UpdatePipeline(pipeline *storage.Pipeline) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if _, found := s.pipelines[pipeline.ID]; !found {
+		return false, nil
+	}
+	s.pipelines[pipeline.ID], _ = json.Marshal(pipeline)
+	return true, nil
+}
+
+func (s *memStorage) GetActivePipelines() ([]storage.Pipeline, error) {
+	s.Lock()
+	defer s.Unlock()
+	var pipelines []storage.Pipeline
+	for _, b := range s.pipelines {
+		var pipeline storage.Pipeline
+		json.Unmarshal(b, &pipeline)
+		if pipeline.State == storage.StateRunning {
+			pipelines = append(pipelines, pipeline)
+		}
+	}
+	return pipelines, nil
+}
+
+// startTestManager returns a manager working in a temporary directory, without the background routines
+//	Requests to targets are discarded. The returned function restores the work directory.
+func startTestManager(t *testing.T) (*manager, *memStorage, func()) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting work directory: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "manager")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("Error changing work directory: %s", err)
+	}
+
+	s := newMemStorage()
+	m := &manager{
+		storage:        s,
+		pipe:           model.NewPipe(),
+		events:         pubsub.New(EventChannelCap),
+		rollouts:       make(map[string]chan struct{}),
+		redactionCache: make(map[string]*redaction),
+	}
+	m.blobs, err = blob.New(blob.DefaultDir)
+	if err != nil {
+		t.Fatalf("Error creating blob store: %s", err)
+	}
+	_, m.signingKey, err = ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Error creating signing key: %s", err)
+	}
+	var private, public [secret.KeySize]byte
+	rand.Read(private[:])
+	curve25519.ScalarBaseMult(&public, &private)
+	m.secretKeys = &secretKeys{private: private[:], public: public[:]}
+
+	// drain the initial pipe only, so that tests can replace it to receive the requests
+	done := make(chan struct{})
+	pipe := m.pipe
+	go func() {
+		for {
+			select {
+			case <-pipe.RequestCh:
+			case <-pipe.OperationCh:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return m, s, func() {
+		close(done)
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// TestConfigPendingOnReconnect checks that a config update missed by an offline target is pushed again when it advertises
+func TestConfigPendingOnReconnect(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"local"}}})
+
+	// replace the pipe to receive the requests
+	m.pipe = model.Pipe{RequestCh: make(chan model.Message, 10)}
+	configRequest := func() *model.TargetConfig {
+		select {
+		case message := <-m.pipe.RequestCh:
+			var w model.RequestWrapper
+			json.Unmarshal(message.Payload, &w)
+			if message.Topic != model.FormatTopicID("gw") || w.Config == nil {
+				t.Fatalf("Expected config request to gw, got %s: %s", message.Topic, message.Payload)
+			}
+			return w.Config
+		default:
+			return nil
+		}
+	}
+	pending := func() bool {
+		target, _ := s.GetTarget("gw")
+		return target.ConfigPending
+	}
+
+	// the update is not received by the offline target
+	found, err := m.updateTarget("gw", &storage.Target{TargetBase: model.TargetBase{Tags: []string{"managed"}}})
+	if !found || err != nil {
+		t.Fatalf("Error updating target: %v %v", found, err)
+	}
+	if configRequest() == nil || !pending() {
+		t.Fatalf("Expected pending config update to be requested")
+	}
+
+	// the reconnected target advertises its local config
+	m.processTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"local"}}}, false)
+	conf := configRequest()
+	if conf == nil || len(conf.Tags) != 1 || conf.Tags[0] != "managed" {
+		t.Fatalf("Expected managed config to be pushed again, got %v", conf)
+	}
+	target, _ := s.GetTarget("gw")
+	if !target.ConfigPending || len(target.Tags) != 1 || target.Tags[0] != "managed" {
+		t.Fatalf("Expected managed tags to be kept as pending, got %v %v", target.Tags, target.ConfigPending)
+	}
+
+	// the acknowledgement clears the pending flag
+	m.processTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"managed"}}}, true)
+	if configRequest() != nil || pending() {
+		t.Fatalf("Expected acknowledged config to be applied")
+	}
+	// later advertisements with the same config push nothing
+	m.processTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"managed"}}}, false)
+	if configRequest() != nil || pending() {
+		t.Fatalf("Expected no config request after acknowledgement")
+	}
+}
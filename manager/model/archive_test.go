@@ -0,0 +1,121 @@
+package model
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestArchiveFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := dir + "/src"
+	os.MkdirAll(src+"/bin", 0755)
+	ioutil.WriteFile(src+"/bin/run.sh", []byte("echo hi"), 0750)
+	ioutil.WriteFile(src+"/config.yml", []byte("key: value"), 0600)
+	os.Symlink("bin/run.sh", src+"/run")
+	root := os.Geteuid() == 0
+	if root {
+		os.Chown(src+"/config.yml", 1234, 5678)
+	}
+
+	for _, format := range []string{ArchiveZip, ArchiveTarGz, ArchiveTarZst} {
+		t.Run(format, func(t *testing.T) {
+			if format == ArchiveTarZst {
+				if _, err := exec.LookPath("zstd"); err != nil {
+					t.Skip("zstd is not installed")
+				}
+			}
+			b, err := CompressFilesAs(format, src)
+			if err != nil {
+				t.Fatalf("Error compressing: %s", err)
+			}
+			if detected := DetectArchive(b); detected != format {
+				t.Fatalf("Detected format %s instead of %s", detected, format)
+			}
+			dest := dir + "/" + format
+			err = DecompressFiles(b, dest)
+			if err != nil {
+				t.Fatalf("Error decompressing: %s", err)
+			}
+			body, err := ioutil.ReadFile(dest + "/src/bin/run.sh")
+			if err != nil || string(body) != "echo hi" {
+				t.Fatalf("Unexpected content: %s %v", body, err)
+			}
+			if format == ArchiveZip {
+				return // the rest is only kept by tar
+			}
+
+			for path, mode := range map[string]os.FileMode{"bin/run.sh": 0750, "config.yml": 0600} {
+				info, err := os.Stat(dest + "/src/" + path)
+				if err != nil {
+					t.Fatalf("Error getting file info: %s", err)
+				}
+				if info.Mode().Perm() != mode {
+					t.Errorf("Mode of %s is %s instead of %s", path, info.Mode().Perm(), mode)
+				}
+			}
+			link, err := os.Readlink(dest + "/src/run")
+			if err != nil || link != "bin/run.sh" {
+				t.Errorf("Symlink not preserved: %s %v", link, err)
+			}
+			if root {
+				info, _ := os.Stat(dest + "/src/config.yml")
+				if stat := info.Sys().(*syscall.Stat_t); stat.Uid != 1234 || stat.Gid != 5678 {
+					t.Errorf("Ownership not preserved: %d:%d", stat.Uid, stat.Gid)
+				}
+			}
+		})
+	}
+
+	t.Run("illegal paths", func(t *testing.T) {
+		for name, entries := range map[string][]tar.Header{
+			"traversal": {{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}},
+			"absolute":  {{Name: "/../../evil", Typeflag: tar.TypeReg, Mode: 0644}},
+			"symlink":   {{Name: "link", Typeflag: tar.TypeSymlink, Linkname: dir}, {Name: "link/evil", Typeflag: tar.TypeReg, Mode: 0644}},
+		} {
+			var b bytes.Buffer
+			gw := gzip.NewWriter(&b)
+			tw := tar.NewWriter(gw)
+			for i := range entries {
+				tw.WriteHeader(&entries[i])
+			}
+			tw.Close()
+			gw.Close()
+
+			dest := dir + "/illegal/" + name
+			err := DecompressFiles(b.Bytes(), dest)
+			if err == nil {
+				t.Errorf("Expected error for %s", name)
+			}
+			if _, err := os.Stat(filepath.Join(dest, "../evil")); !os.IsNotExist(err) {
+				t.Errorf("File written outside of destination for %s", name)
+			}
+			if _, err := os.Stat(dir + "/evil"); !os.IsNotExist(err) {
+				t.Errorf("File written through symlink for %s", name)
+			}
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		for format, valid := range map[string]bool{"": true, "zip": true, "tar.gz": true, "tar.zst": true, "rar": false} {
+			err := ValidateArchive(format)
+			if valid && err != nil {
+				t.Errorf("Unexpected error for %s: %s", format, err)
+			}
+			if !valid && err == nil {
+				t.Errorf("Expected error for %s", format)
+			}
+		}
+	})
+}
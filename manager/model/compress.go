@@ -1,24 +1,74 @@
 package model
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 
 	"code.linksmart.eu/dt/deployment-tool/manager/env"
 	"github.com/mholt/archiver"
 )
 
-// CompressFiles reads from given path and compresses in memory
+// Archive formats of sources, packages and task artifacts
+const (
+	ArchiveZip    = "zip" // default
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarZst = "tar.zst" // requires the zstd command
+)
+
+var archiveMagic = map[string][]byte{
+	ArchiveZip:    {0x50, 0x4b, 0x03, 0x04},
+	ArchiveTarGz:  {0x1f, 0x8b},
+	ArchiveTarZst: {0x28, 0xb5, 0x2f, 0xfd},
+}
+
+// ValidateArchive returns an error if the archive format is not supported
+func ValidateArchive(format string) error {
+	if _, found := archiveMagic[format]; format != "" && !found {
+		return fmt.Errorf("unsupported archive format: %s. Supported formats are %s, %s and %s", format, ArchiveZip, ArchiveTarGz, ArchiveTarZst)
+	}
+	return nil
+}
+
+// DetectArchive returns the format of the archive starting with the given bytes, or empty if unknown
+func DetectArchive(header []byte) string {
+	for format, magic := range archiveMagic {
+		if bytes.HasPrefix(header, magic) {
+			return format
+		}
+	}
+	return ""
+}
+
+// CompressFiles reads from given path and compresses in memory as zip
 func CompressFiles(paths ...string) ([]byte, error) {
+	return CompressFilesAs(ArchiveZip, paths...)
+}
+
+// CompressFilesAs reads from given paths and compresses in memory in the given format
+//	Tar archives keep file modes, symlinks and ownership. The default format is zip.
+func CompressFilesAs(format string, paths ...string) ([]byte, error) {
 	if env.Debug {
-		log.Printf("Compressing: %v", paths)
+		log.Printf("Compressing as %s: %v", format, paths)
 	}
 	if len(paths) == 0 {
 		return nil, fmt.Errorf("no path provided")
 	}
 	var b bytes.Buffer
-	err := archiver.Zip.Write(&b, paths)
+	var err error
+	switch format {
+	case ArchiveZip, "":
+		err = archiver.Zip.Write(&b, paths)
+	case ArchiveTarGz:
+		err = writeTarGz(&b, paths)
+	case ArchiveTarZst:
+		err = writeTarZst(&b, paths)
+	default:
+		err = ValidateArchive(format)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -26,9 +76,31 @@ func CompressFiles(paths ...string) ([]byte, error) {
 }
 
 // DecompressFiles decompresses from memory and writes to given directory
+//	The format is detected from the content.
 func DecompressFiles(b []byte, dir string) error {
 	if env.Debug {
 		log.Printf("Decompressing %d bytes to %s", len(b), dir)
 	}
-	return archiver.Zip.Read(bytes.NewBuffer(b), dir)
+	return Extract(bytes.NewReader(b), dir)
+}
+
+// Extract reads the archive and writes to given directory
+//	The format is detected from the content.
+func Extract(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(4)
+	switch DetectArchive(header) {
+	case ArchiveZip:
+		// zip needs random access, unlike tar
+		b, err := ioutil.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		return archiver.Zip.Read(bytes.NewReader(b), dir)
+	case ArchiveTarGz:
+		return readTarGz(br, dir)
+	case ArchiveTarZst:
+		return readTarZst(br, dir)
+	}
+	return fmt.Errorf("unknown archive format")
 }
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DiffTree lists the files under dir and returns the relative paths of regular files which differ from baseDir
+//	Files in modified are considered changed, e.g. files which are modified on targets.
+func DiffTree(dir, baseDir string, modified map[string]bool) (files []DeltaFile, changed []string, err error) {
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		f := DeltaFile{Path: filepath.ToSlash(rel), Mode: uint32(info.Mode())}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			f.Link, err = os.Readlink(file)
+		case info.Mode().IsRegular():
+			f.Digest, err = FileDigest(file)
+			if err == nil && (modified[rel] || !sameFile(filepath.Join(baseDir, rel), info, f.Digest)) {
+				changed = append(changed, rel)
+			}
+		}
+		files = append(files, f)
+		return err
+	})
+	return files, changed, err
+}
+
+// sameFile tells if the file in base has the given content and mode
+func sameFile(path string, info os.FileInfo, digest []byte) bool {
+	baseInfo, err := os.Lstat(path)
+	if err != nil || !baseInfo.Mode().IsRegular() || baseInfo.Mode() != info.Mode() {
+		return false
+	}
+	if os.SameFile(info, baseInfo) {
+		return true // linked to the same blob
+	}
+	baseDigest, err := FileDigest(path)
+	return err == nil && bytes.Equal(baseDigest, digest)
+}
+
+// FileDigest returns the sha256 digest of the file
+func FileDigest(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// Apply completes the extracted delta in dir with unchanged files of baseDir
+//	The full tree is verified against the manifest.
+func (d *Delta) Apply(dir, baseDir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, f := range d.Files {
+		path := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if !strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal path: %s", f.Path)
+		}
+		mode := os.FileMode(f.Mode)
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(path, mode.Perm())
+		case mode&os.ModeSymlink != 0:
+			os.Remove(path)
+			err = os.Symlink(f.Link, path)
+		case mode.IsRegular():
+			if _, err := os.Lstat(path); os.IsNotExist(err) {
+				err = copyFile(filepath.Join(baseDir, filepath.FromSlash(f.Path)), path, mode.Perm())
+				if err != nil {
+					return fmt.Errorf("error copying %s from current deployment: %s", f.Path, err)
+				}
+			}
+			err = verifyFile(path, mode, f.Digest)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.Path, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dest string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// verifyFile checks that the path is a regular file with the given mode and sha256 digest
+func verifyFile(path string, mode os.FileMode, digest []byte) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file")
+	}
+	if info.Mode().Perm() != mode.Perm() {
+		err = os.Chmod(path, mode.Perm())
+		if err != nil {
+			return err
+		}
+	}
+	actual, err := FileDigest(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(actual, digest) {
+		return fmt.Errorf("digest mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeltaDigest(t *testing.T) {
+	task := Task{Artifacts: []byte("archive")}
+	full := task.TaskDigest()
+
+	task.Delta = &Delta{
+		Base:  "base",
+		Dir:   "src",
+		Files: []DeltaFile{{Path: "app.sh", Mode: 0755, Digest: []byte("digest")}},
+	}
+	delta := task.TaskDigest()
+	if string(delta) == string(full) {
+		t.Fatalf("Digest does not depend on delta")
+	}
+
+	// the manifest of unchanged files must be signed as well
+	task.Delta.Files[0].Mode = 0777
+	if string(task.TaskDigest()) == string(delta) {
+		t.Fatalf("Digest does not depend on delta manifest")
+	}
+	task.Delta.Files[0].Mode = 0755
+	task.Delta.Base = "other"
+	if string(task.TaskDigest()) == string(delta) {
+		t.Fatalf("Digest does not depend on delta base")
+	}
+}
+
+// TestDeltaRoundTrip diffs a deployment against its base and applies the delta as done by targets
+func TestDeltaRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delta")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(path, content string, mode os.FileMode) {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), mode)
+		os.Chmod(path, mode)
+	}
+	base, current := dir+"/base/src", dir+"/current/src"
+	write(base+"/changed.sh", "echo v1", 0755)
+	write(current+"/changed.sh", "echo v2", 0755)
+	write(base+"/lib/unchanged.txt", "same", 0644)
+	write(current+"/lib/unchanged.txt", "same", 0644)
+	write(base+"/removed.txt", "gone", 0644)
+	write(base+"/mode.sh", "echo mode", 0644)
+	write(current+"/mode.sh", "echo mode", 0755)
+	write(base+"/template.yml", "id: {{.TargetID}}", 0644) // rendered on targets
+	write(current+"/template.yml", "id: {{.TargetID}}", 0644)
+	os.Symlink("changed.sh", base+"/run")
+	os.Symlink("lib/unchanged.txt", current+"/run")
+
+	files, changed, err := DiffTree(current, base, map[string]bool{"template.yml": true})
+	if err != nil {
+		t.Fatalf("Error comparing trees: %s", err)
+	}
+	expected := map[string]bool{"changed.sh": true, "mode.sh": true, "template.yml": true}
+	if len(changed) != len(expected) {
+		t.Fatalf("Expected changes %v, got %v", expected, changed)
+	}
+	for _, rel := range changed {
+		if !expected[rel] {
+			t.Fatalf("Expected changes %v, got %v", expected, changed)
+		}
+	}
+
+	// compress only the changed files
+	for _, rel := range changed {
+		info, _ := os.Stat(filepath.Join(current, rel))
+		b, _ := ioutil.ReadFile(filepath.Join(current, rel))
+		write(filepath.Join(dir, "changes/src", rel), string(b), info.Mode())
+	}
+	for _, format := range []string{ArchiveZip, ArchiveTarGz} {
+		t.Run(format, func(t *testing.T) {
+			archive, err := CompressFilesAs(format, dir+"/changes/src")
+			if err != nil {
+				t.Fatalf("Error compressing: %s", err)
+			}
+			task := filepath.Join(dir, "task-"+format)
+			err = DecompressFiles(archive, task)
+			if err != nil {
+				t.Fatalf("Error decompressing: %s", err)
+			}
+			// the target has rendered the template of the base deployment
+			write(base+"/template.yml", "id: target", 0644)
+			delta := Delta{Base: "base", Dir: "src", Files: files}
+			err = delta.Apply(task+"/src", base)
+			if err != nil {
+				t.Fatalf("Error applying delta: %s", err)
+			}
+
+			for rel, mode := range map[string]os.FileMode{"changed.sh": 0755, "lib/unchanged.txt": 0644, "mode.sh": 0755, "template.yml": 0644} {
+				b, err := ioutil.ReadFile(filepath.Join(task, "src", rel))
+				if err != nil {
+					t.Fatalf("Error reading %s: %s", rel, err)
+				}
+				expected, _ := ioutil.ReadFile(filepath.Join(current, rel))
+				if string(b) != string(expected) {
+					t.Fatalf("Unexpected content of %s: %s", rel, b)
+				}
+				info, _ := os.Stat(filepath.Join(task, "src", rel))
+				if info.Mode().Perm() != mode {
+					t.Fatalf("Mode of %s is %s instead of %s", rel, info.Mode().Perm(), mode)
+				}
+			}
+			if _, err := os.Lstat(task + "/src/removed.txt"); !os.IsNotExist(err) {
+				t.Fatalf("Removed file is deployed")
+			}
+			link, err := os.Readlink(task + "/src/run")
+			if err != nil || link != "lib/unchanged.txt" {
+				t.Fatalf("Unexpected symlink: %s %v", link, err)
+			}
+		})
+	}
+
+	// unchanged files are verified, e.g. when modified on the target
+	write(base+"/lib/unchanged.txt", "modified", 0644)
+	task := dir + "/task-modified"
+	archive, _ := CompressFilesAs(ArchiveZip, dir+"/changes/src")
+	DecompressFiles(archive, task)
+	delta := Delta{Base: "base", Dir: "src", Files: files}
+	if err := delta.Apply(task+"/src", base); err == nil {
+		t.Fatalf("Delta is applied over a modified file")
+	}
+}
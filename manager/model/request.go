@@ -1,6 +1,10 @@
 package model
 
-import "fmt"
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
 
 const (
 	// Request types (should not contain PrefixSeparator or TopicSeperator chars)
@@ -11,6 +15,10 @@ const (
 	StageBuild   = "build"
 	StageInstall = "install"
 	StageRun     = "run"
+	// Hook stage types
+	StagePreInstall = "preInstall"
+	StagePostRun    = "postRun"
+	StageStop       = "stop"
 	// Topic consts
 	PrefixSeparator = "-"
 	// Task types
@@ -27,6 +35,9 @@ type Build struct {
 }
 
 type Deploy struct {
+	PreInstall struct {
+		Commands []string `json:"commands"`
+	} `json:"preInstall" yaml:"preInstall"` // executed before install
 	Install struct {
 		Commands []string `json:"commands"`
 	} `json:"install"`
@@ -34,6 +45,52 @@ type Deploy struct {
 		Commands    []string `json:"commands"`
 		AutoRestart bool     `json:"autoRestart"`
 	} `json:"run"`
+	PostRun struct {
+		Commands []string `json:"commands"`
+	} `json:"postRun" yaml:"postRun"` // executed after all run commands have ended
+	Stop struct {
+		Commands []string `json:"commands"`
+	} `json:"stop"` // executed before run commands are interrupted
+	Container *Container `json:"container,omitempty"` // run alongside run commands
+}
+
+// Container describes an application container which is run through the Docker Engine
+type Container struct {
+	Image   string   `json:"image"`
+	Tag     string   `json:"tag,omitempty"`
+	Env     []string `json:"env,omitempty"`     // KEY=VALUE
+	Ports   []string `json:"ports,omitempty"`   // [[hostIP:]hostPort:]containerPort[/protocol]
+	Volumes []string `json:"volumes,omitempty"` // hostPath:containerPath[:ro]
+	Restart string   `json:"restart,omitempty"` // no, always, on-failure, unless-stopped
+}
+
+// Template enables rendering of target variables in commands and files, e.g. {{.TargetID}}
+//	Variables: TargetID, Tags, Location.Lat, Location.Lon, OrderID. Functions: join, hasTag
+type Template struct {
+	Commands bool     `json:"commands,omitempty"` // render commands of all stages and container env
+	Files    []string `json:"files,omitempty"`    // files of deploy tasks, relative to the source or package directory
+}
+
+// SecretRef exposes a secret of the manager's secret store to the commands of a task
+//	The values are encrypted to the key of each target and never stored in orders.
+type SecretRef struct {
+	Name string `json:"name"`
+	Env  string `json:"env,omitempty"`  // name of the environment variable
+	File string `json:"file,omitempty"` // path relative to the source or package directory
+}
+
+// TaskSecrets carries the secret values of a task, encrypted to the public key of the receiving target
+type TaskSecrets struct {
+	Task   string `json:"t"`
+	Sealed []byte `json:"s"` // json encoded map of names and values
+}
+
+// Ref returns the image reference
+func (c *Container) Ref() string {
+	if c.Tag == "" {
+		return c.Image
+	}
+	return c.Image + ":" + c.Tag
 }
 
 // Header contains information that is common among task related structs
@@ -45,16 +102,67 @@ type Header struct {
 // Announcement carries information about a task
 type Announcement struct {
 	Header
-	Size int   `json:"s"`
-	Type uint8 `json:"b,omitempty"`
+	Size  int          `json:"s"`
+	Type  uint8        `json:"b,omitempty"`
+	Retry UnixTimeType `json:"r,omitempty"` // time of the retry, for targets to process the task again
 }
 
 // Task is a struct with all the information for deployment on a target
 type Task struct {
 	Header
-	Build     *Build  `json:"bl,omitempty"`
-	Deploy    *Deploy `json:"de,omitempty"`
-	Artifacts []byte  `json:"ar,omitempty"`
+	Build     *Build       `json:"bl,omitempty"`
+	Deploy    *Deploy      `json:"de,omitempty"`
+	Template  *Template    `json:"tp,omitempty"`
+	Secrets   []SecretRef  `json:"sc,omitempty"`
+	Artifacts []byte       `json:"ar,omitempty"`
+	Arch      string       `json:"an,omitempty"` // architecture of the built artifacts, if any
+	Archive   string       `json:"af,omitempty"` // archive format of artifacts and of the built package
+	Delta     *Delta       `json:"dl,omitempty"` // set when artifacts only contain the changes to the current deployment
+	Download  *Download    `json:"do,omitempty"` // set when artifacts are to be downloaded from the manager
+	Digest    []byte       `json:"dg,omitempty"` // sha256 of the task and its artifacts
+	Signature []byte       `json:"sg,omitempty"` // signature of digest by the manager
+	Retry     UnixTimeType `json:"-"`            // set when resending the task
+	Pull      bool         `json:"-"`            // set when targets download the artifacts instead of receiving them
+}
+
+// Download refers to artifacts served by the manager's REST API
+//	The digest of downloaded artifacts is verified as for artifacts received with the task.
+type Download struct {
+	URL  string `json:"u"` // path relative to the manager address
+	Size int64  `json:"s"`
+}
+
+// TaskDigest returns the sha256 digest of the task to be signed by the manager
+//	It covers the serialized task, including the delta manifest if any, and the artifacts which may be downloaded separately.
+func (t *Task) TaskDigest() []byte {
+	header := *t
+	header.Artifacts = nil
+	header.Download = nil
+	header.Digest = nil
+	header.Signature = nil
+	b, _ := json.Marshal(&header)
+
+	hash := sha256.New()
+	hash.Write(b)
+	artifacts := sha256.Sum256(t.Artifacts)
+	hash.Write(artifacts[:])
+	return hash.Sum(nil)
+}
+
+// Delta describes the full tree of artifacts, of which only changed files are in the archive
+//	Other files are taken from the deployment of the base task.
+type Delta struct {
+	Base    string      `json:"b"` // task id of the current deployment
+	Dir     string      `json:"d"` // execution directory of the artifacts
+	Files   []DeltaFile `json:"f"`
+	Targets []string    `json:"t"` // targets with the base deployment, as other targets receive the task as well
+}
+
+type DeltaFile struct {
+	Path   string `json:"p"`           // relative to the execution directory
+	Mode   uint32 `json:"m"`           // os.FileMode
+	Digest []byte `json:"d,omitempty"` // sha256 of regular files
+	Link   string `json:"l,omitempty"` // target of symlinks
 }
 
 func (t *Task) Validate() error {
@@ -64,6 +172,12 @@ func (t *Task) Validate() error {
 	return nil
 }
 
+// TargetConfig carries the settings of a target which are managed centrally
+type TargetConfig struct {
+	Tags     []string  `json:"tags"`
+	Location *Location `json:"location,omitempty"`
+}
+
 type LogRequest struct {
 	IfModifiedSince UnixTimeType
 }
@@ -75,6 +189,8 @@ type RequestWrapper struct {
 	LogRequest   *LogRequest   `json:"l,omitempty"`
 	Command      *string       `json:"c,omitempty"`
 	StopAll      *bool         `json:"s,omitempty"`
+	Config       *TargetConfig `json:"cf,omitempty"`
+	Secrets      *TaskSecrets  `json:"se,omitempty"`
 }
 
 func FormatTopicID(id string) string {
@@ -9,6 +9,7 @@ const (
 	ResponseLogs          = "LOG" // logs
 	ResponseAdvertisement = "ADV" // device advertisement
 	ResponsePackage       = "PKG" // assembled artifacts
+	ResponseConfigAck     = "CFG" // acknowledgement of target config update
 
 	// Log output constants
 	ExecStart        = "EXEC-START"
@@ -55,6 +56,13 @@ type TargetBase struct {
 	Location         *Location `json:"location,omitempty"`
 	PublicKey        string    `json:"publicKey,omitempty"`
 	PublicKeySwarmio []byte    `json:"publicKeySwarmio,omitempty"`
+	Memory           uint64    `json:"memory,omitempty"` // total memory in bytes
+	Arch             string    `json:"arch,omitempty"`   // architecture, as named by GOARCH
+}
+
+// TaskSizeLimit returns the max size of artifacts accepted by a target with the given total memory
+func TaskSizeLimit(totalMemory uint64) uint64 {
+	return totalMemory / 2 // TODO calculate this based on the available memory
 }
 
 type Package struct {
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/swarmio"
+)
+
+func TestArtifactsSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Error generating keys: %s", err)
+	}
+
+	task := Task{Artifacts: []byte("archive")}
+	task.Digest = task.TaskDigest()
+	task.Signature, err = swarmio.Sign(private, task.Digest)
+	if err != nil {
+		t.Fatalf("Error signing: %s", err)
+	}
+	if !swarmio.Verify(public, task.Digest, task.Signature) {
+		t.Fatalf("Valid signature is not verified")
+	}
+
+	// tampered artifacts change the digest
+	task.Artifacts = []byte("tampered")
+	if string(task.TaskDigest()) == string(task.Digest) {
+		t.Fatalf("Digest does not depend on artifacts")
+	}
+	tampered := task.TaskDigest()
+	if swarmio.Verify(public, tampered, task.Signature) {
+		t.Fatalf("Signature is verified for a different digest")
+	}
+
+	// the header and stages are signed as well
+	task.Artifacts = []byte("archive")
+	task.Deploy = &Deploy{}
+	task.Deploy.Run.Commands = []string{"./app"}
+	if string(task.TaskDigest()) == string(task.Digest) {
+		t.Fatalf("Digest does not depend on commands")
+	}
+	task.Deploy = nil
+	task.Template = &Template{Commands: true}
+	if string(task.TaskDigest()) == string(task.Digest) {
+		t.Fatalf("Digest does not depend on template")
+	}
+	task.Template = nil
+
+	// fields which are set after signing are not part of the digest
+	task.Download = &Download{URL: "/orders/a/artifacts/b", Size: 7}
+	task.Artifacts = nil
+	if string(task.TaskDigest()) == string(task.Digest) {
+		t.Fatalf("Digest does not depend on artifacts")
+	}
+	task.Artifacts = []byte("archive")
+	if string(task.TaskDigest()) != string(task.Digest) {
+		t.Fatalf("Digest depends on download reference")
+	}
+
+	otherPublic, _, _ := ed25519.GenerateKey(nil)
+	if swarmio.Verify(otherPublic, task.Digest, task.Signature) {
+		t.Fatalf("Signature is verified with another key")
+	}
+	if swarmio.Verify(nil, task.Digest, task.Signature) {
+		t.Fatalf("Signature is verified without key")
+	}
+	if _, err := swarmio.Sign([]byte("short"), task.Digest); err == nil {
+		t.Fatalf("Expected error for invalid private key")
+	}
+}
@@ -0,0 +1,213 @@
+package model
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const zstdCommand = "zstd"
+
+func writeTarGz(w io.Writer, paths []string) error {
+	gw := gzip.NewWriter(w)
+	err := writeTar(gw, paths)
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func readTarGz(r io.Reader, dir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("error reading gzip: %s", err)
+	}
+	defer gr.Close()
+	return readTar(gr, dir)
+}
+
+// writeTarZst pipes the tar stream through the zstd command
+func writeTarZst(w io.Writer, paths []string) error {
+	cmd := exec.Command(zstdCommand, "-q", "-c")
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("error starting %s: %s", zstdCommand, err)
+	}
+	err = writeTar(stdin, paths)
+	stdin.Close()
+	if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("error compressing with %s: %s", zstdCommand, waitErr)
+	}
+	return err
+}
+
+// readTarZst pipes the archive through the zstd command
+func readTarZst(r io.Reader, dir string) error {
+	cmd := exec.Command(zstdCommand, "-d", "-q", "-c")
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("error starting %s: %s", zstdCommand, err)
+	}
+	err = readTar(stdout, dir)
+	io.Copy(ioutil.Discard, stdout) // drain, in case of an error
+	if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("error decompressing with %s: %s", zstdCommand, waitErr)
+	}
+	return err
+}
+
+// writeTar adds the paths with their base names to archive, recursively
+func writeTar(w io.Writer, paths []string) error {
+	tw := tar.NewWriter(w)
+	for _, root := range paths {
+		base := filepath.Dir(filepath.Clean(root))
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			var link string
+			if info.Mode()&os.ModeSymlink != 0 {
+				link, err = os.Readlink(path)
+				if err != nil {
+					return err
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link) // includes mode and ownership
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(name)
+			if info.IsDir() {
+				header.Name += "/"
+			}
+			err = tw.WriteHeader(header)
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error archiving %s: %s", root, err)
+		}
+	}
+	return tw.Close()
+}
+
+// readTar writes the archive entries into dir, restoring modes, symlinks and, when permitted, ownership
+//	Entries which would be written outside dir are rejected.
+func readTar(r io.Reader, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	dir, err = filepath.Abs(dir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return err
+	}
+	chown := os.Geteuid() == 0
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar: %s", err)
+		}
+		path := filepath.Join(dir, header.Name)
+		if !within(dir, path) {
+			return fmt.Errorf("illegal path in archive: %s", header.Name)
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		// parent directories may be symlinks from earlier entries
+		if parent, err := filepath.EvalSymlinks(filepath.Dir(path)); err != nil || !within(dir, parent) {
+			return fmt.Errorf("illegal path in archive: %s", header.Name)
+		}
+		// replace symlinks from earlier entries instead of following them
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			os.Remove(path)
+		}
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+			if err == nil {
+				err = os.Chmod(path, mode)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			// unlink rather than truncate, as the file may be a hard link shared with other files
+			os.Remove(path)
+			var f *os.File
+			f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+			if err == nil {
+				_, err = io.Copy(f, tr)
+				f.Close()
+			}
+			if err == nil {
+				err = os.Chmod(path, mode) // not affected by umask
+			}
+		case tar.TypeSymlink:
+			os.Remove(path)
+			err = os.Symlink(header.Linkname, path)
+		case tar.TypeLink:
+			target := filepath.Join(dir, header.Linkname)
+			if !within(dir, target) {
+				return fmt.Errorf("illegal link in archive: %s", header.Linkname)
+			}
+			os.Remove(path)
+			err = os.Link(target, path)
+		default:
+			continue // devices, fifos, etc.
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting %s: %s", header.Name, err)
+		}
+		if chown {
+			err = os.Lchown(path, header.Uid, header.Gid)
+			if err != nil {
+				return fmt.Errorf("error changing owner of %s: %s", header.Name, err)
+			}
+		}
+	}
+}
+
+func within(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
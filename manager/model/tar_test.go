@@ -0,0 +1,43 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestExtractOverLinks checks that extracting over a hard-linked file replaces the link instead of writing through it
+func TestExtractOverLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(dir+"/new/pkg", 0755)
+	ioutil.WriteFile(dir+"/new/pkg/app", []byte("v2"), 0755)
+	b, err := CompressFilesAs(ArchiveTarGz, dir+"/new/pkg")
+	if err != nil {
+		t.Fatalf("Error compressing: %s", err)
+	}
+
+	os.MkdirAll(dir+"/old/pkg", 0755)
+	ioutil.WriteFile(dir+"/blob", []byte("v1"), 0755)
+	err = os.Link(dir+"/blob", dir+"/old/pkg/app")
+	if err != nil {
+		t.Fatalf("Error linking: %s", err)
+	}
+	err = DecompressFiles(b, dir+"/old")
+	if err != nil {
+		t.Fatalf("Error decompressing: %s", err)
+	}
+
+	body, _ := ioutil.ReadFile(dir + "/old/pkg/app")
+	if string(body) != "v2" {
+		t.Fatalf("Expected extracted content, got %s", body)
+	}
+	body, _ = ioutil.ReadFile(dir + "/blob")
+	if string(body) != "v1" {
+		t.Fatalf("Linked file is modified: %s", body)
+	}
+}
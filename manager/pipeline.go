@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+const (
+	PipelineInterval = 5 * time.Second
+)
+
+func (m *manager) addPipeline(pipeline *storage.Pipeline) error {
+	pipeline.Created = model.UnixTime()
+	pipeline.Init()
+
+	// check the steps against current targets and secrets
+	for _, step := range pipeline.Steps {
+		order := *step.Order
+		err := m.prepareOrder(&order)
+		if err != nil {
+			return fmt.Errorf("step %s: %s", step.Name, err)
+		}
+	}
+
+	retry := 0
+generateID:
+	pipeline.ID = m.newTaskID(retry)
+	if temp, err := m.storage.GetPipeline(pipeline.ID); err != nil {
+		return fmt.Errorf("error checking if pipeline ID is unique: %s", err)
+	} else if temp != nil {
+		retry++
+		goto generateID
+	}
+
+	_, err := m.storage.AddPipeline(pipeline)
+	if err != nil {
+		return fmt.Errorf("error storing pipeline: %s", err)
+	}
+	log.Println("Added pipeline:", pipeline.ID)
+
+	go func() {
+		m.pipelineLocker.Lock()
+		defer m.pipelineLocker.Unlock()
+		m.processPipeline(pipeline)
+	}()
+	return nil
+}
+
+func (m *manager) getPipelines(page, perPage int) ([]storage.Pipeline, int64, error) {
+	pipelines, total, err := m.storage.GetPipelines(int((page-1)*perPage), perPage)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying pipelines: %s", err)
+	}
+	return pipelines, total, nil
+}
+
+func (m *manager) getPipeline(id string) (*storage.Pipeline, error) {
+	pipeline, err := m.storage.GetPipeline(id)
+	if err != nil {
+		return nil, fmt.Errorf("error querying pipeline: %s", err)
+	}
+	return pipeline, nil
+}
+
+// deletePipeline removes the pipeline, keeping the orders of its steps
+func (m *manager) deletePipeline(id string) (found bool, err error) {
+	m.pipelineLocker.Lock()
+	defer m.pipelineLocker.Unlock()
+
+	found, err = m.storage.DeletePipeline(id)
+	if err != nil {
+		return false, fmt.Errorf("error deleting pipeline: %s", err)
+	}
+	return found, nil
+}
+
+// stopPipeline cancels the remaining steps and stops the orders of running steps
+func (m *manager) stopPipeline(id string) (found bool, err error) {
+	m.pipelineLocker.Lock()
+	defer m.pipelineLocker.Unlock()
+
+	pipeline, err := m.storage.GetPipeline(id)
+	if err != nil {
+		return false, fmt.Errorf("error querying pipeline: %s", err)
+	}
+	if pipeline == nil {
+		return false, nil
+	}
+	if pipeline.State != storage.StateRunning {
+		return true, nil
+	}
+
+	pipeline.Cancel("pipeline was stopped")
+	err = m.stopSteps(pipeline, "pipeline was stopped")
+	if err != nil {
+		return true, err
+	}
+	pipeline.State = storage.StateCancelled
+	pipeline.UpdatedAt = model.UnixTime()
+	m.storePipeline(pipeline)
+	return true, nil
+}
+
+// stopSteps stops the orders of running steps and cancels the steps
+func (m *manager) stopSteps(pipeline *storage.Pipeline, message string) error {
+	for i := range pipeline.Steps {
+		step := &pipeline.Steps[i]
+		if step.State != storage.StateRunning {
+			continue
+		}
+		_, _, err := m.stopOrder(step.OrderID)
+		if err != nil {
+			return err
+		}
+		step.State = storage.StateCancelled
+		step.Message = message
+	}
+	return nil
+}
+
+// pipelineRunner advances the running pipelines
+func (m *manager) pipelineRunner() {
+	for ; true; <-time.Tick(PipelineInterval) {
+		pipelines, err := m.storage.GetActivePipelines()
+		if err != nil {
+			log.Printf("Error getting active pipelines: %s", err)
+			continue
+		}
+		m.pipelineLocker.Lock()
+		for i := range pipelines {
+			m.processPipeline(&pipelines[i])
+		}
+		m.pipelineLocker.Unlock()
+	}
+}
+
+// processPipeline updates the state of running steps and starts the steps which are ready
+//	Should be called with pipelineLocker held.
+func (m *manager) processPipeline(pipeline *storage.Pipeline) {
+	defer recovery()
+	if pipeline.State != storage.StateRunning {
+		return
+	}
+
+	var changed bool
+	for i := range pipeline.Steps {
+		step := &pipeline.Steps[i]
+		if step.State != storage.StateRunning {
+			continue
+		}
+		order, err := m.storage.GetOrder(step.OrderID)
+		if err != nil {
+			log.Printf("Error getting order of pipeline step: %s", err)
+			return
+		}
+		if order == nil {
+			step.State = storage.StateFailed
+			step.Message = "order is removed"
+			changed = true
+			continue
+		}
+		if order.Status == nil {
+			continue
+		}
+		if state := storage.StepState(order.Status); state != step.State {
+			log.Printf("Pipeline %s: step %s has %s", pipeline.ID, step.Name, state)
+			step.State = state
+			changed = true
+		}
+	}
+
+	if !pipeline.Update() {
+		for _, i := range pipeline.Ready() {
+			m.startStep(pipeline, i)
+			changed = true
+		}
+		pipeline.Update()
+	} else {
+		changed = true
+	}
+	// steps running in parallel to the failed one are stopped too
+	if pipeline.State == storage.StateFailed {
+		err := m.stopSteps(pipeline, "stopped after a failed step")
+		if err != nil {
+			log.Printf("Pipeline %s: error stopping steps: %s", pipeline.ID, err)
+		}
+	}
+
+	if changed {
+		pipeline.UpdatedAt = model.UnixTime()
+		m.storePipeline(pipeline)
+	}
+}
+
+// startStep adds the order of the step
+func (m *manager) startStep(pipeline *storage.Pipeline, i int) {
+	step := &pipeline.Steps[i]
+	log.Printf("Pipeline %s: starting step %s", pipeline.ID, step.Name)
+
+	order := *step.Order
+	if order.Description == "" {
+		order.Description = fmt.Sprintf("%s: %s", pipeline.ID, step.Name)
+	}
+	err := m.addOrder(&order)
+	if err != nil {
+		log.Printf("Pipeline %s: error starting step %s: %s", pipeline.ID, step.Name, err)
+		step.State = storage.StateFailed
+		step.Message = err.Error()
+		return
+	}
+	step.OrderID = order.ID
+	step.State = storage.StateRunning
+}
+
+func (m *manager) storePipeline(pipeline *storage.Pipeline) {
+	_, err := m.storage.UpdatePipeline(pipeline)
+	if err != nil {
+		log.Printf("Error updating pipeline: %s", err)
+		return
+	}
+	m.publishEvent(EventPipelineStatus, pipeline)
+}
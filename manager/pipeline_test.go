@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// runPipelines does one round of the pipeline runner
+func runPipelines(t *testing.T, m *manager) {
+	pipelines, err := m.storage.GetActivePipelines()
+	if err != nil {
+		t.Fatalf("Error getting active pipelines: %s", err)
+	}
+	for i := range pipelines {
+		m.processPipeline(&pipelines[i])
+	}
+}
+
+func TestPipelineRunner(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"gateway"}}})
+
+	// orders are scheduled in the future so that no tasks are sent
+	var pipeline storage.Pipeline
+	err := yaml.Unmarshal([]byte(`
+steps:
+  - name: migrate
+    order:
+      schedule: {start: "2100-01-01T00:00:00Z"}
+      deploy:
+        install: {commands: [./migrate]}
+        target: {tags: [gateway]}
+  - name: app
+    order:
+      schedule: {start: "2100-01-01T00:00:00Z"}
+      deploy:
+        run: {commands: [./app]}
+        target: {tags: [gateway]}
+`), &pipeline)
+	if err != nil {
+		t.Fatalf("Error parsing pipeline: %s", err)
+	}
+	pipeline.ID = "release"
+	pipeline.Init()
+	s.AddPipeline(&pipeline)
+
+	runPipelines(t, m)
+	stored, _ := s.GetPipeline(pipeline.ID)
+	migrate := stored.Steps[0]
+	if migrate.State != storage.StateRunning || migrate.OrderID == "" {
+		t.Fatalf("Expected first step to be running, got %+v", migrate)
+	}
+	if len(s.orders) != 1 {
+		t.Fatalf("Expected 1 order, got %d", len(s.orders))
+	}
+
+	// later runs must not add the order of the running step again
+	runPipelines(t, m)
+	runPipelines(t, m)
+	if len(s.orders) != 1 {
+		t.Fatalf("Expected 1 order after repeated runs, got %d", len(s.orders))
+	}
+
+	// next step starts once the migration has succeeded
+	order, _ := s.GetOrder(migrate.OrderID)
+	order.Status.Set("gw", storage.StateSucceeded)
+	s.UpdateOrderStatus(order.ID, order.Status)
+	runPipelines(t, m)
+	stored, _ = s.GetPipeline(pipeline.ID)
+	if stored.Steps[0].State != storage.StateSucceeded || stored.Steps[1].State != storage.StateRunning {
+		t.Fatalf("Expected second step to be running, got %+v", stored.Steps)
+	}
+	if len(s.orders) != 2 {
+		t.Fatalf("Expected 2 orders, got %d", len(s.orders))
+	}
+
+	// a stopped pipeline is no longer run
+	found, err := m.stopPipeline(pipeline.ID)
+	if err != nil || !found {
+		t.Fatalf("Error stopping pipeline: found=%v %v", found, err)
+	}
+	stored, _ = s.GetPipeline(pipeline.ID)
+	if stored.State != storage.StateCancelled || stored.Steps[1].State != storage.StateCancelled {
+		t.Fatalf("Expected pipeline to be cancelled, got %s %+v", stored.State, stored.Steps)
+	}
+	runPipelines(t, m)
+	if len(s.orders) != 2 {
+		t.Fatalf("Expected no orders after stopping, got %d", len(s.orders))
+	}
+}
+
+// TestPipelineFailedStep checks that steps running in parallel to a failed step are stopped
+func TestPipelineFailedStep(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"gateway"}}})
+
+	var pipeline storage.Pipeline
+	err := yaml.Unmarshal([]byte(`
+steps:
+  - name: db
+    order:
+      schedule: {start: "2100-01-01T00:00:00Z"}
+      deploy:
+        run: {commands: [./db]}
+        target: {tags: [gateway]}
+  - name: app
+    dependsOn: []
+    order:
+      schedule: {start: "2100-01-01T00:00:00Z"}
+      deploy:
+        run: {commands: [./app]}
+        target: {tags: [gateway]}
+  - name: check
+    dependsOn: [db, app]
+    order:
+      deploy:
+        install: {commands: [./check]}
+        target: {tags: [gateway]}
+`), &pipeline)
+	if err != nil {
+		t.Fatalf("Error parsing pipeline: %s", err)
+	}
+	pipeline.ID = "release"
+	pipeline.Init()
+	s.AddPipeline(&pipeline)
+
+	runPipelines(t, m)
+	stored, _ := s.GetPipeline(pipeline.ID)
+	if stored.Steps[0].State != storage.StateRunning || stored.Steps[1].State != storage.StateRunning {
+		t.Fatalf("Expected parallel steps to be running, got %+v", stored.Steps)
+	}
+
+	order, _ := s.GetOrder(stored.Steps[0].OrderID)
+	order.Status.Set("gw", storage.StateFailed)
+	s.UpdateOrderStatus(order.ID, order.Status)
+	runPipelines(t, m)
+	stored, _ = s.GetPipeline(pipeline.ID)
+	if stored.State != storage.StateFailed {
+		t.Fatalf("Expected failed pipeline, got %s", stored.State)
+	}
+	for i, state := range []string{storage.StateFailed, storage.StateCancelled, storage.StateCancelled} {
+		if stored.Steps[i].State != state {
+			t.Fatalf("Expected step %s to be %s, got %+v", stored.Steps[i].Name, state, stored.Steps[i])
+		}
+	}
+	// the order of the running step is stopped
+	app, _ := s.GetOrder(stored.Steps[1].OrderID)
+	if !app.Schedule.Stopped {
+		t.Fatalf("Expected order of the running step to be stopped")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	uuid "github.com/satori/go.uuid"
+)
+
+// orderPlan describes what the order would do, without storing or sending anything
+type orderPlan struct {
+	Build  *taskPlan `json:"build,omitempty"`
+	Deploy *taskPlan `json:"deploy,omitempty"`
+}
+
+type taskPlan struct {
+	Match   *storage.Match `json:"match,omitempty"`
+	Size    int            `json:"size"` // compressed size of artifacts sent to each target
+	Note    string         `json:"note,omitempty"`
+	Batches [][]string     `json:"batches,omitempty"` // rollout batches
+	Targets []targetPlan   `json:"targets"`
+}
+
+type targetPlan struct {
+	ID       string `json:"id"`
+	Memory   uint64 `json:"memory,omitempty"`   // total memory advertised by the target
+	Rejected bool   `json:"rejected,omitempty"` // artifacts exceed the size accepted by the target
+}
+
+// planOrder validates the order against the current targets and returns the plan
+//	The source is fetched into a temporary directory to calculate the size of artifacts.
+func (m *manager) planOrder(order *storage.Order) (*orderPlan, error) {
+	err := m.prepareOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := m.planSize(order.Source, order.Archive)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan orderPlan
+	if order.Build != nil {
+		plan.Build = &taskPlan{Size: size}
+		plan.Build.Targets, err = m.planTargets(order.Build.Hosts(), size)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if order.Deploy != nil {
+		plan.Deploy = &taskPlan{Match: &order.Deploy.Match, Size: size}
+		if order.Build != nil {
+			plan.Deploy.Size = 0
+			plan.Deploy.Note = "size depends on the build artifacts"
+		}
+		if order.Deploy.Rollout != nil {
+			plan.Deploy.Batches = order.Deploy.Rollout.Batches(order.Deploy.Match.List)
+		}
+		plan.Deploy.Targets, err = m.planTargets(order.Deploy.Match.List, plan.Deploy.Size)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &plan, nil
+}
+
+// planSize returns the compressed size of the source
+func (m *manager) planSize(src *source.Source, format string) (int, error) {
+	if src == nil {
+		return 0, nil
+	}
+	tempID := "plan-" + uuid.NewV4().String()
+	defer func() {
+		err := os.RemoveAll(fmt.Sprintf("%s/%s", source.OrdersDir, tempID))
+		if err != nil {
+			log.Printf("Error removing temporary source: %s", err)
+		}
+	}()
+
+	_, err := m.fetchSource(tempID, src)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching source files: %s", err)
+	}
+	compressedArchive, err := m.compressSource(tempID, format)
+	if err != nil {
+		return 0, fmt.Errorf("error compressing files: %s", err)
+	}
+	return len(compressedArchive), nil
+}
+
+func (m *manager) planTargets(ids []string, size int) ([]targetPlan, error) {
+	targets := make([]targetPlan, len(ids))
+	for i, id := range ids {
+		target, err := m.storage.GetTarget(id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting target: %s", err)
+		}
+		targets[i].ID = id
+		if target != nil && target.Memory > 0 {
+			targets[i].Memory = target.Memory
+			targets[i].Rejected = uint64(size) > model.TaskSizeLimit(target.Memory)
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPlanOrder(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	const payload = 64 << 10
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "large", Tags: []string{"swarm"}, Memory: 1 << 30}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "small", Tags: []string{"swarm"}, Memory: payload}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "unknown", Tags: []string{"swarm"}}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "builder", Memory: payload}})
+
+	// random content does not compress
+	os.MkdirAll("input", 0755)
+	b := make([]byte, payload)
+	rand.Read(b)
+	ioutil.WriteFile("input/data", b, 0644)
+
+	plan := func(spec string) (*orderPlan, error) {
+		var order storage.Order
+		err := yaml.Unmarshal([]byte(spec), &order)
+		if err != nil {
+			t.Fatalf("Error parsing order: %s", err)
+		}
+		return m.planOrder(&order)
+	}
+
+	t.Run("size", func(t *testing.T) {
+		size, err := m.planSize(nil, "")
+		if err != nil || size != 0 {
+			t.Fatalf("Expected no size without source, got %d %v", size, err)
+		}
+		size, err = m.planSize(&source.Source{Paths: &source.Paths{"input"}}, model.ArchiveTarGz)
+		if err != nil {
+			t.Fatalf("Error planning size: %s", err)
+		}
+		if size < payload || size > payload+4096 {
+			t.Fatalf("Unexpected size: %d", size)
+		}
+		// the source is fetched only temporarily
+		files, _ := ioutil.ReadDir(source.OrdersDir)
+		if len(files) != 0 {
+			t.Fatalf("Expected temporary source to be removed, got %d files", len(files))
+		}
+		_, err = m.planSize(&source.Source{Paths: &source.Paths{"missing"}}, "")
+		if err == nil {
+			t.Fatalf("Expected error for missing source")
+		}
+	})
+
+	t.Run("memory limit", func(t *testing.T) {
+		p, err := plan(`
+source: {paths: [input]}
+deploy:
+  install: {commands: [./install.sh]}
+  target: {tags: [swarm]}
+  rollout: {canary: "1", batch: "2"}
+`)
+		if err != nil {
+			t.Fatalf("Error planning order: %s", err)
+		}
+		if p.Build != nil || p.Deploy == nil || p.Deploy.Size < payload {
+			t.Fatalf("Unexpected plan: %+v", p)
+		}
+		if len(p.Deploy.Match.List) != 3 || len(p.Deploy.Targets) != 3 {
+			t.Fatalf("Expected 3 targets, got %+v", p.Deploy.Targets)
+		}
+		if len(p.Deploy.Batches) != 2 || len(p.Deploy.Batches[0]) != 1 {
+			t.Fatalf("Expected canary and one batch, got %v", p.Deploy.Batches)
+		}
+		for _, target := range p.Deploy.Targets {
+			switch target.ID {
+			case "large":
+				if target.Rejected || target.Memory != 1<<30 {
+					t.Fatalf("Expected large target to accept the artifacts: %+v", target)
+				}
+			case "small":
+				if !target.Rejected || target.Memory != payload {
+					t.Fatalf("Expected small target to reject the artifacts: %+v", target)
+				}
+			case "unknown":
+				if target.Rejected || target.Memory != 0 {
+					t.Fatalf("Expected target without memory to be unflagged: %+v", target)
+				}
+			}
+		}
+		if len(s.orders) != 0 {
+			t.Fatalf("Plan must not store the order")
+		}
+	})
+
+	t.Run("build", func(t *testing.T) {
+		p, err := plan(`
+source: {paths: [input]}
+build:
+  commands: [make]
+  artifacts: [app]
+  host: builder
+deploy:
+  install: {commands: [./install.sh]}
+  target: {ids: [large]}
+`)
+		if err != nil {
+			t.Fatalf("Error planning order: %s", err)
+		}
+		if p.Build == nil || p.Build.Size < payload || len(p.Build.Targets) != 1 || !p.Build.Targets[0].Rejected {
+			t.Fatalf("Expected build host to reject the source: %+v", p.Build)
+		}
+		if p.Deploy.Size != 0 || p.Deploy.Note == "" || p.Deploy.Targets[0].Rejected {
+			t.Fatalf("Expected deploy size to depend on the build: %+v", p.Deploy)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := plan(`
+build:
+  commands: [make]
+  artifacts: [app]
+  host: missing
+`)
+		if err == nil || !strings.Contains(err.Error(), "build host not found: missing") {
+			t.Fatalf("Expected missing build host error, got %v", err)
+		}
+		_, err = plan(`
+build:
+  commands: [make]
+  artifacts: [app]
+  matrix: [{arch: amd64, host: builder}, {arch: arm, host: missing-arm}]
+`)
+		if err == nil || !strings.Contains(err.Error(), "missing-arm") {
+			t.Fatalf("Expected missing build host of matrix error, got %v", err)
+		}
+		_, err = plan(`
+deploy:
+  install: {commands: [./install.sh]}
+  target: {tags: [none]}
+`)
+		if err == nil {
+			t.Fatalf("Expected error for deployment without targets")
+		}
+	})
+}
@@ -3,16 +3,20 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
 
 	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
 	"code.linksmart.eu/dt/deployment-tool/manager/storage"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -43,6 +47,10 @@ const (
 	_topics          = "topics"
 	_name            = "name"
 	_description     = "description"
+	_orderPart       = "order" // multipart form field
+	_dryRun          = "dryRun"
+	_arch            = "arch"
+	_format          = "format"
 	_tokenHeader     = "X-Auth-Token"
 	defaultPage      = 1
 	defaultPerPage   = 100
@@ -92,6 +100,7 @@ func (a *restAPI) setupRouter() {
 	r.HandleFunc("/targets/{id}", a.deleteTarget).Methods(http.MethodDelete)
 	r.HandleFunc("/targets/{id}", a.updateTarget).Methods(http.MethodPut)
 	r.HandleFunc("/targets/{id}/stop", a.stopTargetOrders).Methods(http.MethodPut)
+	r.HandleFunc("/targets/{id}/rollback", a.rollbackTarget).Methods(http.MethodPost)
 	r.HandleFunc("/targets/{id}/logs", a.requestTargetLogs).Methods(http.MethodPut)
 	r.HandleFunc("/targets/{id}/command", a.executeCommand).Methods(http.MethodPut)
 	r.HandleFunc("/targets/{id}/command", a.stopCommand).Methods(http.MethodDelete)
@@ -100,7 +109,19 @@ func (a *restAPI) setupRouter() {
 	r.HandleFunc("/orders/{id}", a.getOrder).Methods(http.MethodGet)
 	r.HandleFunc("/orders/{id}", a.deleteOrder).Methods(http.MethodDelete)
 	r.HandleFunc("/orders/{id}/stop", a.stopOrder).Methods(http.MethodPut)
+	r.HandleFunc("/orders/{id}/retry", a.retryOrder).Methods(http.MethodPost)
+	r.HandleFunc("/orders/{id}/rollback", a.rollbackOrder).Methods(http.MethodPost)
+	r.HandleFunc("/orders/{id}/artifacts/{digest}", a.getArtifacts).Methods(http.MethodGet)
+	r.HandleFunc("/orders/{id}/package", a.getOrderPackage).Methods(http.MethodGet)
+	r.HandleFunc("/orders/{id}/files", a.getOrderFiles).Methods(http.MethodGet)
 	r.HandleFunc("/orders", a.addOrder).Methods(http.MethodPost)
+	r.HandleFunc("/orders/plan", a.planOrder).Methods(http.MethodPost)
+	// pipelines
+	r.HandleFunc("/pipelines", a.getPipelines).Methods(http.MethodGet)
+	r.HandleFunc("/pipelines", a.addPipeline).Methods(http.MethodPost)
+	r.HandleFunc("/pipelines/{id}", a.getPipeline).Methods(http.MethodGet)
+	r.HandleFunc("/pipelines/{id}", a.deletePipeline).Methods(http.MethodDelete)
+	r.HandleFunc("/pipelines/{id}/stop", a.stopPipeline).Methods(http.MethodPut)
 	// logs
 	r.HandleFunc("/logs", a.getLogs).Methods(http.MethodGet)
 	// tokens
@@ -108,6 +129,14 @@ func (a *restAPI) setupRouter() {
 	r.HandleFunc("/token_sets", a.createTokenSet).Methods(http.MethodPost)
 	r.HandleFunc("/token_sets/{name}", a.getTokenSet).Methods(http.MethodGet)
 	r.HandleFunc("/token_sets/{name}", a.deleteTokenSet).Methods(http.MethodDelete)
+	// secrets
+	r.HandleFunc("/secrets", a.getSecrets).Methods(http.MethodGet)
+	r.HandleFunc("/secrets/{name}", a.putSecret).Methods(http.MethodPut)
+	r.HandleFunc("/secrets/{name}", a.deleteSecret).Methods(http.MethodDelete)
+	// blobs
+	r.HandleFunc("/blobs", a.getBlobStats).Methods(http.MethodGet)
+	r.HandleFunc("/blobs/gc", a.collectGarbage).Methods(http.MethodPost)
+	// registration
 	r.HandleFunc("/rpc/targets", a.registerTarget).Methods(http.MethodPost)
 	r.HandleFunc("/rpc/server_info", a.getServerInfo).Methods(http.MethodGet)
 	// health
@@ -139,16 +168,17 @@ func (a *restAPI) index(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *restAPI) addOrder(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get(_dryRun) == "true" {
+		a.planOrder(w, r)
+		return
+	}
 
-	decoder := yaml.NewDecoder(r.Body)
-	defer r.Body.Close()
-
-	var order storage.Order
-	err := decoder.Decode(&order)
+	order, err := decodeOrder(r)
 	if err != nil {
 		HTTPResponseError(w, http.StatusBadRequest, err)
 		return
 	}
+	defer removeUpload(order)
 	log.Println("Received order:", order)
 
 	err = order.Validate()
@@ -157,7 +187,7 @@ func (a *restAPI) addOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.manager.addOrder(&order)
+	err = a.manager.addOrder(order)
 	if err != nil {
 		HTTPResponseError(w, http.StatusInternalServerError, err)
 		return
@@ -172,6 +202,111 @@ func (a *restAPI) addOrder(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// planOrder reports what the order would do, without storing or sending anything
+func (a *restAPI) planOrder(w http.ResponseWriter, r *http.Request) {
+
+	order, err := decodeOrder(r)
+	if err != nil {
+		HTTPResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer removeUpload(order)
+
+	err = order.Validate()
+	if err != nil {
+		HTTPResponseError(w, http.StatusBadRequest, "Invalid order: ", err)
+		return
+	}
+
+	plan, err := a.manager.planOrder(order)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b, err := json.Marshal(plan)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	HTTPResponse(w, http.StatusOK, b)
+	return
+}
+
+// decodeOrder reads the order YAML from request body
+//	Multipart requests carry the YAML in the order part and source files as file parts, which are streamed to disk.
+func decodeOrder(r *http.Request) (*storage.Order, error) {
+	defer r.Body.Close()
+
+	var order storage.Order
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		err := yaml.NewDecoder(r.Body).Decode(&order)
+		if err != nil {
+			return nil, err
+		}
+		return &order, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("error reading multipart request: %s", err)
+	}
+	var upload *source.Upload
+	var found bool
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			switch {
+			case part.FormName() == _orderPart:
+				err = yaml.NewDecoder(part).Decode(&order)
+				found = true
+			case part.FileName() != "":
+				if upload == nil {
+					upload, err = source.NewUpload()
+					if err != nil {
+						return nil, err
+					}
+				}
+				err = upload.Add(part.FileName(), part)
+			default:
+				err = fmt.Errorf("unexpected form field: %s", part.FormName())
+			}
+		}
+		if err != nil {
+			if upload != nil {
+				upload.Remove()
+			}
+			return nil, err
+		}
+	}
+
+	if !found {
+		if upload != nil {
+			upload.Remove()
+		}
+		return nil, fmt.Errorf("multipart request has no %s part", _orderPart)
+	}
+	if upload != nil {
+		if order.Source != nil {
+			upload.Remove()
+			return nil, fmt.Errorf("source cannot be combined with uploaded files")
+		}
+		order.Source = &source.Source{Upload: upload}
+	}
+	return &order, nil
+}
+
+// removeUpload removes files received with the order, if not used
+func removeUpload(order *storage.Order) {
+	if order.Source != nil && order.Source.Upload != nil {
+		order.Source.Upload.Remove()
+	}
+}
+
 func (a *restAPI) getOrder(w http.ResponseWriter, r *http.Request) {
 
 	id := mux.Vars(r)["id"]
@@ -213,6 +348,119 @@ func (a *restAPI) deleteOrder(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (a *restAPI) addPipeline(w http.ResponseWriter, r *http.Request) {
+
+	decoder := yaml.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	var pipeline storage.Pipeline
+	err := decoder.Decode(&pipeline)
+	if err != nil {
+		HTTPResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = pipeline.Validate()
+	if err != nil {
+		HTTPResponseError(w, http.StatusBadRequest, "Invalid pipeline: ", err)
+		return
+	}
+
+	err = a.manager.addPipeline(&pipeline)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b, err := json.Marshal(pipeline)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	HTTPResponse(w, http.StatusCreated, b)
+	return
+}
+
+func (a *restAPI) getPipelines(w http.ResponseWriter, r *http.Request) {
+	page, perPage, err := parsePagingAttributes(r.URL.Query())
+	if err != nil {
+		HTTPResponseError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pipelines, total, err := a.manager.getPipelines(page, perPage)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b, err := json.Marshal(&list{total, pipelines, page, perPage})
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	HTTPResponse(w, http.StatusOK, b)
+	return
+}
+
+func (a *restAPI) getPipeline(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+
+	pipeline, err := a.manager.getPipeline(id)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if pipeline == nil {
+		HTTPResponseError(w, http.StatusNotFound, id+" is not found!")
+		return
+	}
+
+	b, err := json.Marshal(pipeline)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	HTTPResponse(w, http.StatusOK, b)
+	return
+}
+
+func (a *restAPI) deletePipeline(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+
+	found, err := a.manager.deletePipeline(id)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		HTTPResponseError(w, http.StatusNotFound, id+" is not found!")
+		return
+	}
+
+	return
+}
+
+func (a *restAPI) stopPipeline(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+
+	found, err := a.manager.stopPipeline(id)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		HTTPResponseError(w, http.StatusNotFound, id+" is not found!")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return
+}
+
 func (a *restAPI) stopTargetOrders(w http.ResponseWriter, r *http.Request) {
 
 	id := mux.Vars(r)["id"]
@@ -249,6 +497,182 @@ func (a *restAPI) stopOrder(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (a *restAPI) retryOrder(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+
+	found, list, err := a.manager.retryOrder(id)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		HTTPResponseError(w, http.StatusNotFound, id+" is not found!")
+		return
+	}
+	if len(list) == 0 {
+		HTTPResponseError(w, http.StatusConflict, "order has no failed or undelivered targets")
+		return
+	}
+
+	HTTPResponseSuccess(w, http.StatusOK, "Retrying on ", list)
+	return
+}
+
+func (a *restAPI) rollbackOrder(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+
+	found, rollbacks, err := a.manager.rollbackOrder(id)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		HTTPResponseError(w, http.StatusNotFound, id+" is not found!")
+		return
+	}
+	if len(rollbacks) == 0 {
+		HTTPResponseError(w, http.StatusConflict, "no previous deployments to roll back to")
+		return
+	}
+
+	b, err := json.Marshal(rollbacks)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	HTTPResponse(w, http.StatusOK, b)
+	return
+}
+
+// getArtifacts serves the artifacts of a task to registered targets
+//	Range requests are supported for resuming downloads.
+func (a *restAPI) getArtifacts(w http.ResponseWriter, r *http.Request) {
+	targetID, err := a.manager.authenticateTarget(r)
+	if err != nil {
+		HTTPResponseError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	vars := mux.Vars(r)
+	path, found, permitted := a.manager.artifactsPath(vars["id"], vars["digest"], targetID)
+	if found && !permitted {
+		HTTPResponseError(w, http.StatusForbidden, targetID+" is not a target of the order")
+		return
+	}
+	if !found {
+		HTTPResponseError(w, http.StatusNotFound, "artifacts are not found")
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("Serving artifacts of %s to %s", vars["id"], targetID)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// getOrderPackage serves the package built for the order as archive
+//	Packages of a build matrix are selected with the arch query parameter.
+//	The archive format defaults to that of the order and can be changed with the format query parameter.
+func (a *restAPI) getOrderPackage(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+
+	order, err := a.manager.getOrder(id)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if order == nil {
+		HTTPResponseError(w, http.StatusNotFound, id+" is not found!")
+		return
+	}
+	if order.Build == nil {
+		HTTPResponseError(w, http.StatusNotFound, id+" has no build")
+		return
+	}
+
+	arch := r.URL.Query().Get(_arch)
+	if len(order.Build.Matrix) > 0 && !inBatch(arch, order.Build.Archs()) {
+		HTTPResponseError(w, http.StatusBadRequest, fmt.Sprintf("%s must be one of %v", _arch, order.Build.Archs()))
+		return
+	} else if len(order.Build.Matrix) == 0 && arch != "" {
+		HTTPResponseError(w, http.StatusBadRequest, id+" has no build matrix")
+		return
+	}
+	format := r.URL.Query().Get(_format)
+	if format == "" {
+		format = order.Archive
+	}
+	if format == "" {
+		format = model.ArchiveZip
+	}
+	err = model.ValidateArchive(format)
+	if err != nil {
+		HTTPResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !a.manager.packageExists(order.ID, arch) {
+		HTTPResponseError(w, http.StatusNotFound, "package is not found")
+		return
+	}
+
+	b, err := a.manager.compressPackage(order.ID, arch, format)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, "error compressing package: ", err)
+		return
+	}
+
+	name := order.ID
+	if arch != "" {
+		name += "-" + arch
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(b)
+	if err != nil {
+		log.Printf("Error writing package: %s", err)
+	}
+}
+
+// getOrderFiles lists the source and package trees of the order, with sizes and sha256 digests
+func (a *restAPI) getOrderFiles(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+
+	files, err := a.manager.getOrderFiles(id)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if files == nil {
+		HTTPResponseError(w, http.StatusNotFound, id+" is not found!")
+		return
+	}
+
+	b, err := json.Marshal(files)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	HTTPResponse(w, http.StatusOK, b)
+	return
+}
+
 func (a *restAPI) getOrders(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	page, perPage, err := parsePagingAttributes(query)
@@ -335,6 +759,28 @@ func (a *restAPI) getTarget(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (a *restAPI) rollbackTarget(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+
+	found, orderID, err := a.manager.rollbackTarget(id)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		HTTPResponseError(w, http.StatusNotFound, id+" is not found!")
+		return
+	}
+	if orderID == "" {
+		HTTPResponseError(w, http.StatusConflict, "no previous deployment to roll back to")
+		return
+	}
+
+	HTTPResponseSuccess(w, http.StatusOK, "Rolling back to ", orderID)
+	return
+}
+
 func (a *restAPI) deleteTarget(w http.ResponseWriter, r *http.Request) {
 
 	id := mux.Vars(r)["id"]
@@ -370,13 +816,12 @@ func (a *restAPI) registerTarget(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// sign swarmio key
-	cert, err := a.manager.signPublicKey(target.PublicKeySwarmio)
+	// sign swarmio key, bound to the target id
+	cert, err := a.manager.signPublicKey(target.ID, target.PublicKeySwarmio)
 	if err != nil {
 		HTTPResponseError(w, http.StatusBadRequest, err)
 		return
 	}
-	target.PublicKeySwarmio = nil
 
 	authorized, conflict, err := a.manager.registerTarget(&target, token)
 	if err != nil {
@@ -689,6 +1134,117 @@ func (a *restAPI) deleteTokenSet(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (a *restAPI) getSecrets(w http.ResponseWriter, r *http.Request) {
+
+	secrets, err := a.manager.getSecrets()
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b, err := json.Marshal(&list{int64(len(secrets)), secrets, 1, len(secrets)})
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	HTTPResponse(w, http.StatusOK, b)
+	return
+}
+
+// putSecret creates or updates a secret. The value is never returned by the API.
+func (a *restAPI) putSecret(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)[_name]
+	if !secretNameRegexp.MatchString(name) {
+		HTTPResponseError(w, http.StatusBadRequest, "invalid secret name. Allowed characters: a-z A-Z 0-9 _ . -")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	var body struct {
+		Value string `json:"value"`
+	}
+	err := decoder.Decode(&body)
+	if err != nil {
+		HTTPResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Value == "" {
+		HTTPResponseError(w, http.StatusBadRequest, "value not given")
+		return
+	}
+
+	created, err := a.manager.putSecret(name, body.Value)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return
+}
+
+func (a *restAPI) deleteSecret(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)[_name]
+
+	found, err := a.manager.deleteSecret(name)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		HTTPResponseError(w, http.StatusNotFound, name+" is not found!")
+		return
+	}
+
+	return
+}
+
+func (a *restAPI) getBlobStats(w http.ResponseWriter, r *http.Request) {
+
+	stats, err := a.manager.blobs.Stats()
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b, err := json.Marshal(stats)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	HTTPResponse(w, http.StatusOK, b)
+	return
+}
+
+// collectGarbage removes files of orders which are not retained and unreferenced blobs
+func (a *restAPI) collectGarbage(w http.ResponseWriter, r *http.Request) {
+
+	report, err := a.manager.collectGarbage()
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		HTTPResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	HTTPResponse(w, http.StatusOK, b)
+	return
+}
+
 func (a *restAPI) getHealth(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK!"))
 }
@@ -706,7 +1262,7 @@ func (a *restAPI) websocket(w http.ResponseWriter, r *http.Request) {
 	defer c.Close()
 
 	query := r.URL.Query()
-	topics := []string{EventLogs, EventTargetAdded, EventTargetUpdated}
+	topics := []string{EventLogs, EventTargetAdded, EventTargetUpdated, EventOrderStatus, EventPipelineStatus}
 	if topicsQuery := query.Get(_topics); topicsQuery != "" {
 		topics = strings.Split(topicsQuery, ",")
 	}
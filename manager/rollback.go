@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+// recordDeployment sets the order as the current deployment of the target
+func (m *manager) recordDeployment(targetID, orderID string) {
+	m.deploymentLocker.Lock()
+	defer m.deploymentLocker.Unlock()
+
+	target, err := m.storage.GetTarget(targetID)
+	if err != nil {
+		log.Printf("Error getting target: %s", err)
+		return
+	}
+	if target == nil || !target.AddDeployment(orderID) {
+		return
+	}
+	_, err = m.storage.UpdateTargetDeployments(targetID, target.Deployments)
+	if err != nil {
+		log.Printf("Error updating deployments of target: %s", err)
+	}
+}
+
+// discardDeployment removes the order which failed after starting to run from deployments of the target,
+//	so that it is not rolled back to
+func (m *manager) discardDeployment(targetID, orderID string) {
+	m.deploymentLocker.Lock()
+	defer m.deploymentLocker.Unlock()
+
+	target, err := m.storage.GetTarget(targetID)
+	if err != nil {
+		log.Printf("Error getting target: %s", err)
+		return
+	}
+	if target == nil || !target.RemoveDeployment(orderID) {
+		return
+	}
+	log.Printf("Discarded failed deployment of %s on %s", orderID, targetID)
+	_, err = m.storage.UpdateTargetDeployments(targetID, target.Deployments)
+	if err != nil {
+		log.Printf("Error updating deployments of target: %s", err)
+	}
+}
+
+// rollbackTarget redeploys the order that was deployed on the target before the current one
+func (m *manager) rollbackTarget(id string) (found bool, orderID string, err error) {
+	m.deploymentLocker.Lock()
+	defer m.deploymentLocker.Unlock()
+
+	target, err := m.storage.GetTarget(id)
+	if err != nil {
+		return false, "", fmt.Errorf("error getting target: %s", err)
+	}
+	if target == nil {
+		return false, "", nil
+	}
+	if len(target.Deployments) == 0 {
+		return true, "", nil
+	}
+	current := target.Deployments[len(target.Deployments)-1]
+	orderID = target.RollbackDeployment(current)
+	if orderID == "" {
+		return true, "", nil
+	}
+
+	err = m.rollback(orderID, current, []string{id})
+	if err != nil {
+		return true, "", err
+	}
+	_, err = m.storage.UpdateTargetDeployments(id, target.Deployments)
+	if err != nil {
+		return true, "", fmt.Errorf("error updating deployments of target: %s", err)
+	}
+	return true, orderID, nil
+}
+
+// rollbackOrder redeploys the orders that were deployed before the given order on its targets
+//	Returns the targets for each redeployed order. Targets on which the order failed are returned to their current
+//	deployment, as failed deployments are not recorded. Other targets on which the order was never deployed are skipped.
+func (m *manager) rollbackOrder(id string) (found bool, rollbacks map[string][]string, err error) {
+	order, err := m.storage.GetOrder(id)
+	if err != nil {
+		return false, nil, fmt.Errorf("error querying order: %s", err)
+	}
+	if order == nil {
+		return false, nil, nil
+	}
+	if order.Deploy == nil {
+		return true, nil, nil
+	}
+	m.stopRollout(id)
+
+	m.deploymentLocker.Lock()
+	defer m.deploymentLocker.Unlock()
+
+	rollbacks = make(map[string][]string)
+	updated := make(map[string][]string) // target id: deployments
+	for _, targetID := range order.Deploy.Match.List {
+		target, err := m.storage.GetTarget(targetID)
+		if err != nil {
+			return true, nil, fmt.Errorf("error getting target: %s", err)
+		}
+		if target == nil {
+			continue
+		}
+		if previous := target.RollbackDeployment(id); previous != "" {
+			rollbacks[previous] = append(rollbacks[previous], targetID)
+			updated[targetID] = target.Deployments
+		} else if failed(order, targetID) && len(target.Deployments) > 0 {
+			current := target.Deployments[len(target.Deployments)-1]
+			rollbacks[current] = append(rollbacks[current], targetID)
+			updated[targetID] = target.Deployments
+		}
+	}
+
+	for previous, targets := range rollbacks {
+		err = m.rollback(previous, id, targets)
+		if err != nil {
+			return true, nil, err
+		}
+		for _, targetID := range targets {
+			_, err = m.storage.UpdateTargetDeployments(targetID, updated[targetID])
+			if err != nil {
+				return true, nil, fmt.Errorf("error updating deployments of target: %s", err)
+			}
+		}
+	}
+	return true, rollbacks, nil
+}
+
+// failed returns true if the order has failed on the target
+func failed(order *storage.Order, targetID string) bool {
+	if order.Status == nil {
+		return false
+	}
+	for _, t := range order.Status.Targets {
+		if t.ID == targetID {
+			return t.State == storage.StateFailed
+		}
+	}
+	return false
+}
+
+// rollback redeploys the order to targets, replacing the given order
+func (m *manager) rollback(orderID, from string, targets []string) error {
+	order, err := m.storage.GetOrder(orderID)
+	if err != nil {
+		return fmt.Errorf("error querying order: %s", err)
+	}
+	if order == nil || order.Deploy == nil {
+		return fmt.Errorf("previously deployed order %s is not found", orderID)
+	}
+
+	log.Printf("Rolling back %s to %s on %d target(s)", from, orderID, len(targets))
+	m.storeLog(from, model.StageInstall, "rolling back to "+orderID, false, targets...)
+	m.storeLog(orderID, model.StageInstall, "rollback from "+from, false, targets...)
+	go m.redeploy(order, targets)
+	return nil
+}
+
+// redeploy resends the deploy task of the order to targets, which process it again even if received before
+func (m *manager) redeploy(order *storage.Order, targets []string) {
+	defer recovery()
+	task, ok := m.deployTask(order, targets)
+	if !ok {
+		return
+	}
+	task.Retry = model.UnixTime()
+	m.sendDeploy(order, task, storage.Match{IDs: targets, List: targets})
+}
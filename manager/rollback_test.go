@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// interleavedStorage calls the hook once after the first target is read
+type interleavedStorage struct {
+	*memStorage
+	called int32 // accessed atomically
+	hook   func()
+}
+
+func (s *interleavedStorage) GetTarget(id string) (*storage.Target, error) {
+	target, err := s.memStorage.GetTarget(id)
+	if atomic.CompareAndSwapInt32(&s.called, 0, 1) {
+		s.hook()
+	}
+	return target, err
+}
+
+// TestAdvertisementKeepsDeployments checks that a deployment recorded while processing an advertisement is kept
+func TestAdvertisementKeepsDeployments(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"v1"}}})
+
+	recorded := make(chan struct{})
+	m.storage = &interleavedStorage{memStorage: s, hook: func() {
+		go func() {
+			m.recordDeployment("gw", "order")
+			close(recorded)
+		}()
+		time.Sleep(100 * time.Millisecond)
+	}}
+
+	m.processTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"v2"}}}, false)
+	<-recorded
+
+	target, _ := s.GetTarget("gw")
+	if len(target.Tags) != 1 || target.Tags[0] != "v2" {
+		t.Fatalf("Expected advertised tags, got %v", target.Tags)
+	}
+	if len(target.Deployments) != 1 || target.Deployments[0] != "order" {
+		t.Fatalf("Expected recorded deployment, got %v", target.Deployments)
+	}
+}
+
+// TestRollbackSkipsFailedRun checks that an order which fails after starting to run is not rolled back to
+func TestRollbackSkipsFailedRun(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw"}})
+
+	for _, id := range []string{"v1", "v2", "v3"} {
+		var order storage.Order
+		yaml.Unmarshal([]byte("deploy: {run: {commands: [./app]}}"), &order)
+		order.ID = id
+		order.Deploy.Match = storage.Match{IDs: []string{"gw"}, List: []string{"gw"}}
+		order.Status = storage.NewOrderStatus([]string{"gw"})
+		s.AddOrder(&order)
+	}
+	runLog := func(task, output string, error bool) storage.Log {
+		return storage.Log{Log: model.Log{Task: task, Stage: model.StageRun, Command: "./app", Output: output, Error: error}, Target: "gw"}
+	}
+	deployments := func() []string {
+		target, _ := s.GetTarget("gw")
+		return target.Deployments
+	}
+
+	m.updateOrderStatus([]storage.Log{runLog("v1", "started", false)})
+	// v2 is recorded when it starts to run and discarded when it fails
+	m.updateOrderStatus([]storage.Log{runLog("v2", "started", false)})
+	if d := deployments(); len(d) != 2 || d[1] != "v2" {
+		t.Fatalf("Expected running order to be recorded, got %v", d)
+	}
+	m.updateOrderStatus([]storage.Log{runLog("v2", model.StageEnd, true)})
+	if d := deployments(); len(d) != 1 || d[0] != "v1" {
+		t.Fatalf("Expected failed order to be discarded, got %v", d)
+	}
+
+	// rollback of the failed order returns to the last successful one
+	found, rollbacks, err := m.rollbackOrder("v2")
+	if !found || err != nil {
+		t.Fatalf("Error rolling back v2: %v %v", found, err)
+	}
+	if len(rollbacks) != 1 || len(rollbacks["v1"]) != 1 {
+		t.Fatalf("Expected rollback to v1, got %v", rollbacks)
+	}
+
+	// rollback of a later order skips the failed one
+	m.updateOrderStatus([]storage.Log{runLog("v3", "started", false)})
+	found, orderID, err := m.rollbackTarget("gw")
+	if !found || err != nil {
+		t.Fatalf("Error rolling back target: %v %v", found, err)
+	}
+	if orderID != "v1" {
+		t.Fatalf("Expected rollback to v1, got %q", orderID)
+	}
+	if d := deployments(); len(d) != 1 || d[0] != "v1" {
+		t.Fatalf("Expected v1 to be the current deployment, got %v", d)
+	}
+}
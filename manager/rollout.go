@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+const (
+	RolloutPollInterval = 5 * time.Second
+)
+
+var errRolloutStopped = fmt.Errorf("order was stopped")
+
+// rollout sends the deploy task to batches of matched targets
+//	The next batch is sent when targets of the current batch have succeeded or started running,
+//	and remain so during the soak period. The rollout halts if the failure rate of a batch exceeds the threshold.
+func (m *manager) rollout(task *model.Task, order *storage.Order) {
+	rollout := order.Deploy.Rollout
+	batches := rollout.Batches(order.Deploy.Match.List)
+	stop := m.startRollout(order.ID)
+	defer m.endRollout(order.ID)
+
+	for i, batch := range batches {
+		log.Printf("Rollout of %s: batch %d/%d with %d target(s)", order.ID, i+1, len(batches), len(batch))
+		m.setRolloutStatus(order.ID, &storage.RolloutStatus{Batch: i + 1, Batches: len(batches)})
+		m.storeLog(order.ID, model.StageInstall, fmt.Sprintf("rollout batch %d/%d", i+1, len(batches)), false, batch...)
+
+		m.sendDeploy(order, task, storage.Match{IDs: batch, List: batch})
+
+		failed, err := m.awaitBatch(order.ID, batch, rollout.TimeoutDuration(), stop)
+		// soak before the next batch
+		if err == nil && i < len(batches)-1 && rollout.SoakDuration() > 0 && !exceedsFailureRate(failed, batch, rollout) {
+			m.storeLog(order.ID, model.StageInstall, fmt.Sprintf("soaking for %s", rollout.SoakDuration()), false, batch...)
+			select {
+			case <-time.After(rollout.SoakDuration()):
+				failed, err = m.batchFailures(order.ID, batch)
+			case <-stop:
+				err = errRolloutStopped
+			}
+		}
+		if err == nil && exceedsFailureRate(failed, batch, rollout) {
+			err = fmt.Errorf("%d/%d target(s) of batch %d failed", failed, len(batch), i+1)
+		}
+		if err != nil {
+			var remaining []string
+			for _, b := range batches[i+1:] {
+				remaining = append(remaining, b...)
+			}
+			m.haltRollout(order.ID, i+1, len(batches), err.Error(), remaining)
+			return
+		}
+	}
+	log.Printf("Rollout of %s: completed", order.ID)
+}
+
+// awaitBatch waits until targets of the batch have either started running or ended, and returns the number of failed ones
+//	Targets that haven't progressed within the timeout are considered as failed.
+func (m *manager) awaitBatch(orderID string, batch []string, timeout time.Duration, stop <-chan struct{}) (failed int, err error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-time.After(RolloutPollInterval):
+		case <-stop:
+			return 0, errRolloutStopped
+		case <-deadline:
+			log.Printf("Rollout of %s: timeout waiting for the batch", orderID)
+			return m.batchFailures(orderID, batch)
+		}
+
+		order, err := m.storage.GetOrder(orderID)
+		if err != nil {
+			return 0, fmt.Errorf("error getting order: %s", err)
+		}
+		if order == nil || order.Status == nil {
+			return 0, fmt.Errorf("order status not found")
+		}
+		pending := false
+		for _, t := range order.Status.Targets {
+			if inBatch(t.ID, batch) && t.State != storage.StateRunning && !finalState(t.State) {
+				pending = true
+				break
+			}
+		}
+		if !pending {
+			return m.batchFailures(orderID, batch)
+		}
+	}
+}
+
+// batchFailures returns the number of targets in the batch that have failed or have not yet progressed
+func (m *manager) batchFailures(orderID string, batch []string) (failed int, err error) {
+	order, err := m.storage.GetOrder(orderID)
+	if err != nil {
+		return 0, fmt.Errorf("error getting order: %s", err)
+	}
+	if order == nil || order.Status == nil {
+		return 0, fmt.Errorf("order status not found")
+	}
+	for _, t := range order.Status.Targets {
+		if inBatch(t.ID, batch) && t.State != storage.StateRunning && t.State != storage.StateSucceeded {
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+// haltRollout cancels the deployment to remaining targets
+func (m *manager) haltRollout(orderID string, batch, batches int, reason string, remaining []string) {
+	log.Printf("Rollout of %s: halted: %s", orderID, reason)
+	m.setRolloutStatus(orderID, &storage.RolloutStatus{Batch: batch, Batches: batches, Halted: true, Message: reason})
+	if len(remaining) > 0 {
+		m.setTargetStates(orderID, storage.StateCancelled, remaining...)
+		m.storeLogFatal(orderID, model.StageInstall, "rollout halted: "+reason, remaining...)
+	}
+}
+
+func (m *manager) setRolloutStatus(orderID string, rollout *storage.RolloutStatus) {
+	m.updateStatus(orderID, func(order *storage.Order) (changed bool) {
+		order.Status.Rollout = rollout
+		order.Status.UpdatedAt = model.UnixTime()
+		return true
+	})
+}
+
+// startRollout registers the rollout and returns a channel which is closed when the order is stopped
+func (m *manager) startRollout(orderID string) <-chan struct{} {
+	m.rolloutLocker.Lock()
+	defer m.rolloutLocker.Unlock()
+	stop := make(chan struct{})
+	m.rollouts[orderID] = stop
+	return stop
+}
+
+func (m *manager) endRollout(orderID string) {
+	m.rolloutLocker.Lock()
+	defer m.rolloutLocker.Unlock()
+	delete(m.rollouts, orderID)
+}
+
+// stopRollout halts the rollout of the order, if any
+func (m *manager) stopRollout(orderID string) {
+	m.rolloutLocker.Lock()
+	defer m.rolloutLocker.Unlock()
+	if stop, found := m.rollouts[orderID]; found {
+		close(stop)
+		delete(m.rollouts, orderID)
+	}
+}
+
+func exceedsFailureRate(failed int, batch []string, rollout *storage.Rollout) bool {
+	return float64(failed)*100/float64(len(batch)) > rollout.MaxFailure
+}
+
+func inBatch(id string, batch []string) bool {
+	for i := range batch {
+		if batch[i] == id {
+			return true
+		}
+	}
+	return false
+}
+
+func finalState(state string) bool {
+	switch state {
+	case storage.StateSucceeded, storage.StateFailed, storage.StateUndeliverable, storage.StateCancelled:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+const (
+	SchedulerInterval = 30 * time.Second
+)
+
+// scheduler starts scheduled orders when due and deploys to pending targets when their maintenance windows open
+//	The schedules are persisted with orders and resumed after restarts.
+func (m *manager) scheduler() {
+	for ; true; <-time.Tick(SchedulerInterval) {
+		orders, err := m.storage.GetScheduledOrders()
+		if err != nil {
+			log.Printf("Error getting scheduled orders: %s", err)
+			continue
+		}
+		for i := range orders {
+			m.processSchedule(&orders[i])
+		}
+	}
+}
+
+func (m *manager) processSchedule(order *storage.Order) {
+	defer recovery()
+	if order.Schedule == nil || order.Schedule.Stopped {
+		return
+	}
+
+	if !order.Schedule.Started {
+		if !order.Schedule.Due(time.Now()) {
+			return
+		}
+		m.scheduleLocker.Lock()
+		if m.scheduleStopped(order.ID) {
+			m.scheduleLocker.Unlock()
+			return
+		}
+		order.Schedule.Started = true
+		_, err := m.storage.UpdateOrderSchedule(order.ID, order.Schedule)
+		m.scheduleLocker.Unlock()
+		if err != nil {
+			log.Printf("Error updating order schedule: %s", err)
+			return
+		}
+		log.Println("Starting scheduled order:", order.ID)
+		go m.composeTask(order)
+		return
+	}
+
+	if len(order.Schedule.Pending) > 0 && order.Deploy != nil {
+		m.dispatchInWindows(order, nil, order.Schedule.Pending)
+	}
+}
+
+// dispatchInWindows sends the task to targets in open maintenance windows and keeps others pending
+//	The task is composed when there are targets to send to, if not given.
+func (m *manager) dispatchInWindows(order *storage.Order, task *model.Task, targets []string) {
+	m.scheduleLocker.Lock()
+	defer m.scheduleLocker.Unlock()
+
+	if m.scheduleStopped(order.ID) {
+		return
+	}
+
+	wasPending := make(map[string]bool)
+	for _, id := range order.Schedule.Pending {
+		wasPending[id] = true
+	}
+
+	now := time.Now()
+	var due, pending, waiting, removed []string
+	for _, id := range targets {
+		target, err := m.storage.GetTarget(id)
+		if err != nil {
+			log.Printf("Error getting target: %s", err)
+			pending = append(pending, id)
+			continue
+		}
+		switch {
+		case target == nil:
+			removed = append(removed, id)
+		case order.Schedule.Open(target, now):
+			due = append(due, id)
+		default:
+			pending = append(pending, id)
+			if !wasPending[id] {
+				waiting = append(waiting, id)
+			}
+		}
+	}
+	if len(due)+len(removed) == 0 && len(waiting) == 0 {
+		return // nothing changed
+	}
+
+	order.Schedule.Pending = pending
+	_, err := m.storage.UpdateOrderSchedule(order.ID, order.Schedule)
+	if err != nil {
+		log.Printf("Error updating order schedule: %s", err)
+		return
+	}
+
+	if len(waiting) > 0 {
+		m.storeLog(order.ID, model.StageInstall, "waiting for maintenance window", false, waiting...)
+	}
+	if len(removed) > 0 {
+		m.setTargetStates(order.ID, storage.StateUndeliverable, removed...)
+		m.storeLogFatal(order.ID, model.StageInstall, "target is removed", removed...)
+	}
+	if len(due) > 0 && task == nil {
+		var ok bool
+		task, ok = m.deployTask(order, due)
+		if !ok {
+			return
+		}
+	}
+	if len(due) > 0 {
+		log.Printf("Dispatching %s to %d target(s) in maintenance window", order.ID, len(due))
+		go m.sendDeploy(order, task, storage.Match{IDs: due, List: due})
+	}
+}
+
+// stopSchedule marks the schedule of the order as stopped, so that it is not started or dispatched to pending targets anymore
+func (m *manager) stopSchedule(id string) error {
+	m.scheduleLocker.Lock()
+	defer m.scheduleLocker.Unlock()
+
+	order, err := m.storage.GetOrder(id)
+	if err != nil {
+		return fmt.Errorf("error querying order: %s", err)
+	}
+	if order == nil || order.Schedule == nil || order.Schedule.Stopped {
+		return nil
+	}
+	order.Schedule.Stopped = true
+	order.Schedule.Pending = nil
+	_, err = m.storage.UpdateOrderSchedule(id, order.Schedule)
+	if err != nil {
+		return fmt.Errorf("error updating order schedule: %s", err)
+	}
+	return nil
+}
+
+// scheduleStopped returns true if the stored order is stopped or removed since it was read
+//	The schedule locker must be held, to not dispatch orders being stopped.
+func (m *manager) scheduleStopped(id string) bool {
+	order, err := m.storage.GetOrder(id)
+	if err != nil {
+		log.Printf("Error getting order schedule: %s", err)
+		return true
+	}
+	return order == nil || order.Schedule == nil || order.Schedule.Stopped
+}
+
+// logScheduled informs the targets that the order is held until the start time
+func (m *manager) logScheduled(order *storage.Order) {
+	log.Printf("Scheduled order %s to start at %s", order.ID, order.Schedule.Start)
+	stage := model.StageInstall
+	if order.Build != nil {
+		stage = model.StageBuild
+	}
+	m.storeLog(order.ID, stage, fmt.Sprintf("scheduled to start at %s", order.Schedule.Start), false, m.getTargetList(order)...)
+}
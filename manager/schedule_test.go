@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// runScheduler does one round of the scheduler
+func runScheduler(t *testing.T, m *manager) {
+	orders, err := m.storage.GetScheduledOrders()
+	if err != nil {
+		t.Fatalf("Error getting scheduled orders: %s", err)
+	}
+	for i := range orders {
+		m.processSchedule(&orders[i])
+	}
+}
+
+func TestScheduleDispatch(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "open", Tags: []string{"open"}}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "closed", Tags: []string{"closed"}}})
+
+	// windows relative to now, in UTC
+	now := time.Now().UTC()
+	var order storage.Order
+	err := yaml.Unmarshal([]byte(`
+schedule:
+  windows:
+    - tags: [open]
+      start: "`+now.Add(-time.Hour).Format("15:04")+`"
+      end: "`+now.Add(time.Hour).Format("15:04")+`"
+    - tags: [closed]
+      start: "`+now.Add(2*time.Hour).Format("15:04")+`"
+      end: "`+now.Add(3*time.Hour).Format("15:04")+`"
+deploy:
+  run: {commands: [./app]}
+  target: {tags: [open, closed]}
+`), &order)
+	if err != nil {
+		t.Fatalf("Error parsing order: %s", err)
+	}
+	order.ID = "scheduled"
+	order.Status = storage.NewOrderStatus([]string{"open", "closed"})
+	order.Schedule.Started = true
+	order.Schedule.Pending = []string{"open", "closed"}
+	s.AddOrder(&order)
+
+	runScheduler(t, m)
+	stored, _ := s.GetOrder(order.ID)
+	if len(stored.Schedule.Pending) != 1 || stored.Schedule.Pending[0] != "closed" {
+		t.Fatalf("Expected closed target to be pending, got %v", stored.Schedule.Pending)
+	}
+	waitForOutput(t, s, order.ID, "sending task")
+
+	// the window opens
+	stored.Schedule.Windows[1] = stored.Schedule.Windows[0]
+	s.UpdateOrderSchedule(order.ID, stored.Schedule)
+	runScheduler(t, m)
+	stored, _ = s.GetOrder(order.ID)
+	if len(stored.Schedule.Pending) != 0 {
+		t.Fatalf("Expected no pending targets, got %v", stored.Schedule.Pending)
+	}
+
+	// nothing is left to dispatch
+	time.Sleep(100 * time.Millisecond)
+	sent := strings.Count(s.Outputs(order.ID), "sending task")
+	orders, _ := s.GetScheduledOrders()
+	if len(orders) != 0 {
+		t.Fatalf("Expected no scheduled orders, got %d", len(orders))
+	}
+	runScheduler(t, m)
+	time.Sleep(100 * time.Millisecond)
+	if count := strings.Count(s.Outputs(order.ID), "sending task"); count != sent {
+		t.Fatalf("Task was sent again: %d times instead of %d", count, sent)
+	}
+}
+
+// TestStopScheduledOrder checks that a stopped order is neither started nor dispatched to targets in windows
+func TestStopScheduledOrder(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"gw"}}})
+
+	now := time.Now().UTC()
+	var due, windowed storage.Order
+	err := yaml.Unmarshal([]byte(`
+schedule:
+  start: "`+now.Add(-time.Minute).Format(time.RFC3339)+`"
+deploy:
+  run: {commands: [./app]}
+  target: {ids: [gw]}
+`), &due)
+	if err != nil {
+		t.Fatalf("Error parsing order: %s", err)
+	}
+	due.ID = "due"
+	due.Deploy.Match = storage.Match{IDs: []string{"gw"}, List: []string{"gw"}}
+	s.AddOrder(&due)
+
+	err = yaml.Unmarshal([]byte(`
+schedule:
+  windows:
+    - start: "`+now.Add(-time.Hour).Format("15:04")+`"
+      end: "`+now.Add(time.Hour).Format("15:04")+`"
+deploy:
+  run: {commands: [./app]}
+  target: {ids: [gw]}
+`), &windowed)
+	if err != nil {
+		t.Fatalf("Error parsing order: %s", err)
+	}
+	windowed.ID = "windowed"
+	windowed.Status = storage.NewOrderStatus([]string{"gw"})
+	windowed.Schedule.Started = true
+	windowed.Schedule.Pending = []string{"gw"}
+	s.AddOrder(&windowed)
+
+	// orders read by the scheduler before being stopped
+	orders, err := s.GetScheduledOrders()
+	if err != nil || len(orders) != 2 {
+		t.Fatalf("Expected 2 scheduled orders, got %d: %v", len(orders), err)
+	}
+	for _, id := range []string{due.ID, windowed.ID} {
+		found, _, err := m.stopOrder(id)
+		if !found || err != nil {
+			t.Fatalf("Error stopping %s: %v %v", id, found, err)
+		}
+	}
+	for i := range orders {
+		m.processSchedule(&orders[i])
+	}
+	runScheduler(t, m)
+
+	time.Sleep(100 * time.Millisecond)
+	for _, id := range []string{due.ID, windowed.ID} {
+		if outputs := s.Outputs(id); outputs != "" {
+			t.Fatalf("Expected nothing to be sent for %s, got:\n%s", id, outputs)
+		}
+		stored, _ := s.GetOrder(id)
+		if !stored.Schedule.Stopped || len(stored.Schedule.Pending) != 0 {
+			t.Fatalf("Expected stopped schedule of %s, got %+v", id, stored.Schedule)
+		}
+	}
+	stored, _ := s.GetOrder(due.ID)
+	if stored.Schedule.Started {
+		t.Fatalf("Stopped order is started")
+	}
+}
+
+// waitForOutput waits for a log of the task, sent asynchronously
+func waitForOutput(t *testing.T, s *memStorage, task, output string) {
+	for i := 0; i < 50; i++ {
+		if strings.Contains(s.Outputs(task), output) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Expected log %q for %s, got:\n%s", output, task, s.Outputs(task))
+}
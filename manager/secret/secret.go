@@ -0,0 +1,124 @@
+// Package secret encrypts data to the Curve keys of targets and the manager
+//	Data is sealed with an ephemeral X25519 key agreement and AES-256-GCM.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	KeySize = 32
+)
+
+// ParseCurveKey decodes a Curve key in the format of key files, i.e. base64 encoded Z85 text
+func ParseCurveKey(encoded string) ([]byte, error) {
+	z85, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding base64: %s", err)
+	}
+	key, err := z85Decode(string(z85))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size: %d", len(key))
+	}
+	return key, nil
+}
+
+// Seal encrypts the data such that it can only be opened with the private key corresponding to the public key
+//	The output consists of the ephemeral public key, the nonce and the ciphertext.
+func Seal(publicKey, data []byte) ([]byte, error) {
+	if len(publicKey) != KeySize {
+		return nil, fmt.Errorf("invalid public key size: %d", len(publicKey))
+	}
+	var ephemeral, ephemeralPublic [KeySize]byte
+	_, err := rand.Read(ephemeral[:])
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %s", err)
+	}
+	curve25519.ScalarBaseMult(&ephemeralPublic, &ephemeral)
+	shared, err := x25519(ephemeral[:], publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(shared, ephemeralPublic[:], publicKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error generating nonce: %s", err)
+	}
+
+	out := append(ephemeralPublic[:], nonce...)
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+// Open decrypts data sealed to the public key of the given private key
+func Open(privateKey, sealed []byte) ([]byte, error) {
+	if len(privateKey) != KeySize {
+		return nil, fmt.Errorf("invalid private key size: %d", len(privateKey))
+	}
+	if len(sealed) < KeySize {
+		return nil, fmt.Errorf("sealed data is too short")
+	}
+	shared, err := x25519(privateKey, sealed[:KeySize])
+	if err != nil {
+		return nil, err
+	}
+	var private, public [KeySize]byte
+	copy(private[:], privateKey)
+	curve25519.ScalarBaseMult(&public, &private)
+
+	aead, err := newAEAD(shared, sealed[:KeySize], public[:])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[KeySize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data is too short")
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting: %s", err)
+	}
+	return data, nil
+}
+
+// x25519 returns the shared secret of the key agreement, rejecting low order public keys
+func x25519(privateKey, publicKey []byte) ([]byte, error) {
+	var private, public, shared [KeySize]byte
+	copy(private[:], privateKey)
+	copy(public[:], publicKey)
+	curve25519.ScalarMult(&shared, &private, &public)
+	var zero [KeySize]byte
+	if subtle.ConstantTimeCompare(shared[:], zero[:]) == 1 {
+		return nil, fmt.Errorf("error in key agreement: low order public key")
+	}
+	return shared[:], nil
+}
+
+// newAEAD derives the encryption key from the shared secret and both public keys
+func newAEAD(shared, ephemeralPublic, recipientPublic []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephemeralPublic)
+	h.Write(recipientPublic)
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
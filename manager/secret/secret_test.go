@@ -0,0 +1,75 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// Curve key pair of the zmq_curve manual, base64 encoded as in key files
+var (
+	curvePublic  = base64.StdEncoding.EncodeToString([]byte("Yne@$w-vo<fVvi]a<NY6T1ed:M$fCG*[IaLV{hID"))
+	curvePrivate = base64.StdEncoding.EncodeToString([]byte("D:)Q[IlAW!ahhC2ac:9*A}h:p?([4%wOTJ%JR%cs"))
+)
+
+func TestSecretSealOpen(t *testing.T) {
+	public, err := ParseCurveKey(curvePublic)
+	if err != nil {
+		t.Fatalf("Error parsing public key: %s", err)
+	}
+	private, err := ParseCurveKey(curvePrivate)
+	if err != nil {
+		t.Fatalf("Error parsing private key: %s", err)
+	}
+
+	t.Run("key pair", func(t *testing.T) {
+		var key, derived [KeySize]byte
+		copy(key[:], private)
+		curve25519.ScalarBaseMult(&derived, &key)
+		if !bytes.Equal(derived[:], public) {
+			t.Fatalf("Public key does not belong to the private key")
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		sealed, err := Seal(public, []byte("p4ssw0rd"))
+		if err != nil {
+			t.Fatalf("Error sealing: %s", err)
+		}
+		if bytes.Contains(sealed, []byte("p4ssw0rd")) {
+			t.Fatalf("Sealed data contains the plaintext")
+		}
+		opened, err := Open(private, sealed)
+		if err != nil {
+			t.Fatalf("Error opening: %s", err)
+		}
+		if string(opened) != "p4ssw0rd" {
+			t.Fatalf("Unexpected value: %s", opened)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		sealed, _ := Seal(public, []byte("p4ssw0rd"))
+		sealed[len(sealed)-1] ^= 1
+		_, err := Open(private, sealed)
+		if err == nil {
+			t.Fatalf("Expected error for tampered data")
+		}
+	})
+
+	t.Run("low order key", func(t *testing.T) {
+		_, err := Seal(make([]byte, KeySize), []byte("p4ssw0rd"))
+		if err == nil {
+			t.Fatalf("Expected error for low order public key")
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := ParseCurveKey(base64.StdEncoding.EncodeToString([]byte("HelloWorld")))
+		if err == nil {
+			t.Fatalf("Expected error for key of wrong size")
+		}
+	})
+}
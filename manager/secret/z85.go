@@ -0,0 +1,32 @@
+package secret
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Z85 alphabet as specified in https://rfc.zeromq.org/spec:32/Z85
+const z85Chars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ.-:+=^!/*?&<>()[]{}@%$#"
+
+// z85Decode decodes Z85 text into binary, e.g. Curve keys generated by libzmq
+func z85Decode(s string) ([]byte, error) {
+	if len(s)%5 != 0 {
+		return nil, fmt.Errorf("invalid z85 length: %d", len(s))
+	}
+	out := make([]byte, 0, len(s)*4/5)
+	for i := 0; i < len(s); i += 5 {
+		var value uint64
+		for j := 0; j < 5; j++ {
+			index := strings.IndexByte(z85Chars, s[i+j])
+			if index < 0 {
+				return nil, fmt.Errorf("invalid z85 character: %q", s[i+j])
+			}
+			value = value*85 + uint64(index)
+		}
+		if value > 0xFFFFFFFF {
+			return nil, fmt.Errorf("invalid z85 block: %s", s[i:i+5])
+		}
+		out = append(out, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+	}
+	return out, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/secret"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"code.linksmart.eu/dt/deployment-tool/manager/zeromq"
+)
+
+const (
+	Redacted = "[REDACTED]"
+)
+
+var secretNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// secretKeys are the Curve keys of the manager, used to encrypt stored secrets
+type secretKeys struct {
+	private []byte
+	public  []byte
+}
+
+func loadSecretKeys() (*secretKeys, error) {
+	private, err := zeromq.ReadKeyFile(os.Getenv(zeromq.EnvPrivateKey), zeromq.DefaultPrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key file: %s", err)
+	}
+	public, err := zeromq.ReadKeyFile(os.Getenv(zeromq.EnvPublicKey), zeromq.DefaultPublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key file: %s", err)
+	}
+	var keys secretKeys
+	keys.private, err = secret.ParseCurveKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding private key: %s", err)
+	}
+	keys.public, err = secret.ParseCurveKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding public key: %s", err)
+	}
+	return &keys, nil
+}
+
+// putSecret encrypts and stores the value, returns true if the secret was created
+func (m *manager) putSecret(name, value string) (created bool, err error) {
+	existing, err := m.storage.GetSecret(name)
+	if err != nil {
+		return false, fmt.Errorf("error querying secret: %s", err)
+	}
+
+	sealed, err := secret.Seal(m.secretKeys.public, []byte(value))
+	if err != nil {
+		return false, fmt.Errorf("error encrypting secret: %s", err)
+	}
+	s := storage.Secret{
+		SecretMeta: storage.SecretMeta{Name: name, UpdatedAt: model.UnixTime()},
+		Sealed:     sealed,
+	}
+	if existing != nil {
+		s.CreatedAt = existing.CreatedAt
+	} else {
+		s.CreatedAt = s.UpdatedAt
+	}
+	err = m.storage.IndexSecret(&s)
+	if err != nil {
+		return false, fmt.Errorf("error storing secret: %s", err)
+	}
+	m.secretChanged(name)
+	return existing == nil, nil
+}
+
+func (m *manager) getSecrets() ([]storage.SecretMeta, error) {
+	secrets, err := m.storage.GetSecrets()
+	if err != nil {
+		return nil, fmt.Errorf("error querying secrets: %s", err)
+	}
+	return secrets, nil
+}
+
+func (m *manager) deleteSecret(name string) (found bool, err error) {
+	found, err = m.storage.DeleteSecret(name)
+	if err != nil {
+		return false, fmt.Errorf("error deleting secret: %s", err)
+	}
+	m.secretChanged(name)
+	return found, nil
+}
+
+// checkSecrets returns an error if any of the referenced secrets is not in the store
+func (m *manager) checkSecrets(refs []model.SecretRef) error {
+	for _, ref := range refs {
+		s, err := m.storage.GetSecret(ref.Name)
+		if err != nil {
+			return fmt.Errorf("error querying secret: %s", err)
+		}
+		if s == nil {
+			return fmt.Errorf("secret not found: %s", ref.Name)
+		}
+	}
+	return nil
+}
+
+// secretValues decrypts the referenced secrets
+func (m *manager) secretValues(refs []model.SecretRef) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, ref := range refs {
+		if _, found := values[ref.Name]; found {
+			continue
+		}
+		s, err := m.storage.GetSecret(ref.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error querying secret: %s", err)
+		}
+		if s == nil {
+			return nil, fmt.Errorf("secret not found: %s", ref.Name)
+		}
+		value, err := secret.Open(m.secretKeys.private, s.Sealed)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting secret %s: %s", ref.Name, err)
+		}
+		values[ref.Name] = string(value)
+	}
+	return values, nil
+}
+
+// urlCredential decrypts the password or token of the URL source
+func (m *manager) urlCredential(u *source.URL) (string, error) {
+	if u.Auth == nil {
+		return "", nil
+	}
+	values, err := m.secretValues([]model.SecretRef{{Name: u.Auth.Secret}})
+	if err != nil {
+		return "", err
+	}
+	return values[u.Auth.Secret], nil
+}
+
+// sealSecrets encrypts the secret values of the task to the keys of targets
+//	Returns the messages for each target and the targets for which encryption failed.
+func (m *manager) sealSecrets(taskID string, values map[string]string, targets []string) (map[string][]byte, map[string]string) {
+	plain, _ := json.Marshal(values)
+	messages := make(map[string][]byte)
+	failed := make(map[string]string) // target id: error
+	for _, id := range targets {
+		target, err := m.storage.GetTarget(id)
+		if err != nil {
+			failed[id] = fmt.Sprintf("error querying target: %s", err)
+			continue
+		}
+		if target == nil {
+			failed[id] = "target not found"
+			continue
+		}
+		key, err := secret.ParseCurveKey(target.PublicKey)
+		if err != nil {
+			failed[id] = fmt.Sprintf("error decoding target key: %s", err)
+			continue
+		}
+		sealed, err := secret.Seal(key, plain)
+		if err != nil {
+			failed[id] = fmt.Sprintf("error encrypting secrets: %s", err)
+			continue
+		}
+		w := model.RequestWrapper{
+			Time:    model.UnixTime(),
+			Secrets: &model.TaskSecrets{Task: taskID, Sealed: sealed},
+		}
+		messages[id], _ = json.Marshal(&w)
+	}
+	return messages, failed
+}
+
+// redactLogs replaces values of secrets in logs of orders with secrets
+func (m *manager) redactLogs(logs []storage.Log) {
+	for i := range logs {
+		values := m.redactions(logs[i].Task)
+		for _, value := range values {
+			logs[i].Output = strings.Replace(logs[i].Output, value, Redacted, -1)
+			logs[i].Command = strings.Replace(logs[i].Command, value, Redacted, -1)
+		}
+	}
+}
+
+// redaction holds the secret values of an order
+type redaction struct {
+	secrets []string // names
+	values  []string
+	stale   bool // a secret has changed since loading the values
+}
+
+// redactions returns the secret values of the order, loading them once per order and again after secrets change
+//	Values of changed or deleted secrets remain, as they may still be used by earlier deployments.
+func (m *manager) redactions(orderID string) []string {
+	m.redactionLocker.Lock()
+	defer m.redactionLocker.Unlock()
+
+	cached, found := m.redactionCache[orderID]
+	if found && !cached.stale {
+		return cached.values
+	}
+	order, err := m.storage.GetOrder(orderID)
+	if err != nil {
+		log.Printf("Error getting order: %s", err)
+		if found {
+			return cached.values
+		}
+		return nil
+	}
+	if order == nil {
+		return nil // the logs may arrive before the order is stored
+	}
+
+	r := &redaction{}
+	if found {
+		r.values = cached.values
+	} else {
+		m.redactionOrders = append(m.redactionOrders, orderID)
+		if len(m.redactionOrders) > RedactionCacheSize {
+			delete(m.redactionCache, m.redactionOrders[0])
+			m.redactionOrders = m.redactionOrders[1:]
+		}
+	}
+	for _, ref := range order.Secrets {
+		r.secrets = append(r.secrets, ref.Name)
+		secrets, err := m.secretValues([]model.SecretRef{ref})
+		if err != nil {
+			log.Printf("Error getting secrets of %s: %s", orderID, err)
+			continue
+		}
+		if value := secrets[ref.Name]; value != "" && !inBatch(value, r.values) {
+			r.values = append(r.values, value)
+		}
+	}
+	m.redactionCache[orderID] = r
+	return r.values
+}
+
+// secretChanged reloads the redactions of cached orders with the secret
+func (m *manager) secretChanged(name string) {
+	m.redactionLocker.Lock()
+	defer m.redactionLocker.Unlock()
+	for _, r := range m.redactionCache {
+		if inBatch(name, r.secrets) {
+			r.stale = true
+		}
+	}
+}
+
+func (m *manager) forgetRedactions(orderID string) {
+	m.redactionLocker.Lock()
+	defer m.redactionLocker.Unlock()
+	if _, found := m.redactionCache[orderID]; !found {
+		return
+	}
+	delete(m.redactionCache, orderID)
+	for i, id := range m.redactionOrders {
+		if id == orderID {
+			m.redactionOrders = append(m.redactionOrders[:i], m.redactionOrders[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/source"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRedactionCache(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	for i := 0; i < 2*RedactionCacheSize; i++ {
+		s.AddOrder(&storage.Order{Header: model.Header{ID: fmt.Sprintf("order-%d", i)}})
+		m.redactions(fmt.Sprintf("order-%d", i))
+	}
+	if len(m.redactionCache) != RedactionCacheSize || len(m.redactionOrders) != RedactionCacheSize {
+		t.Fatalf("Expected %d cached orders, got %d", RedactionCacheSize, len(m.redactionCache))
+	}
+	if _, found := m.redactionCache["order-0"]; found {
+		t.Fatalf("Expected oldest order to be evicted")
+	}
+
+	last := fmt.Sprintf("order-%d", 2*RedactionCacheSize-1)
+	m.forgetRedactions(last)
+	if _, found := m.redactionCache[last]; found || len(m.redactionOrders) != RedactionCacheSize-1 {
+		t.Fatalf("Expected order to be forgotten")
+	}
+}
+
+// TestRedactionsSecretChange checks that changed secrets are redacted, along with their earlier values
+func TestRedactionsSecretChange(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	_, err := m.putSecret("db", "old-password")
+	if err != nil {
+		t.Fatalf("Error storing secret: %s", err)
+	}
+	order := &storage.Order{Header: model.Header{ID: "order"}, Secrets: []model.SecretRef{{Name: "db", Env: "DB_PASSWORD"}}}
+
+	// logs arriving before the order is stored
+	if values := m.redactions(order.ID); len(values) != 0 {
+		t.Fatalf("Expected no redactions for a missing order, got %v", values)
+	}
+	s.AddOrder(order)
+	expect := func(values ...string) {
+		t.Helper()
+		redactions := m.redactions(order.ID)
+		if strings.Join(redactions, ",") != strings.Join(values, ",") {
+			t.Fatalf("Expected redactions %v, got %v", values, redactions)
+		}
+	}
+	expect("old-password")
+
+	_, err = m.putSecret("other", "unrelated")
+	if err != nil {
+		t.Fatalf("Error storing secret: %s", err)
+	}
+	_, err = m.putSecret("db", "new-password")
+	if err != nil {
+		t.Fatalf("Error storing secret: %s", err)
+	}
+	expect("old-password", "new-password")
+
+	logs := []storage.Log{{Log: model.Log{Task: order.ID, Output: "connecting with new-password"}}}
+	m.redactLogs(logs)
+	if logs[0].Output != "connecting with "+Redacted {
+		t.Fatalf("Secret is not redacted: %s", logs[0].Output)
+	}
+
+	_, err = m.deleteSecret("db")
+	if err != nil {
+		t.Fatalf("Error deleting secret: %s", err)
+	}
+	expect("old-password", "new-password")
+}
+
+// TestSendTaskSealFailure checks that the task is not announced to targets whose secrets could not be encrypted
+func TestSendTaskSealFailure(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	// key of the zmq_curve manual
+	key := base64.StdEncoding.EncodeToString([]byte("Yne@$w-vo<fVvi]a<NY6T1ed:M$fCG*[IaLV{hID"))
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "good", Tags: []string{"swarm"}, PublicKey: key}})
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "bad", Tags: []string{"swarm"}, PublicKey: "invalid"}})
+	_, err := m.putSecret("password", "p4ssw0rd")
+	if err != nil {
+		t.Fatalf("Error storing secret: %s", err)
+	}
+	order := storage.Order{Status: storage.NewOrderStatus([]string{"good", "bad"})}
+	order.ID = "order"
+	s.AddOrder(&order)
+
+	// replace the pipe to receive the requests
+	m.pipe = model.NewPipe()
+	task := &model.Task{
+		Header:  order.Header,
+		Deploy:  &model.Deploy{},
+		Secrets: []model.SecretRef{{Name: "password", Env: "PASSWORD"}},
+	}
+	go m.sendTask(task, storage.Match{Tags: []string{"swarm"}, List: []string{"good", "bad"}})
+
+	var topics []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message := <-m.pipe.RequestCh:
+			topics = append(topics, message.Topic)
+		case <-timeout:
+			t.Fatalf("Task is not sent, got requests to %v", topics)
+		}
+		if topics[len(topics)-1] == task.ID {
+			break
+		}
+	}
+	for _, topic := range topics {
+		if topic == model.FormatTopicID("bad") || topic == model.FormatTopicTag("swarm") {
+			t.Fatalf("Request sent to %s, got requests to %v", topic, topics)
+		}
+	}
+	if len(topics) != 3 || topics[0] != model.FormatTopicID("good") || topics[1] != model.FormatTopicID("good") {
+		t.Fatalf("Expected secrets and announcement to good, got requests to %v", topics)
+	}
+
+	stored, _ := s.GetOrder(order.ID)
+	for _, target := range stored.Status.Targets {
+		if target.ID == "bad" && target.State != storage.StateFailed {
+			t.Fatalf("Expected bad target to have failed, got %s", target.State)
+		}
+	}
+}
+
+// TestURLSourceCredentials checks that the URL source is fetched with the auth secret, which is not kept with the order
+func TestURLSourceCredentials(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+	a := restAPI{manager: m}
+	a.setupRouter()
+	s.AddTarget(&storage.Target{TargetBase: model.TargetBase{ID: "gw", Tags: []string{"swarm"}}})
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	f, _ := zw.Create("run.sh")
+	f.Write([]byte("echo url"))
+	zw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(zipped.Bytes())
+	}))
+	defer server.Close()
+	sum := sha256.Sum256(zipped.Bytes())
+
+	newOrder := func(secret string) *storage.Order {
+		var order storage.Order
+		err := yaml.Unmarshal([]byte(`
+source:
+  url:
+    url: `+server.URL+`/release.zip
+    sha256: `+hex.EncodeToString(sum[:])+`
+    auth: {secret: `+secret+`}
+deploy:
+  run: {commands: [./run.sh]}
+  target: {tags: [swarm]}
+`), &order)
+		if err != nil {
+			t.Fatalf("Error parsing order: %s", err)
+		}
+		return &order
+	}
+
+	if err := m.addOrder(newOrder("artifacts-token")); err == nil {
+		t.Fatalf("Expected error for missing auth secret")
+	}
+	_, err := m.putSecret("artifacts-token", "t0ken")
+	if err != nil {
+		t.Fatalf("Error storing secret: %s", err)
+	}
+	order := newOrder("artifacts-token")
+	err = m.addOrder(order)
+	if err != nil {
+		t.Fatalf("Error adding order: %s", err)
+	}
+	b, err := ioutil.ReadFile(fmt.Sprintf("%s/%s/%s/run.sh", source.OrdersDir, order.ID, source.SourceDir))
+	if err != nil || string(b) != "echo url" {
+		t.Fatalf("Unexpected source file: %s %v", b, err)
+	}
+
+	s.Lock()
+	stored := string(s.orders[order.ID])
+	s.Unlock()
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+order.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	for _, doc := range []string{stored, w.Body.String()} {
+		if strings.Contains(doc, "t0ken") {
+			t.Fatalf("Order contains the credential: %s", doc)
+		}
+	}
+}
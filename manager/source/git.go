@@ -0,0 +1,115 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const gitCloneDir = "git" // temporary clone in the order directory
+
+type Git struct {
+	URL    string `json:"url"`    // remote repository or local path
+	Ref    string `json:"ref"`    // branch, tag or commit. Defaults to the remote HEAD
+	Subdir string `json:"subdir"` // directory within the repository to use as source
+}
+
+func (g Git) Validate() error {
+	if g.URL == "" {
+		return fmt.Errorf("url not given")
+	}
+	if strings.HasPrefix(g.URL, "-") || strings.HasPrefix(g.Ref, "-") {
+		return fmt.Errorf("url and ref should not start with a dash")
+	}
+	path := g.Subdir
+	if strings.HasPrefix(path, "/") || strings.HasPrefix(path, "../") || strings.Contains(path, "/../") || path == ".." {
+		return fmt.Errorf("subdir should be relative to the repository. Given path is invalid: %s", path)
+	}
+	return nil
+}
+
+// Clone checks out the ref into order directory and returns the resolved commit hash
+func (g Git) Clone(orderID string) (commit string, err error) {
+	log.Printf("git: Cloning %s %s", g.URL, g.Ref)
+	workDir := fmt.Sprintf("%s/%s", OrdersDir, orderID)
+	cloneDir := fmt.Sprintf("%s/%s", workDir, gitCloneDir)
+	defer os.RemoveAll(cloneDir)
+
+	_, err = git("", "clone", "--quiet", "--no-checkout", "--", g.URL, cloneDir)
+	if err != nil {
+		return "", fmt.Errorf("error cloning repository: %s", err)
+	}
+	ref := g.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	_, err = git(cloneDir, "checkout", "--quiet", ref, "--")
+	if err != nil {
+		return "", fmt.Errorf("error checking out %s: %s", ref, err)
+	}
+	commit, err = git(cloneDir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("error resolving commit: %s", err)
+	}
+
+	err = os.RemoveAll(fmt.Sprintf("%s/.git", cloneDir))
+	if err != nil {
+		return "", fmt.Errorf("error removing git metadata: %s", err)
+	}
+	dir := cloneDir
+	if g.Subdir != "" {
+		dir = fmt.Sprintf("%s/%s", cloneDir, filepath.Clean(g.Subdir))
+		if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+			return "", fmt.Errorf("subdir not found in repository: %s", g.Subdir)
+		}
+		// the path is checked lexically on validation, but the repository may have symlinks on the way
+		within, err := withinDir(cloneDir, dir)
+		if err != nil {
+			return "", fmt.Errorf("error resolving subdir: %s", err)
+		}
+		if !within {
+			return "", fmt.Errorf("subdir is outside the repository: %s", g.Subdir)
+		}
+	}
+	err = os.Rename(dir, fmt.Sprintf("%s/%s", workDir, SourceDir))
+	if err != nil {
+		return "", fmt.Errorf("error moving source: %s", err)
+	}
+	log.Printf("git: Checked out commit %s", commit)
+	return commit, nil
+}
+
+// withinDir returns true if the path resolves to a directory inside the base directory, following symlinks
+func withinDir(base, path string) (bool, error) {
+	base, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return false, err
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return false, err
+	}
+	return rel != ".." && !strings.HasPrefix(rel, "../"), nil
+}
+
+// git runs the git command and returns its trimmed output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,128 @@
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGitSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "git-source")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("Error changing directory: %s", err)
+	}
+
+	// bare repository with two commits, the first tagged
+	run := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error running git %s: %s: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run(dir, "init", "--quiet", "--bare", "repo.git")
+	run(dir, "init", "--quiet", "work")
+	os.MkdirAll("work/app", 0755)
+	ioutil.WriteFile("work/app/run.sh", []byte("echo v1"), 0755)
+	ioutil.WriteFile("work/README", []byte("readme"), 0644)
+	run("work", "add", ".")
+	run("work", "commit", "--quiet", "-m", "v1")
+	run("work", "tag", "v1")
+	first := run("work", "rev-parse", "HEAD")
+	ioutil.WriteFile("work/app/run.sh", []byte("echo v2"), 0755)
+	run("work", "commit", "--quiet", "-am", "v2")
+	second := run("work", "rev-parse", "HEAD")
+	run("work", "push", "--quiet", "--tags", dir+"/repo.git", "HEAD:refs/heads/master")
+	run(dir+"/repo.git", "symbolic-ref", "HEAD", "refs/heads/master")
+	// branch with symlinks to directories outside and inside the repository
+	run("work", "checkout", "--quiet", "-b", "links")
+	os.Symlink(dir, "work/outside")
+	os.Symlink("app", "work/inside")
+	run("work", "add", ".")
+	run("work", "commit", "--quiet", "-m", "links")
+	run("work", "push", "--quiet", dir+"/repo.git", "links")
+
+	cases := []struct {
+		git    Git
+		commit string
+		file   string
+		body   string
+	}{
+		{Git{URL: dir + "/repo.git"}, second, "app/run.sh", "echo v2"},
+		{Git{URL: dir + "/repo.git", Ref: "v1"}, first, "app/run.sh", "echo v1"},
+		{Git{URL: dir + "/repo.git", Ref: first[:8], Subdir: "app"}, first, "run.sh", "echo v1"},
+		{Git{URL: dir + "/repo.git", Ref: "master", Subdir: "app/"}, second, "run.sh", "echo v2"},
+	}
+	for i, c := range cases {
+		orderID := fmt.Sprintf("order-%d", i)
+		commit, err := c.git.Clone(orderID)
+		if err != nil {
+			t.Fatalf("Error cloning %+v: %s", c.git, err)
+		}
+		if commit != c.commit {
+			t.Errorf("Expected commit %s for %+v, got %s", c.commit, c.git, commit)
+		}
+		b, err := ioutil.ReadFile(fmt.Sprintf("%s/%s/%s/%s", OrdersDir, orderID, SourceDir, c.file))
+		if err != nil {
+			t.Fatalf("Error reading cloned file: %s", err)
+		}
+		if string(b) != c.body {
+			t.Errorf("Unexpected content for %+v: %s", c.git, b)
+		}
+		if _, err := os.Stat(fmt.Sprintf("%s/%s/%s/.git", OrdersDir, orderID, SourceDir)); !os.IsNotExist(err) {
+			t.Errorf("Git metadata was not removed for %+v", c.git)
+		}
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for _, g := range []Git{
+			{URL: dir + "/missing.git"},
+			{URL: dir + "/repo.git", Ref: "v9"},
+			{URL: dir + "/repo.git", Subdir: "missing"},
+			{URL: dir + "/repo.git", Ref: "links", Subdir: "outside"},
+			{URL: dir + "/repo.git", Ref: "links", Subdir: "outside/work"},
+			{URL: dir + "/repo.git", Ref: "links", Subdir: "inside"},
+		} {
+			_, err := g.Clone("order-failing")
+			if err == nil {
+				t.Errorf("Expected error for %+v", g)
+			}
+			os.RemoveAll(OrdersDir + "/order-failing")
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		for g, valid := range map[Git]bool{
+			{URL: "https://example.com/repo.git"}: true,
+			{URL: "repo.git", Subdir: "app/src"}:  true,
+			{}:                                    false,
+			{URL: "--upload-pack=touch /tmp/x"}:   false,
+			{URL: "repo.git", Ref: "-b"}:          false,
+			{URL: "repo.git", Subdir: "../etc"}:   false,
+			{URL: "repo.git", Subdir: "/etc"}:     false,
+		} {
+			err := g.Validate()
+			if valid && err != nil {
+				t.Errorf("Unexpected validation error for %+v: %s", g, err)
+			}
+			if !valid && err == nil {
+				t.Errorf("Expected validation error for %+v", g)
+			}
+		}
+	})
+}
@@ -2,7 +2,10 @@ package source
 
 import (
 	"fmt"
+	"io"
 	"os"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
 )
 
 const (
@@ -10,12 +13,17 @@ const (
 	SourceDir     = "src"
 	SourceArchive = "src.tgz"
 	PackageDir    = "pkg"
+	ArchDir       = "arch" // packages of a build matrix, one work directory per architecture
 )
 
 type Source struct {
 	Paths *Paths `json:"paths"`
 	Zip   *Zip   `json:"zip"`
 	Order *Order `json:"order"`
+	Git   *Git   `json:"git"`
+	URL   *URL   `json:"url"`
+	// Upload is set for files received along with the order
+	Upload *Upload `json:"-" yaml:"-"`
 }
 
 func ExecDir(workDir string) (dir string, found bool) {
@@ -27,6 +35,36 @@ func ExecDir(workDir string) (dir string, found bool) {
 	return "", false
 }
 
+// ArchWorkDir returns the work directory of the package built for the architecture
+func ArchWorkDir(workDir, arch string) string {
+	if arch == "" {
+		return workDir
+	}
+	return fmt.Sprintf("%s/%s/%s", workDir, ArchDir, arch)
+}
+
+// extractFile extracts the zip or tar archive into dir
+func extractFile(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return model.Extract(f, dir)
+}
+
+// isArchive tells if the file is an archive in a supported format
+func isArchive(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 4)
+	n, _ := io.ReadFull(f, header)
+	return model.DetectArchive(header[:n]) != ""
+}
+
 func exists(path string) bool {
 	if _, err := os.Stat(path); err != nil && os.IsNotExist(err) {
 		return false
@@ -0,0 +1,94 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	copier "github.com/otiai10/copy"
+)
+
+const uploadPrefix = ".upload-" // temporary directories in orders directory
+
+// Upload holds files received with the order, e.g. as parts of a multipart request
+//	Archives are extracted into the source directory, other files are copied as they are.
+type Upload struct {
+	dir   string
+	files []string
+}
+
+// NewUpload creates a temporary directory for the received files
+func NewUpload() (*Upload, error) {
+	err := os.MkdirAll(OrdersDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating orders directory: %s", err)
+	}
+	dir, err := ioutil.TempDir(OrdersDir, uploadPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error creating upload directory: %s", err)
+	}
+	return &Upload{dir: dir}, nil
+}
+
+// Add streams the file to disk
+func (u *Upload) Add(name string, r io.Reader) error {
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == "/" {
+		return fmt.Errorf("invalid file name: %s", name)
+	}
+	for _, file := range u.files {
+		if file == name {
+			return fmt.Errorf("duplicate file name: %s", name)
+		}
+	}
+	f, err := os.Create(fmt.Sprintf("%s/%s", u.dir, name))
+	if err != nil {
+		return fmt.Errorf("error creating file: %s", err)
+	}
+	defer f.Close()
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return fmt.Errorf("error receiving %s: %s", name, err)
+	}
+	log.Printf("upload: Received %s sized %d bytes", name, n)
+	u.files = append(u.files, name)
+	return nil
+}
+
+// Files returns the names of received files
+func (u *Upload) Files() []string {
+	return u.files
+}
+
+// Extract places the files into order directory and removes the temporary directory
+func (u *Upload) Extract(orderID string) error {
+	defer u.Remove()
+	dest := fmt.Sprintf("%s/%s/%s", OrdersDir, orderID, SourceDir)
+	for _, name := range u.files {
+		path := fmt.Sprintf("%s/%s", u.dir, name)
+		if isArchive(path) {
+			log.Printf("upload: Extracting %s", name)
+			err := extractFile(path, dest)
+			if err != nil {
+				return fmt.Errorf("error extracting %s: %s", name, err)
+			}
+			continue
+		}
+		err := copier.Copy(path, fmt.Sprintf("%s/%s", dest, name))
+		if err != nil {
+			return fmt.Errorf("error copying %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// Remove deletes the received files
+func (u *Upload) Remove() {
+	err := os.RemoveAll(u.dir)
+	if err != nil {
+		log.Printf("upload: Error removing %s: %s", u.dir, err)
+	}
+}
@@ -0,0 +1,71 @@
+package source
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUploadSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload-source")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("Error changing directory: %s", err)
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, _ := zw.Create("app/run.sh")
+	w.Write([]byte("echo zip"))
+	zw.Close()
+
+	upload, err := NewUpload()
+	if err != nil {
+		t.Fatalf("Error creating upload: %s", err)
+	}
+	err = upload.Add("release.zip", &zipped)
+	if err != nil {
+		t.Fatalf("Error adding archive: %s", err)
+	}
+	err = upload.Add("../../config.yml", strings.NewReader("key: value"))
+	if err != nil {
+		t.Fatalf("Error adding file: %s", err)
+	}
+	err = upload.Add("config.yml", strings.NewReader("key: other"))
+	if err == nil {
+		t.Fatalf("Expected error for duplicate file name")
+	}
+
+	err = upload.Extract("order")
+	if err != nil {
+		t.Fatalf("Error extracting upload: %s", err)
+	}
+	for file, body := range map[string]string{
+		"app/run.sh": "echo zip",
+		"config.yml": "key: value",
+	} {
+		b, err := ioutil.ReadFile(fmt.Sprintf("%s/order/%s/%s", OrdersDir, SourceDir, file))
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", file, err)
+		}
+		if string(b) != body {
+			t.Errorf("Unexpected content of %s: %s", file, b)
+		}
+	}
+
+	// only the order directory remains
+	files, _ := ioutil.ReadDir(OrdersDir)
+	if len(files) != 1 || files[0].Name() != "order" {
+		t.Fatalf("Temporary upload directory was not removed: %v", files)
+	}
+}
@@ -0,0 +1,110 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DownloadTimeout = 10 * time.Minute
+	downloadFile    = "download" // temporary archive in the order directory
+)
+
+// URL is a zip or tar archive downloaded over HTTP
+type URL struct {
+	URL    string   `json:"url"`
+	SHA256 string   `json:"sha256"` // hex encoded checksum of the archive
+	Auth   *URLAuth `json:"auth"`
+}
+
+// URLAuth sets basic authorization with the username, otherwise bearer authorization
+//	The password or token is read from the secret store, so that it is not kept along with orders and pipelines.
+type URLAuth struct {
+	Username string `json:"username"`
+	Secret   string `json:"secret"` // name of the secret holding the password or token
+}
+
+func (u URL) Validate() error {
+	if !strings.HasPrefix(u.URL, "http://") && !strings.HasPrefix(u.URL, "https://") {
+		return fmt.Errorf("url should start with http:// or https://")
+	}
+	if b, err := hex.DecodeString(u.SHA256); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("sha256 should be a hex encoded SHA-256 checksum")
+	}
+	if u.Auth != nil && u.Auth.Secret == "" {
+		return fmt.Errorf("auth.secret should be the name of the secret holding the password or token")
+	}
+	return nil
+}
+
+// Download fetches the archive, verifies its checksum and extracts it into order directory
+//	The credential is the password or token of the auth secret.
+func (u URL) Download(orderID, credential string) error {
+	log.Printf("url: Downloading %s", u.URL)
+	workDir := fmt.Sprintf("%s/%s", OrdersDir, orderID)
+	err := os.MkdirAll(workDir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating order directory: %s", err)
+	}
+	path := fmt.Sprintf("%s/%s", workDir, downloadFile)
+	defer os.Remove(path)
+
+	checksum, err := u.fetch(path, credential)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(checksum, u.SHA256) {
+		return fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", u.URL, u.SHA256, checksum)
+	}
+
+	err = extractFile(path, fmt.Sprintf("%s/%s", workDir, SourceDir))
+	if err != nil {
+		return fmt.Errorf("error extracting archive from %s: %s", u.URL, err)
+	}
+	return nil
+}
+
+// fetch writes the response body to file and returns its hex encoded sha256
+func (u URL) fetch(path, credential string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, u.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %s", err)
+	}
+	if u.Auth != nil {
+		if u.Auth.Username != "" {
+			req.SetBasicAuth(u.Auth.Username, credential)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+credential)
+		}
+	}
+
+	client := http.Client{Timeout: DownloadTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error downloading %s: %s", u.URL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error downloading %s: %s", u.URL, res.Status)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("error creating file: %s", err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), res.Body)
+	if err != nil {
+		return "", fmt.Errorf("error downloading %s: %s", u.URL, err)
+	}
+	log.Printf("url: Size of data: %d bytes", n)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
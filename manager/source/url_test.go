@@ -0,0 +1,139 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestURLSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "url-source")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("Error changing directory: %s", err)
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, _ := zw.Create("app/run.sh")
+	w.Write([]byte("echo zip"))
+	zw.Close()
+
+	var tarred bytes.Buffer
+	gw := gzip.NewWriter(&tarred)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "app/run.sh", Mode: 0755, Size: 8, Typeflag: tar.TypeReg})
+	tw.Write([]byte("echo tar"))
+	tw.Close()
+	gw.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/release.zip":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write(zipped.Bytes())
+		case "/release":
+			if r.Header.Get("Authorization") != "Bearer t0ken" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write(tarred.Bytes())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	checksum := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	cases := []struct {
+		url        URL
+		credential string
+		body       string
+	}{
+		{URL{URL: server.URL + "/release.zip", SHA256: checksum(zipped.Bytes()), Auth: &URLAuth{Username: "ci", Secret: "ci-password"}}, "secret", "echo zip"},
+		{URL{URL: server.URL + "/release", SHA256: strings.ToUpper(checksum(tarred.Bytes())), Auth: &URLAuth{Secret: "ci-token"}}, "t0ken", "echo tar"},
+	}
+	for i, c := range cases {
+		orderID := fmt.Sprintf("order-%d", i)
+		err := c.url.Download(orderID, c.credential)
+		if err != nil {
+			t.Fatalf("Error downloading %s: %s", c.url.URL, err)
+		}
+		b, err := ioutil.ReadFile(fmt.Sprintf("%s/%s/%s/app/run.sh", OrdersDir, orderID, SourceDir))
+		if err != nil {
+			t.Fatalf("Error reading extracted file: %s", err)
+		}
+		if string(b) != c.body {
+			t.Errorf("Unexpected content from %s: %s", c.url.URL, b)
+		}
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		u := URL{URL: server.URL + "/release.zip", SHA256: checksum([]byte("other")), Auth: &URLAuth{Username: "ci", Secret: "ci-password"}}
+		err := u.Download("order-mismatch", "secret")
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("Expected checksum mismatch, got: %v", err)
+		}
+		if _, err := os.Stat(fmt.Sprintf("%s/order-mismatch/%s", OrdersDir, SourceDir)); !os.IsNotExist(err) {
+			t.Fatalf("Source was extracted despite checksum mismatch")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, u := range []URL{
+			{URL: server.URL + "/release.zip", SHA256: checksum(zipped.Bytes())}, // no auth
+			{URL: server.URL + "/missing.zip", SHA256: checksum(zipped.Bytes())},
+		} {
+			err := u.Download("order-failing", "")
+			if err == nil {
+				t.Errorf("Expected error for %s", u.URL)
+			}
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		sum := checksum(nil)
+		for i, c := range []struct {
+			url   URL
+			valid bool
+		}{
+			{URL{URL: "https://example.com/a.zip", SHA256: sum}, true},
+			{URL{URL: "https://example.com/a.zip"}, false},
+			{URL{URL: "https://example.com/a.zip", SHA256: "abc"}, false},
+			{URL{URL: "file:///etc/passwd", SHA256: sum}, false},
+			{URL{URL: "https://example.com/a.zip", SHA256: sum, Auth: &URLAuth{Secret: "token"}}, true},
+			{URL{URL: "https://example.com/a.zip", SHA256: sum, Auth: &URLAuth{Username: "a"}}, false},
+		} {
+			err := c.url.Validate()
+			if c.valid && err != nil {
+				t.Errorf("Unexpected validation error for case %d: %s", i, err)
+			}
+			if !c.valid && err == nil {
+				t.Errorf("Expected validation error for case %d", i)
+			}
+		}
+	})
+}
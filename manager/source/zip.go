@@ -1,17 +1,17 @@
 package source
 
 import (
-	"bytes"
 	"encoding/base64"
 	"fmt"
 	"log"
 
-	"github.com/mholt/archiver"
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
 )
 
 type Zip string
 
-// Decode base64 encoded zip archive and write it to order directory
+// Decode base64 encoded archive and write it to order directory
+//	Besides zip, tar.gz and tar.zst archives are detected from the content.
 func (zip Zip) Store(orderID string) error {
 	log.Println("zip: Storing the base64 encoded archive...")
 	data, err := base64.StdEncoding.DecodeString(string(zip))
@@ -19,7 +19,7 @@ func (zip Zip) Store(orderID string) error {
 		return err
 	}
 	log.Printf("zip: Size of data: %d bytes", len(data))
-	err = archiver.Zip.Read(bytes.NewBuffer(data), fmt.Sprintf("%s/%s/%s", OrdersDir, orderID, SourceDir))
+	err = model.DecompressFiles(data, fmt.Sprintf("%s/%s/%s", OrdersDir, orderID, SourceDir))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+type orderStatus struct {
+	ID     string               `json:"id"`
+	Status *storage.OrderStatus `json:"status"`
+}
+
+// orderLocker holds a lock per order, which is removed once released by all holders
+type orderLocker struct {
+	sync.Mutex
+	locks map[string]*orderLock
+}
+
+type orderLock struct {
+	sync.Mutex
+	users int // holder and waiters
+}
+
+func (l *orderLocker) lock(orderID string) {
+	l.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*orderLock)
+	}
+	ol, found := l.locks[orderID]
+	if !found {
+		ol = &orderLock{}
+		l.locks[orderID] = ol
+	}
+	ol.users++
+	l.Unlock()
+
+	ol.Lock()
+}
+
+func (l *orderLocker) unlock(orderID string) {
+	l.Lock()
+	ol := l.locks[orderID]
+	ol.users--
+	if ol.users == 0 {
+		delete(l.locks, orderID)
+	}
+	l.Unlock()
+
+	ol.Unlock()
+}
+
+// setTargetStates changes the state of targets without considering the current state
+func (m *manager) setTargetStates(orderID, state string, targets ...string) {
+	m.updateStatus(orderID, func(order *storage.Order) (changed bool) {
+		for _, target := range targets {
+			if order.Status.Reset(target, state) {
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// updateOrderStatus derives the state of targets from logs and updates the status of their orders
+func (m *manager) updateOrderStatus(logs []storage.Log) {
+	orders := make(map[string][]storage.Log)
+	var ids []string // to keep the order
+	for i := range logs {
+		if logs[i].Task == "" || logs[i].Task == model.TaskTerminal {
+			continue
+		}
+		if _, found := orders[logs[i].Task]; !found {
+			ids = append(ids, logs[i].Task)
+		}
+		orders[logs[i].Task] = append(orders[logs[i].Task], logs[i])
+	}
+
+	for _, id := range ids {
+		var deployed, discarded []string
+		m.updateStatus(id, func(order *storage.Order) (changed bool) {
+			for _, l := range orders[id] {
+				state := targetState(&l.Log, order)
+				if state == "" {
+					continue
+				}
+				if order.Status.Set(l.Target, state) {
+					changed = true
+					// deployment is recorded when the run starts, as long-running applications may not end,
+					//	and discarded if it fails afterwards
+					switch {
+					case l.Stage == model.StageBuild:
+					case state == storage.StateRunning || state == storage.StateSucceeded:
+						deployed = append(deployed, l.Target)
+					case state == storage.StateFailed:
+						discarded = append(discarded, l.Target)
+					}
+				}
+				// deployment will not follow a failed build
+				if state == storage.StateFailed && l.Stage == model.StageBuild {
+					for _, t := range order.Status.Targets {
+						if t.State == storage.StateQueued && order.Status.Set(t.ID, storage.StateFailed) {
+							changed = true
+						}
+					}
+				}
+			}
+			return changed
+		})
+		for _, target := range deployed {
+			m.recordDeployment(target, id)
+		}
+		for _, target := range discarded {
+			m.discardDeployment(target, id)
+		}
+	}
+}
+
+// updateStatus applies the changes to the status of the order and stores it
+func (m *manager) updateStatus(orderID string, apply func(*storage.Order) (changed bool)) {
+	m.statusLocker.lock(orderID)
+	defer m.statusLocker.unlock(orderID)
+
+	order, err := m.storage.GetOrder(orderID)
+	if err != nil {
+		log.Printf("Error getting order for status update: %s", err)
+		return
+	}
+	if order == nil || order.Status == nil { // removed or created before status tracking
+		return
+	}
+	if !apply(order) {
+		return
+	}
+
+	_, err = m.storage.UpdateOrderStatus(orderID, order.Status)
+	if err != nil {
+		log.Printf("Error updating order status: %s", err)
+		return
+	}
+	m.publishEvent(EventOrderStatus, orderStatus{orderID, order.Status})
+}
+
+// targetState returns the state of the target as indicated by the log, or empty string if the log indicates no state
+func targetState(l *model.Log, order *storage.Order) string {
+	switch {
+	case l.Output == model.StageEnd && l.Error:
+		return storage.StateFailed
+	case l.Output == model.StageEnd:
+		switch l.Stage {
+		case model.StageBuild: // logged by the manager after receiving the package
+			return storage.StateSucceeded
+		case model.StageInstall: // nothing to run
+			if order.Deploy != nil && len(order.Deploy.Run.Commands) == 0 && order.Deploy.Container == nil {
+				return storage.StateSucceeded
+			}
+		case model.StageRun:
+			return storage.StateSucceeded
+		}
+		return ""
+	case l.Command == model.CommandByManager:
+		return ""
+	case l.Command == model.CommandByAgent && l.Output != model.StageStart:
+		return storage.StateReceived
+	case l.Stage == model.StageRun:
+		return storage.StateRunning
+	case l.Stage == model.StageBuild, l.Stage == model.StagePreInstall, l.Stage == model.StageInstall:
+		return storage.StateInstalling
+	}
+	return ""
+}
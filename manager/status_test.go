@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"code.linksmart.eu/dt/deployment-tool/manager/storage"
+)
+
+// TestUpdateStatusPerOrder checks that status updates are serialized per order only
+func TestUpdateStatusPerOrder(t *testing.T) {
+	m, s, stop := startTestManager(t)
+	defer stop()
+
+	var targets []string
+	for i := 0; i < 20; i++ {
+		targets = append(targets, fmt.Sprintf("t%d", i))
+	}
+	for _, id := range []string{"a", "b"} {
+		s.AddOrder(&storage.Order{Header: model.Header{ID: id}, Status: storage.NewOrderStatus(targets)})
+	}
+
+	// an update of another order is not blocked by a pending one
+	release := make(chan struct{})
+	blocked := make(chan struct{})
+	go func() {
+		m.updateStatus("a", func(order *storage.Order) bool {
+			close(blocked)
+			<-release
+			return false
+		})
+	}()
+	<-blocked
+	updated := make(chan struct{})
+	go func() {
+		m.setTargetStates("b", storage.StateFailed, "t0")
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Update of order b is blocked by order a")
+	}
+
+	// concurrent updates of the same order are all kept
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			m.setTargetStates("a", storage.StateFailed, target)
+		}(target)
+	}
+	close(release)
+	wg.Wait()
+
+	order, _ := s.GetOrder("a")
+	for _, status := range order.Status.Targets {
+		if status.State != storage.StateFailed {
+			t.Fatalf("Update of %s is lost: %s", status.ID, status.State)
+		}
+	}
+	if len(m.statusLocker.locks) != 0 {
+		t.Fatalf("Expected released locks to be removed, got %d", len(m.statusLocker.locks))
+	}
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTargetDeployments(t *testing.T) {
+	var target Target
+
+	if target.RollbackDeployment("") != "" {
+		t.Fatalf("Expected nothing to roll back to")
+	}
+	for _, id := range []string{"a", "b", "a", "c"} {
+		target.AddDeployment(id)
+	}
+	if target.AddDeployment("c") {
+		t.Fatalf("Expected no change when adding the current deployment")
+	}
+	if !reflect.DeepEqual(target.Deployments, []string{"b", "a", "c"}) {
+		t.Fatalf("Unexpected deployments: %v", target.Deployments)
+	}
+
+	// order never deployed on this target
+	if previous := target.RollbackDeployment("x"); previous != "" {
+		t.Fatalf("Expected nothing to roll back to, got %s", previous)
+	}
+
+	// roll back an earlier order, removing the later ones
+	if previous := target.RollbackDeployment("a"); previous != "b" {
+		t.Fatalf("Expected b, got %s", previous)
+	}
+	if !reflect.DeepEqual(target.Deployments, []string{"b"}) {
+		t.Fatalf("Unexpected deployments: %v", target.Deployments)
+	}
+	if previous := target.RollbackDeployment(""); previous != "" {
+		t.Fatalf("Expected nothing to roll back to, got %s", previous)
+	}
+
+	// failed order is removed
+	if target.RemoveDeployment("x") {
+		t.Fatalf("Expected no change when removing an order never deployed")
+	}
+	if !target.RemoveDeployment("b") || len(target.Deployments) != 0 {
+		t.Fatalf("Unexpected deployments: %v", target.Deployments)
+	}
+
+	// history is limited
+	for i := 0; i < MaxDeployments+5; i++ {
+		target.AddDeployment(fmt.Sprint(i))
+	}
+	if len(target.Deployments) != MaxDeployments {
+		t.Fatalf("Expected %d deployments, got %d", MaxDeployments, len(target.Deployments))
+	}
+	if previous := target.RollbackDeployment(""); previous != fmt.Sprint(MaxDeployments+3) {
+		t.Fatalf("Unexpected previous deployment: %s", previous)
+	}
+}
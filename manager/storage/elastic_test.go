@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeElastic is an in-memory Elasticsearch serving the requests of the storage client
+//	Partial updates are deep-merged and queries support term, exists and bool clauses, as in Elasticsearch.
+type fakeElastic struct {
+	sync.Mutex
+	docs map[string]map[string]map[string]interface{} // index: id: source
+	ids  map[string][]string                          // index: ids in order of creation
+}
+
+func startFakeElastic(t *testing.T) (Storage, *fakeElastic, func()) {
+	f := &fakeElastic{
+		docs: make(map[string]map[string]map[string]interface{}),
+		ids:  make(map[string][]string),
+	}
+	server := httptest.NewServer(f)
+	s, err := StartElasticStorage(server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatalf("Error starting storage: %s", err)
+	}
+	return s, f, server.Close
+}
+
+// source returns the stored document
+func (f *fakeElastic) source(index, id string) map[string]interface{} {
+	f.Lock()
+	defer f.Unlock()
+	return f.docs[index][id]
+}
+
+func (f *fakeElastic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var body map[string]interface{}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	respond := func(code int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(v)
+	}
+	notFound := func() {
+		respond(http.StatusNotFound, map[string]interface{}{"found": false, "status": http.StatusNotFound,
+			"error": map[string]interface{}{"type": "document_missing_exception", "reason": "not found"}})
+	}
+
+	index := parts[0]
+	switch {
+	case index == "":
+		respond(http.StatusOK, map[string]interface{}{"version": map[string]interface{}{"number": "6.6.1"}})
+	case len(parts) == 1: // index exists or create index
+		respond(http.StatusOK, map[string]interface{}{"acknowledged": true})
+	case parts[1] == "_mapping":
+		respond(http.StatusOK, map[string]interface{}{"acknowledged": true})
+	case len(parts) == 3 && parts[2] == "_search":
+		hits := []interface{}{}
+		for _, id := range f.ids[index] {
+			doc, found := f.docs[index][id]
+			if found && matchQuery(doc, body["query"]) {
+				hits = append(hits, map[string]interface{}{"_index": index, "_type": parts[1], "_id": id, "_source": doc})
+			}
+		}
+		respond(http.StatusOK, map[string]interface{}{"hits": map[string]interface{}{"total": len(hits), "hits": hits}})
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		doc, found := f.docs[index][parts[2]]
+		if !found {
+			notFound()
+			return
+		}
+		respond(http.StatusOK, map[string]interface{}{"_index": index, "_type": parts[1], "_id": parts[2], "found": true, "_source": doc})
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		if _, found := f.docs[index][parts[2]]; !found {
+			notFound()
+			return
+		}
+		delete(f.docs[index], parts[2])
+		respond(http.StatusOK, map[string]interface{}{"_index": index, "_id": parts[2], "result": "deleted"})
+	case len(parts) == 3: // index document
+		id := parts[2]
+		if f.docs[index] == nil {
+			f.docs[index] = make(map[string]map[string]interface{})
+		}
+		_, exists := f.docs[index][id]
+		if exists && r.URL.Query().Get("op_type") == "create" {
+			respond(http.StatusConflict, map[string]interface{}{"status": http.StatusConflict,
+				"error": map[string]interface{}{"type": "version_conflict_engine_exception"}})
+			return
+		}
+		if !exists {
+			f.ids[index] = append(f.ids[index], id)
+		}
+		f.docs[index][id] = body
+		respond(http.StatusOK, map[string]interface{}{"_index": index, "_id": id, "result": "created"})
+	case len(parts) == 4 && parts[3] == "_update":
+		doc, found := f.docs[index][parts[2]]
+		if !found {
+			notFound()
+			return
+		}
+		partial, _ := body["doc"].(map[string]interface{})
+		mergeDoc(doc, partial)
+		respond(http.StatusOK, map[string]interface{}{"_index": index, "_id": parts[2], "result": "updated"})
+	default:
+		respond(http.StatusBadRequest, map[string]interface{}{"status": http.StatusBadRequest,
+			"error": map[string]interface{}{"type": "unsupported", "reason": r.Method + " " + r.URL.Path}})
+	}
+}
+
+// mergeDoc merges the partial document into doc: objects are merged recursively, other values are replaced
+func mergeDoc(doc, partial map[string]interface{}) {
+	for k, v := range partial {
+		if obj, ok := v.(map[string]interface{}); ok {
+			if existing, ok := doc[k].(map[string]interface{}); ok {
+				mergeDoc(existing, obj)
+				continue
+			}
+		}
+		doc[k] = v
+	}
+}
+
+// field returns the values of a dotted path, flattening arrays
+func field(doc map[string]interface{}, path string) []interface{} {
+	var v interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[key]
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+func matchQuery(doc map[string]interface{}, query interface{}) bool {
+	q, ok := query.(map[string]interface{})
+	if !ok || len(q) == 0 {
+		return true
+	}
+	for kind, clause := range q {
+		c, _ := clause.(map[string]interface{})
+		switch kind {
+		case "match_all":
+		case "term":
+			for path, value := range c {
+				if obj, ok := value.(map[string]interface{}); ok {
+					value = obj["value"]
+				}
+				var hit bool
+				for _, v := range field(doc, path) {
+					hit = hit || fmt.Sprint(v) == fmt.Sprint(value)
+				}
+				if !hit {
+					return false
+				}
+			}
+		case "exists":
+			if len(field(doc, fmt.Sprint(c["field"]))) == 0 {
+				return false
+			}
+		case "bool":
+			for _, must := range clauses(c["must"]) {
+				if !matchQuery(doc, must) {
+					return false
+				}
+			}
+			for _, filter := range clauses(c["filter"]) {
+				if !matchQuery(doc, filter) {
+					return false
+				}
+			}
+			for _, mustNot := range clauses(c["must_not"]) {
+				if matchQuery(doc, mustNot) {
+					return false
+				}
+			}
+			should := clauses(c["should"])
+			var hit bool
+			for _, s := range should {
+				hit = hit || matchQuery(doc, s)
+			}
+			if len(should) > 0 && !hit {
+				return false
+			}
+		default:
+			panic("unsupported query: " + kind)
+		}
+	}
+	return true
+}
+
+func clauses(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestOrderBuildMatrixValidation(t *testing.T) {
+	cases := map[string]bool{
+		"host: builder": true,
+		"matrix: [{arch: amd64, host: b1}, {arch: arm, host: b2}]": true,
+		"host: builder\n  matrix: [{arch: amd64, host: b1}]":       false,
+		"matrix: [{arch: amd64}]":                                  false,
+		"matrix: [{host: b1}]":                                     false,
+		"matrix: [{arch: arm, host: b1}, {arch: arm, host: b2}]":   false,
+		"matrix: [{arch: amd64, host: b1}, {arch: arm, host: b1}]": false,
+	}
+	for build, valid := range cases {
+		var order Order
+		err := yaml.Unmarshal([]byte("build:\n  commands: [make]\n  artifacts: [app]\n  "+build+"\n"), &order)
+		if err != nil {
+			t.Fatalf("Error parsing order: %s", err)
+		}
+		err = order.Validate()
+		if valid && err != nil {
+			t.Errorf("Unexpected validation error for %s: %s", build, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("Expected validation error for %s", build)
+		}
+	}
+}
+
+func TestOrderBuildMatrixHosts(t *testing.T) {
+	var order Order
+	err := yaml.Unmarshal([]byte("build:\n  matrix: [{arch: amd64, host: b1}, {arch: arm, host: b2}]\n"), &order)
+	if err != nil {
+		t.Fatalf("Error parsing order: %s", err)
+	}
+	if hosts := order.Build.Hosts(); !reflect.DeepEqual(hosts, []string{"b1", "b2"}) {
+		t.Fatalf("Unexpected hosts: %v", hosts)
+	}
+	if archs := order.Build.Archs(); !reflect.DeepEqual(archs, []string{"amd64", "arm"}) {
+		t.Fatalf("Unexpected archs: %v", archs)
+	}
+	if arch := order.Build.ArchOf("b2"); arch != "arm" {
+		t.Fatalf("Unexpected arch of b2: %s", arch)
+	}
+	if arch := order.Build.ArchOf("b3"); arch != "" {
+		t.Fatalf("Unexpected arch of b3: %s", arch)
+	}
+
+	order = Order{}
+	err = yaml.Unmarshal([]byte("build:\n  host: builder\n"), &order)
+	if err != nil {
+		t.Fatalf("Error parsing order: %s", err)
+	}
+	if hosts := order.Build.Hosts(); !reflect.DeepEqual(hosts, []string{"builder"}) {
+		t.Fatalf("Unexpected hosts: %v", hosts)
+	}
+}
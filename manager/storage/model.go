@@ -2,7 +2,11 @@ package storage
 
 import (
 	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"code.linksmart.eu/dt/deployment-tool/manager/model"
 	"code.linksmart.eu/dt/deployment-tool/manager/source"
@@ -16,13 +20,58 @@ type Order struct {
 	Description  string             `json:"description,omitempty"`
 	Created      model.UnixTimeType `json:"createdAt"`
 	Source       *source.Source     `json:"source,omitempty"`
+	Commit       string             `json:"commit,omitempty" yaml:"-"` // resolved commit of the git source
 	Build        *build             `json:"build"`
 	Deploy       *deploy            `json:"deploy"`
+	Template     *model.Template    `json:"template,omitempty"`
+	Secrets      []model.SecretRef  `json:"secrets,omitempty"`
+	Archive      string             `json:"archive,omitempty"` // format of task artifacts and packages, zip by default
+	Pull         bool               `json:"pull,omitempty"`    // targets download artifacts from the manager instead of receiving them with the task
+	Schedule     *Schedule          `json:"schedule,omitempty"`
+	Status       *OrderStatus       `json:"status,omitempty"`
 }
 
 type build struct {
 	model.Build `yaml:",inline"`
-	Host        string `json:"host"`
+	Host        string      `json:"host"`
+	Matrix      []BuildArch `json:"matrix,omitempty"` // build hosts per architecture, instead of a single host
+}
+
+// BuildArch is the host that builds the package for targets of an architecture, as named by GOARCH
+type BuildArch struct {
+	Arch string `json:"arch"`
+	Host string `json:"host"`
+}
+
+// Hosts returns the build hosts
+func (b *build) Hosts() []string {
+	if len(b.Matrix) == 0 {
+		return []string{b.Host}
+	}
+	hosts := make([]string, len(b.Matrix))
+	for i := range b.Matrix {
+		hosts[i] = b.Matrix[i].Host
+	}
+	return hosts
+}
+
+// ArchOf returns the architecture built by the host, which is empty without build matrix
+func (b *build) ArchOf(host string) string {
+	for _, ba := range b.Matrix {
+		if ba.Host == host {
+			return ba.Arch
+		}
+	}
+	return ""
+}
+
+// Archs returns the architectures of the build matrix
+func (b *build) Archs() []string {
+	archs := make([]string, len(b.Matrix))
+	for i := range b.Matrix {
+		archs[i] = b.Matrix[i].Arch
+	}
+	return archs
 }
 
 type deploy struct {
@@ -31,7 +80,8 @@ type deploy struct {
 		IDs  []string `json:"ids"`
 		Tags []string `json:"tags"`
 	} `json:"target"`
-	Match Match `json:"match"`
+	Match   Match    `json:"match"`
+	Rollout *Rollout `json:"rollout,omitempty"`
 }
 
 type Match struct {
@@ -45,9 +95,28 @@ func (o Order) Validate() error {
 		return fmt.Errorf("neither build nor deploy are defined")
 	}
 
+	err := model.ValidateArchive(o.Archive)
+	if err != nil {
+		return fmt.Errorf("archive: %s", err)
+	}
+
+	// validate source
+	if o.Source != nil && o.Source.Git != nil {
+		err := o.Source.Git.Validate()
+		if err != nil {
+			return fmt.Errorf("source.git: %s", err)
+		}
+	}
+	if o.Source != nil && o.Source.URL != nil {
+		err := o.Source.URL.Validate()
+		if err != nil {
+			return fmt.Errorf("source.url: %s", err)
+		}
+	}
+
 	// validate build
-	if o.Build != nil && len(o.Build.Commands)+len(o.Build.Artifacts)+len(o.Build.Host) > 0 {
-		if o.Source.Order != nil {
+	if o.Build != nil && len(o.Build.Commands)+len(o.Build.Artifacts)+len(o.Build.Host)+len(o.Build.Matrix) > 0 {
+		if o.Source != nil && o.Source.Order != nil {
 			return fmt.Errorf("source.order is set to take artifacts from a previous order.build. build should be omitted")
 		}
 		if len(o.Build.Commands) == 0 {
@@ -56,8 +125,26 @@ func (o Order) Validate() error {
 		if len(o.Build.Artifacts) == 0 {
 			return fmt.Errorf("build.artifacts empty")
 		}
-		if o.Build.Host == "" {
-			return fmt.Errorf("build.host not given")
+		if o.Build.Host == "" && len(o.Build.Matrix) == 0 {
+			return fmt.Errorf("neither build.host nor build.matrix are given")
+		}
+		if o.Build.Host != "" && len(o.Build.Matrix) > 0 {
+			return fmt.Errorf("build.host cannot be combined with build.matrix")
+		}
+		archs := make(map[string]bool)
+		hosts := make(map[string]bool)
+		for _, ba := range o.Build.Matrix {
+			if ba.Arch == "" || ba.Host == "" {
+				return fmt.Errorf("build.matrix requires both arch and host")
+			}
+			if archs[ba.Arch] {
+				return fmt.Errorf("build.matrix has duplicate arch: %s", ba.Arch)
+			}
+			if hosts[ba.Host] {
+				return fmt.Errorf("build.matrix has duplicate host: %s", ba.Host)
+			}
+			archs[ba.Arch] = true
+			hosts[ba.Host] = true
 		}
 
 		for _, path := range o.Build.Artifacts {
@@ -67,19 +154,489 @@ func (o Order) Validate() error {
 		}
 	}
 
+	// validate template
+	if o.Template != nil {
+		for _, path := range o.Template.Files {
+			if !withinSource(path) {
+				return fmt.Errorf("path in template.files should be relative to source. Given path is invalid: %s", path)
+			}
+		}
+	}
+
+	// validate secrets
+	for _, secret := range o.Secrets {
+		if secret.Name == "" {
+			return fmt.Errorf("secrets.name not given")
+		}
+		if secret.Env == "" && secret.File == "" {
+			return fmt.Errorf("neither secrets.env nor secrets.file are given for %s", secret.Name)
+		}
+		if secret.Env != "" && strings.ContainsAny(secret.Env, "= ") {
+			return fmt.Errorf("secrets.env has invalid value: %s", secret.Env)
+		}
+		if secret.File != "" && !withinSource(secret.File) {
+			return fmt.Errorf("path in secrets.file should be relative to source. Given path is invalid: %s", secret.File)
+		}
+	}
+
+	// validate schedule
+	if o.Schedule != nil {
+		err := o.Schedule.validate()
+		if err != nil {
+			return fmt.Errorf("schedule: %s", err)
+		}
+		if len(o.Schedule.Windows) > 0 && o.Deploy != nil && o.Deploy.Rollout != nil {
+			return fmt.Errorf("schedule.windows cannot be combined with deploy.rollout")
+		}
+	}
+
 	// validate deploy
-	if o.Deploy != nil && len(o.Deploy.Install.Commands)+len(o.Deploy.Run.Commands)+len(o.Deploy.Target.IDs)+len(o.Deploy.Target.Tags) > 0 {
+	if o.Deploy != nil && (len(o.Deploy.Install.Commands)+len(o.Deploy.Run.Commands)+len(o.Deploy.Target.IDs)+len(o.Deploy.Target.Tags) > 0 || o.Deploy.Container != nil) {
 		if len(o.Deploy.Target.IDs)+len(o.Deploy.Target.Tags) == 0 {
 			return fmt.Errorf("both deploy.target.ids and deploy.target.tags are empty")
 		}
-		if len(o.Deploy.Install.Commands)+len(o.Deploy.Run.Commands) == 0 {
-			return fmt.Errorf("both deploy.install.commands and deploy.run.commands are empty")
+		if len(o.Deploy.Install.Commands)+len(o.Deploy.Run.Commands) == 0 && o.Deploy.Container == nil {
+			return fmt.Errorf("deploy.install.commands, deploy.run.commands and deploy.container are empty")
+		}
+		if o.Deploy.Rollout != nil {
+			err := o.Deploy.Rollout.validate()
+			if err != nil {
+				return fmt.Errorf("deploy.rollout: %s", err)
+			}
+		}
+		if o.Deploy.Container != nil {
+			if o.Deploy.Container.Image == "" {
+				return fmt.Errorf("deploy.container.image not given")
+			}
+			switch o.Deploy.Container.Restart {
+			case "", "no", "always", "on-failure", "unless-stopped":
+			default:
+				return fmt.Errorf("deploy.container.restart has invalid value: %s", o.Deploy.Container.Restart)
+			}
 		}
 	}
 
 	return nil
 }
 
+// withinSource tells if the path refers to a file under the source directory
+func withinSource(path string) bool {
+	clean := filepath.Clean(path)
+	return path != "" && !filepath.IsAbs(clean) && clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+//
+// ROLLOUT
+//
+const (
+	DefaultRolloutTimeout = 10 * time.Minute
+)
+
+// Rollout deploys to targets in batches, starting with a canary batch.
+//	Each batch should succeed or start running and remain so during the soak period, before the next batch.
+//	Sizes are given as number of targets (e.g. 2) or percentage of matched targets (e.g. 10%).
+type Rollout struct {
+	Canary     string  `json:"canary,omitempty" yaml:"canary"`   // size of the first batch
+	Batch      string  `json:"batch,omitempty" yaml:"batch"`     // size of other batches, all remaining targets if not set
+	Soak       string  `json:"soak,omitempty" yaml:"soak"`       // wait after each batch e.g. 5m
+	Timeout    string  `json:"timeout,omitempty" yaml:"timeout"` // max wait for targets of a batch to succeed or start running
+	MaxFailure float64 `json:"maxFailure" yaml:"maxFailure"`     // percentage of failed targets in a batch that halts the rollout
+}
+
+func (r *Rollout) validate() error {
+	for field, size := range map[string]string{"canary": r.Canary, "batch": r.Batch} {
+		if size == "" {
+			continue
+		}
+		_, err := batchSize(size, 100)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", field, err)
+		}
+	}
+	for field, duration := range map[string]string{"soak": r.Soak, "timeout": r.Timeout} {
+		if duration == "" {
+			continue
+		}
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", field, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("invalid %s: negative duration", field)
+		}
+	}
+	if r.MaxFailure < 0 || r.MaxFailure > 100 {
+		return fmt.Errorf("maxFailure should be a percentage between 0 and 100")
+	}
+	return nil
+}
+
+// Batches splits the targets into batches
+func (r *Rollout) Batches(targets []string) [][]string {
+	var batches [][]string
+	total := len(targets)
+	size := r.Canary
+	if size == "" {
+		size = r.Batch
+	}
+	for len(targets) > 0 {
+		n := len(targets)
+		if size != "" {
+			n, _ = batchSize(size, total)
+		}
+		if n > len(targets) {
+			n = len(targets)
+		}
+		batches = append(batches, targets[:n])
+		targets = targets[n:]
+		size = r.Batch
+	}
+	return batches
+}
+
+// SoakDuration returns the wait after each batch
+func (r *Rollout) SoakDuration() time.Duration {
+	d, _ := time.ParseDuration(r.Soak)
+	return d
+}
+
+// TimeoutDuration returns the max wait for targets of a batch
+func (r *Rollout) TimeoutDuration() time.Duration {
+	d, err := time.ParseDuration(r.Timeout)
+	if err != nil || d == 0 {
+		return DefaultRolloutTimeout
+	}
+	return d
+}
+
+// batchSize returns the number of targets for a size given as a number or percentage of total
+func batchSize(size string, total int) (int, error) {
+	if strings.HasSuffix(size, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(size, "%"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, fmt.Errorf("percentage should be between 0 and 100: %s", size)
+		}
+		n := int(math.Ceil(p * float64(total) / 100))
+		if n < 1 {
+			n = 1
+		}
+		return n, nil
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("should be a positive number or percentage: %s", size)
+	}
+	return n, nil
+}
+
+//
+// SCHEDULE
+//
+
+// Schedule holds the order until the start time and deploys to targets only within their maintenance windows
+//	Targets not covered by any window receive the deployment at the start time.
+type Schedule struct {
+	Start   string   `json:"start,omitempty" yaml:"start"` // RFC3339 e.g. 2019-01-01T02:00:00Z
+	Windows []Window `json:"windows,omitempty" yaml:"windows"`
+	// set by the manager
+	Started bool     `json:"started" yaml:"-"`           // order has been dispatched
+	Pending []string `json:"pending" yaml:"-"`           // targets waiting for a window. Not omitted, to clear the stored list in partial updates
+	Stopped bool     `json:"stopped,omitempty" yaml:"-"` // order has been stopped, nothing is dispatched anymore
+}
+
+// Window is a recurring maintenance window for targets with given ids or tags, or all targets if none is given
+type Window struct {
+	IDs      []string `json:"ids,omitempty" yaml:"ids"`
+	Tags     []string `json:"tags,omitempty" yaml:"tags"`
+	Days     []string `json:"days,omitempty" yaml:"days"`         // mon, tue, wed, thu, fri, sat, sun. Every day if empty
+	Start    string   `json:"start" yaml:"start"`                 // HH:MM
+	End      string   `json:"end" yaml:"end"`                     // HH:MM, before start for windows spanning midnight
+	Timezone string   `json:"timezone,omitempty" yaml:"timezone"` // IANA time zone e.g. Europe/Berlin. UTC if empty
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (s *Schedule) validate() error {
+	if s.Start != "" {
+		_, err := time.Parse(time.RFC3339, s.Start)
+		if err != nil {
+			return fmt.Errorf("invalid start: %s", err)
+		}
+	}
+	for i, w := range s.Windows {
+		err := w.validate()
+		if err != nil {
+			return fmt.Errorf("windows[%d]: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// Due returns true if the start time has been reached
+func (s *Schedule) Due(now time.Time) bool {
+	start, err := time.Parse(time.RFC3339, s.Start)
+	return err != nil || !now.Before(start)
+}
+
+// Open returns true if the target can be deployed to at the given time, i.e. it is in an open window or not covered by any
+func (s *Schedule) Open(target *Target, now time.Time) bool {
+	covered := false
+	for i := range s.Windows {
+		if !s.Windows[i].covers(target) {
+			continue
+		}
+		if s.Windows[i].open(now) {
+			return true
+		}
+		covered = true
+	}
+	return !covered
+}
+
+func (w *Window) validate() error {
+	start, err := minuteOfDay(w.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %s", err)
+	}
+	end, err := minuteOfDay(w.End)
+	if err != nil {
+		return fmt.Errorf("invalid end: %s", err)
+	}
+	if start == end {
+		return fmt.Errorf("start and end are the same")
+	}
+	for _, day := range w.Days {
+		if _, found := weekdays[day]; !found {
+			return fmt.Errorf("invalid day: %s", day)
+		}
+	}
+	_, err = time.LoadLocation(w.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone: %s", err)
+	}
+	return nil
+}
+
+func (w *Window) covers(target *Target) bool {
+	if len(w.IDs)+len(w.Tags) == 0 {
+		return true
+	}
+	for _, id := range w.IDs {
+		if id == target.ID {
+			return true
+		}
+	}
+	for _, tag := range w.Tags {
+		for _, targetTag := range target.Tags {
+			if tag == targetTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *Window) open(now time.Time) bool {
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return false
+	}
+	now = now.In(loc)
+	start, _ := minuteOfDay(w.Start)
+	end, _ := minuteOfDay(w.End)
+	minute := now.Hour()*60 + now.Minute()
+
+	if start < end {
+		return minute >= start && minute < end && w.onDay(now.Weekday())
+	}
+	// spanning midnight
+	if minute >= start {
+		return w.onDay(now.Weekday())
+	}
+	if minute < end { // opened the day before
+		return w.onDay((now.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (w *Window) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[d] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// minuteOfDay parses HH:MM
+func minuteOfDay(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %s", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+//
+// ORDER STATUS
+//
+const (
+	// states of targets in progress
+	StateQueued     = "queued"
+	StateSent       = "sent"
+	StateReceived   = "received"
+	StateInstalling = "installing"
+	StateRunning    = "running"
+	// final states
+	StateSucceeded     = "succeeded"
+	StateFailed        = "failed"
+	StateUndeliverable = "undeliverable"
+	StateCancelled     = "cancelled" // not sent because the rollout or pipeline was halted
+)
+
+// stateRank is the order of states for targets in progress
+var stateRank = map[string]int{
+	StateQueued:     1,
+	StateSent:       2,
+	StateReceived:   3,
+	StateInstalling: 4,
+	StateRunning:    5,
+}
+
+type OrderStatus struct {
+	State     string             `json:"state"` // aggregated state of all targets
+	UpdatedAt model.UnixTimeType `json:"updatedAt"`
+	Targets   []TargetStatus     `json:"targets"`
+	Rollout   *RolloutStatus     `json:"rollout,omitempty"`
+}
+
+type RolloutStatus struct {
+	Batch   int    `json:"batch"` // current batch, starting from 1
+	Batches int    `json:"batches"`
+	Halted  bool   `json:"halted,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type TargetStatus struct {
+	ID        string             `json:"id"`
+	State     string             `json:"state"`
+	UpdatedAt model.UnixTimeType `json:"updatedAt"`
+}
+
+// NewOrderStatus returns a status with all targets queued
+func NewOrderStatus(ids []string) *OrderStatus {
+	s := &OrderStatus{
+		UpdatedAt: model.UnixTime(),
+		Targets:   make([]TargetStatus, len(ids)),
+	}
+	for i := range ids {
+		s.Targets[i] = TargetStatus{ids[i], StateQueued, s.UpdatedAt}
+	}
+	s.aggregate()
+	return s
+}
+
+// Set changes the state of the target if the transition is allowed and returns true if the state has changed
+//	States in progress only move forward and final states are kept, except undeliverable which is left
+//	when the target reports progress. Targets not in the list are added.
+func (s *OrderStatus) Set(id, state string) (changed bool) {
+	i := s.index(id)
+	current := s.Targets[i].State
+	switch {
+	case current == state:
+		return false
+	case current == StateSucceeded || current == StateFailed || current == StateCancelled:
+		return false
+	case current == StateUndeliverable && stateRank[state] < stateRank[StateReceived]:
+		return false
+	case stateRank[state] > 0 && stateRank[state] < stateRank[current]:
+		return false
+	}
+	s.set(i, state)
+	return true
+}
+
+// Retriable returns the targets which have failed or to which the task was not delivered
+func (s *OrderStatus) Retriable() []string {
+	var ids []string
+	for _, t := range s.Targets {
+		if t.State == StateFailed || t.State == StateUndeliverable {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
+
+// Reset sets the state of the target regardless of the current state e.g. when a new task of the order is sent
+func (s *OrderStatus) Reset(id, state string) (changed bool) {
+	i := s.index(id)
+	if s.Targets[i].State == state {
+		return false
+	}
+	s.set(i, state)
+	return true
+}
+
+func (s *OrderStatus) set(i int, state string) {
+	s.UpdatedAt = model.UnixTime()
+	s.Targets[i].State = state
+	s.Targets[i].UpdatedAt = s.UpdatedAt
+	s.aggregate()
+}
+
+// index returns the index of the target in the list, adding a queued target if missing
+func (s *OrderStatus) index(id string) int {
+	for i := range s.Targets {
+		if s.Targets[i].ID == id {
+			return i
+		}
+	}
+	s.Targets = append(s.Targets, TargetStatus{id, StateQueued, model.UnixTime()})
+	return len(s.Targets) - 1
+}
+
+// aggregate sets the state of the order:
+//	the earliest state of targets in progress, otherwise succeeded if all have succeeded,
+//	undeliverable if none were delivered, or failed.
+func (s *OrderStatus) aggregate() {
+	var earliest string
+	var succeeded, undeliverable int
+	for _, t := range s.Targets {
+		switch t.State {
+		case StateSucceeded:
+			succeeded++
+		case StateUndeliverable:
+			undeliverable++
+		case StateFailed:
+		default:
+			if earliest == "" || stateRank[t.State] < stateRank[earliest] {
+				earliest = t.State
+			}
+		}
+	}
+	switch {
+	case earliest != "":
+		s.State = earliest
+	case succeeded == len(s.Targets):
+		s.State = StateSucceeded
+	case undeliverable == len(s.Targets):
+		s.State = StateUndeliverable
+	default:
+		s.State = StateFailed
+	}
+}
+
 //
 // TARGET
 //
@@ -88,6 +645,84 @@ type Target struct {
 	CreatedAt    model.UnixTimeType `json:"createdAt,omitempty"`
 	UpdatedAt    model.UnixTimeType `json:"updatedAt,omitempty"`
 	LogRequestAt model.UnixTimeType `json:"logRequestAt,omitempty"`
+	// ConfigPending is true when tags or location are changed by the manager but not yet applied by the target
+	ConfigPending bool `json:"configPending,omitempty"`
+	// Deployments are the orders successfully deployed on the target, the current one being the last
+	Deployments []string `json:"deployments,omitempty"`
+}
+
+const MaxDeployments = 10
+
+// AddDeployment appends the order to deployments and returns true if the current deployment has changed
+func (t *Target) AddDeployment(orderID string) bool {
+	if len(t.Deployments) > 0 && t.Deployments[len(t.Deployments)-1] == orderID {
+		return false
+	}
+	var deployments []string
+	for _, id := range t.Deployments {
+		if id != orderID {
+			deployments = append(deployments, id)
+		}
+	}
+	deployments = append(deployments, orderID)
+	if len(deployments) > MaxDeployments {
+		deployments = deployments[len(deployments)-MaxDeployments:]
+	}
+	t.Deployments = deployments
+	return true
+}
+
+// RemoveDeployment removes the order from deployments and returns true if it was recorded
+//	The order deployed before it becomes the current one, if it was the last.
+func (t *Target) RemoveDeployment(orderID string) bool {
+	var deployments []string
+	for _, id := range t.Deployments {
+		if id != orderID {
+			deployments = append(deployments, id)
+		}
+	}
+	if len(deployments) == len(t.Deployments) {
+		return false
+	}
+	t.Deployments = deployments
+	return true
+}
+
+// RollbackDeployment removes the given order, or the current one if not given, and the ones after it from deployments
+//	Returns the order deployed before it, or empty string if there is none or the order was never deployed.
+func (t *Target) RollbackDeployment(orderID string) string {
+	if len(t.Deployments) == 0 {
+		return ""
+	}
+	if orderID == "" {
+		orderID = t.Deployments[len(t.Deployments)-1]
+	}
+	for i := range t.Deployments {
+		if t.Deployments[i] == orderID {
+			if i == 0 {
+				return ""
+			}
+			t.Deployments = t.Deployments[:i]
+			return t.Deployments[i-1]
+		}
+	}
+	return ""
+}
+
+// SameConfig returns true if both targets have the same tags and location
+func (t *Target) SameConfig(other *Target) bool {
+	if len(t.Tags) != len(other.Tags) {
+		return false
+	}
+	for i := range t.Tags {
+		if t.Tags[i] != other.Tags[i] {
+			return false
+		}
+	}
+	if t.Location == nil || other.Location == nil {
+		return t.Location == other.Location
+	}
+	return *t.Location == *other.Location
 }
 
 //
@@ -109,3 +744,170 @@ type TokenHashed struct {
 	TokenMeta
 	Hash string `json:"hash"`
 }
+
+//
+// SECRET
+//
+type SecretMeta struct {
+	Name      string             `json:"name"`
+	CreatedAt model.UnixTimeType `json:"createdAt"`
+	UpdatedAt model.UnixTimeType `json:"updatedAt"`
+}
+
+// Secret is stored with the value encrypted to the key of the manager
+type Secret struct {
+	SecretMeta
+	Sealed []byte `json:"sealed"`
+}
+
+//
+// PIPELINE
+//
+
+// Pipeline runs orders as steps, each starting when the steps it depends on have succeeded
+//	A step succeeds when all of its targets have succeeded or started running.
+//	The pipeline stops on the first failed step and the remaining steps are cancelled.
+type Pipeline struct {
+	ID          string             `json:"id"`
+	Description string             `json:"description,omitempty"`
+	Created     model.UnixTimeType `json:"createdAt"`
+	Steps       []Step             `json:"steps"`
+	State       string             `json:"state"`
+	UpdatedAt   model.UnixTimeType `json:"updatedAt"`
+}
+
+type Step struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"dependsOn,omitempty" yaml:"dependsOn"` // names of previous steps. Default: the previous step
+	Order     *Order   `json:"order"`
+	// status
+	OrderID string `json:"orderID,omitempty" yaml:"-"`
+	State   string `json:"state" yaml:"-"`
+	Message string `json:"message,omitempty" yaml:"-"`
+}
+
+func (p *Pipeline) Validate() error {
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("steps empty")
+	}
+	names := make(map[string]bool)
+	for i, step := range p.Steps {
+		if step.Name == "" {
+			return fmt.Errorf("steps[%d].name not given", i)
+		}
+		if names[step.Name] {
+			return fmt.Errorf("duplicate step name: %s", step.Name)
+		}
+		for _, dep := range step.DependsOn {
+			if !names[dep] {
+				return fmt.Errorf("step %s depends on %s which is not a previous step", step.Name, dep)
+			}
+		}
+		names[step.Name] = true
+		if step.Order == nil {
+			return fmt.Errorf("step %s: order not given", step.Name)
+		}
+		err := step.Order.Validate()
+		if err != nil {
+			return fmt.Errorf("step %s: %s", step.Name, err)
+		}
+	}
+	return nil
+}
+
+// Init sets the default dependencies and queues the steps
+func (p *Pipeline) Init() {
+	for i := range p.Steps {
+		if p.Steps[i].DependsOn == nil && i > 0 {
+			p.Steps[i].DependsOn = []string{p.Steps[i-1].Name}
+		}
+		p.Steps[i].OrderID = ""
+		p.Steps[i].State = StateQueued
+		p.Steps[i].Message = ""
+	}
+	p.State = StateRunning
+	p.UpdatedAt = model.UnixTime()
+}
+
+// Ready returns the indices of queued steps whose dependencies have succeeded
+func (p *Pipeline) Ready() []int {
+	states := make(map[string]string)
+	for _, step := range p.Steps {
+		states[step.Name] = step.State
+	}
+	var ready []int
+	for i, step := range p.Steps {
+		if step.State != StateQueued {
+			continue
+		}
+		ok := true
+		for _, dep := range step.DependsOn {
+			if states[dep] != StateSucceeded {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			ready = append(ready, i)
+		}
+	}
+	return ready
+}
+
+// Update sets the state of the pipeline from the state of steps, cancelling queued steps after a failure
+//	Returns true if the state has changed.
+func (p *Pipeline) Update() (changed bool) {
+	if p.State != StateRunning {
+		return false
+	}
+	failed, done := false, true
+	for _, step := range p.Steps {
+		switch step.State {
+		case StateFailed:
+			failed = true
+		case StateSucceeded:
+		default:
+			done = false
+		}
+	}
+	switch {
+	case failed:
+		p.Cancel("stopped after a failed step")
+		p.State = StateFailed
+	case done:
+		p.State = StateSucceeded
+	default:
+		return false
+	}
+	p.UpdatedAt = model.UnixTime()
+	return true
+}
+
+// Cancel cancels the queued steps
+func (p *Pipeline) Cancel(message string) {
+	for i := range p.Steps {
+		if p.Steps[i].State == StateQueued {
+			p.Steps[i].State = StateCancelled
+			p.Steps[i].Message = message
+		}
+	}
+}
+
+// StepState returns the state of a step given the status of its order:
+//	failed if any target has failed, succeeded if all have succeeded or started running, otherwise running
+func StepState(status *OrderStatus) string {
+	done := true
+	for _, t := range status.Targets {
+		switch t.State {
+		case StateFailed, StateUndeliverable, StateCancelled:
+			return StateFailed
+		case StateSucceeded, StateRunning:
+		default:
+			done = false
+		}
+	}
+	if done && len(status.Targets) > 0 {
+		return StateSucceeded
+	}
+	return StateRunning
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestPipeline(t *testing.T) {
+	var pipeline Pipeline
+	err := yaml.Unmarshal([]byte(`
+steps:
+  - name: migrate
+    order:
+      deploy:
+        install:
+          commands: [./migrate]
+        target:
+          tags: [gateway]
+  - name: app
+    order:
+      deploy:
+        run:
+          commands: [./app]
+        target:
+          tags: [sensor]
+  - name: verify
+    dependsOn: [migrate, app]
+    order:
+      deploy:
+        run:
+          commands: [./verify]
+        target:
+          tags: [gateway]
+`), &pipeline)
+	if err != nil {
+		t.Fatalf("Error parsing pipeline: %s", err)
+	}
+	err = pipeline.Validate()
+	if err != nil {
+		t.Fatalf("Unexpected validation error: %s", err)
+	}
+	pipeline.Init()
+	if !reflect.DeepEqual(pipeline.Steps[1].DependsOn, []string{"migrate"}) {
+		t.Fatalf("Expected default dependency on previous step, got %v", pipeline.Steps[1].DependsOn)
+	}
+
+	if ready := pipeline.Ready(); !reflect.DeepEqual(ready, []int{0}) {
+		t.Fatalf("Expected first step to be ready, got %v", ready)
+	}
+	pipeline.Steps[0].State = StateSucceeded
+	if ready := pipeline.Ready(); !reflect.DeepEqual(ready, []int{1}) {
+		t.Fatalf("Expected second step to be ready, got %v", ready)
+	}
+	if pipeline.Update() {
+		t.Fatalf("Unexpected change of pipeline state")
+	}
+
+	// stop on failure
+	pipeline.Steps[1].State = StateFailed
+	if !pipeline.Update() || pipeline.State != StateFailed {
+		t.Fatalf("Expected pipeline to fail, got %s", pipeline.State)
+	}
+	if pipeline.Steps[2].State != StateCancelled {
+		t.Fatalf("Expected remaining step to be cancelled, got %s", pipeline.Steps[2].State)
+	}
+	if len(pipeline.Ready()) != 0 {
+		t.Fatalf("Expected no ready steps after failure")
+	}
+}
+
+func TestPipelineValidation(t *testing.T) {
+	cases := map[string]string{
+		"duplicate name":   "steps:\n  - {name: a, order: {deploy: {run: {commands: [x]}, target: {tags: [t]}}}}\n  - {name: a, order: {deploy: {run: {commands: [x]}, target: {tags: [t]}}}}",
+		"later dependency": "steps:\n  - {name: a, dependsOn: [b], order: {deploy: {run: {commands: [x]}, target: {tags: [t]}}}}\n  - {name: b, order: {deploy: {run: {commands: [x]}, target: {tags: [t]}}}}",
+		"missing order":    "steps:\n  - {name: a}",
+		"invalid order":    "steps:\n  - {name: a, order: {deploy: {run: {commands: [x]}}}}",
+		"no steps":         "description: empty",
+	}
+	for name, doc := range cases {
+		var pipeline Pipeline
+		err := yaml.Unmarshal([]byte(doc), &pipeline)
+		if err != nil {
+			t.Fatalf("%s: error parsing pipeline: %s", name, err)
+		}
+		if pipeline.Validate() == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestPipelineStepState(t *testing.T) {
+	status := NewOrderStatus([]string{"a", "b"})
+	if state := StepState(status); state != StateRunning {
+		t.Fatalf("Expected %s, got %s", StateRunning, state)
+	}
+	status.Set("a", StateRunning)
+	status.Set("b", StateSucceeded)
+	if state := StepState(status); state != StateSucceeded {
+		t.Fatalf("Expected %s, got %s", StateSucceeded, state)
+	}
+	status.Reset("a", StateFailed)
+	if state := StepState(status); state != StateFailed {
+		t.Fatalf("Expected %s, got %s", StateFailed, state)
+	}
+}
+
+// TestPipelineStorage follows the runner: the state of steps must persist between the runs
+func TestPipelineStorage(t *testing.T) {
+	s, _, stop := startFakeElastic(t)
+	defer stop()
+
+	order := &Order{}
+	pipeline := Pipeline{ID: "p", Steps: []Step{{Name: "migrate", Order: order}, {Name: "app", Order: order}}}
+	pipeline.Init()
+	_, err := s.AddPipeline(&pipeline)
+	if err != nil {
+		t.Fatalf("Error adding pipeline: %s", err)
+	}
+
+	// first run starts the migration
+	active, err := s.GetActivePipelines()
+	if err != nil || len(active) != 1 {
+		t.Fatalf("Expected 1 active pipeline, got %d: %v", len(active), err)
+	}
+	p := active[0]
+	if ready := p.Ready(); !reflect.DeepEqual(ready, []int{0}) {
+		t.Fatalf("Expected first step to be ready, got %v", ready)
+	}
+	p.Steps[0].OrderID = "migrate-order"
+	p.Steps[0].State = StateRunning
+	found, err := s.UpdatePipeline(&p)
+	if err != nil || !found {
+		t.Fatalf("Error updating pipeline: found=%v %v", found, err)
+	}
+
+	// next run must not start the migration again
+	active, err = s.GetActivePipelines()
+	if err != nil || len(active) != 1 {
+		t.Fatalf("Expected 1 active pipeline, got %d: %v", len(active), err)
+	}
+	p = active[0]
+	if p.Steps[0].State != StateRunning || p.Steps[0].OrderID != "migrate-order" {
+		t.Fatalf("Step state was not stored: %+v", p.Steps[0])
+	}
+	if ready := p.Ready(); len(ready) != 0 {
+		t.Fatalf("Expected no ready steps, got %v", ready)
+	}
+
+	// stopped pipelines are no longer run
+	p.Cancel("pipeline was stopped")
+	p.Steps[0].State = StateCancelled
+	p.State = StateCancelled
+	_, err = s.UpdatePipeline(&p)
+	if err != nil {
+		t.Fatalf("Error updating pipeline: %s", err)
+	}
+	active, err = s.GetActivePipelines()
+	if err != nil || len(active) != 0 {
+		t.Fatalf("Expected no active pipelines, got %d: %v", len(active), err)
+	}
+	stored, err := s.GetPipeline("p")
+	if err != nil || stored == nil {
+		t.Fatalf("Error getting pipeline: %v", err)
+	}
+	if stored.State != StateCancelled || stored.Steps[1].State != StateCancelled {
+		t.Fatalf("Cancellation was not stored: %s %+v", stored.State, stored.Steps)
+	}
+
+	found, err = s.UpdatePipeline(&Pipeline{ID: "missing"})
+	if err != nil || found {
+		t.Fatalf("Expected missing pipeline not to be found: %v", err)
+	}
+}
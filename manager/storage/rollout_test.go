@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRolloutBatches(t *testing.T) {
+	var order Order
+	err := yaml.Unmarshal([]byte(`
+deploy:
+  run:
+    commands:
+      - ./app
+  target:
+    tags:
+      - swarm
+  rollout:
+    canary: 1
+    batch: 40%
+    soak: 1m
+    maxFailure: 10
+`), &order)
+	if err != nil {
+		t.Fatalf("Error parsing order: %s", err)
+	}
+	err = order.Validate()
+	if err != nil {
+		t.Fatalf("Unexpected validation error: %s", err)
+	}
+
+	rollout := order.Deploy.Rollout
+	if rollout.SoakDuration() != time.Minute || rollout.TimeoutDuration() != DefaultRolloutTimeout {
+		t.Fatalf("Unexpected durations: %s %s", rollout.SoakDuration(), rollout.TimeoutDuration())
+	}
+
+	batches := rollout.Batches([]string{"a", "b", "c", "d", "e", "f"})
+	expected := []int{1, 3, 2} // 40% of 6 is rounded up
+	if len(batches) != len(expected) {
+		t.Fatalf("Expected %d batches, got: %v", len(expected), batches)
+	}
+	for i := range expected {
+		if len(batches[i]) != expected[i] {
+			t.Fatalf("Expected batch %d to have %d targets, got: %v", i+1, expected[i], batches)
+		}
+	}
+
+	// the rest at once after the canary
+	rollout.Batch = ""
+	batches = rollout.Batches([]string{"a", "b", "c"})
+	if len(batches) != 2 || len(batches[1]) != 2 {
+		t.Fatalf("Unexpected batches: %v", batches)
+	}
+
+	for _, invalid := range []Rollout{{Canary: "0"}, {Batch: "120%"}, {Soak: "soon"}, {MaxFailure: 101}} {
+		order.Deploy.Rollout = &invalid
+		if order.Validate() == nil {
+			t.Fatalf("Expected validation error for %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"code.linksmart.eu/dt/deployment-tool/manager/model"
+	"gopkg.in/yaml.v2"
+)
+
+func TestScheduleWindows(t *testing.T) {
+	var order Order
+	err := yaml.Unmarshal([]byte(`
+schedule:
+  start: 2019-06-01T00:00:00Z
+  windows:
+    - tags: [night]
+      start: "22:00"
+      end: "02:00"
+    - ids: [weekend]
+      days: [sat, sun]
+      start: "10:00"
+      end: "12:00"
+      timezone: Europe/Berlin
+deploy:
+  run:
+    commands:
+      - ./app
+  target:
+    tags:
+      - swarm
+`), &order)
+	if err != nil {
+		t.Fatalf("Error parsing order: %s", err)
+	}
+	err = order.Validate()
+	if err != nil {
+		t.Fatalf("Unexpected validation error: %s", err)
+	}
+
+	schedule := order.Schedule
+	if schedule.Due(time.Date(2019, 5, 31, 23, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected schedule not to be due before start")
+	}
+	if !schedule.Due(time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected schedule to be due at start")
+	}
+
+	night := &Target{TargetBase: model.TargetBase{ID: "a", Tags: []string{"night"}}}
+	weekend := &Target{TargetBase: model.TargetBase{ID: "weekend"}}
+	other := &Target{TargetBase: model.TargetBase{ID: "b", Tags: []string{"swarm"}}}
+
+	saturday := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		target *Target
+		time   time.Time
+		open   bool
+	}{
+		{night, saturday.Add(23 * time.Hour), true},
+		{night, saturday.Add(time.Hour), true}, // window opened the day before
+		{night, saturday.Add(12 * time.Hour), false},
+		{weekend, saturday.Add(9 * time.Hour), true},    // 11:00 in Berlin
+		{weekend, saturday.Add(11 * time.Hour), false},  // 13:00 in Berlin
+		{weekend, saturday.Add(-15 * time.Hour), false}, // Friday
+		{other, saturday.Add(12 * time.Hour), true},     // not covered by any window
+	}
+	for i, c := range cases {
+		if open := schedule.Open(c.target, c.time); open != c.open {
+			t.Fatalf("Case %d: expected open=%v for %s at %s", i, c.open, c.target.ID, c.time)
+		}
+	}
+
+	for _, invalid := range []Schedule{
+		{Start: "tomorrow"},
+		{Windows: []Window{{Start: "25:00", End: "01:00"}}},
+		{Windows: []Window{{Start: "01:00", End: "01:00"}}},
+		{Windows: []Window{{Start: "01:00", End: "02:00", Days: []string{"monday"}}}},
+		{Windows: []Window{{Start: "01:00", End: "02:00", Timezone: "Mars/Olympus"}}},
+	} {
+		order.Schedule = &invalid
+		if order.Validate() == nil {
+			t.Fatalf("Expected validation error for %+v", invalid)
+		}
+	}
+}
+
+// TestScheduleStorage checks that a schedule without pending targets or a stopped one is no longer returned
+func TestScheduleStorage(t *testing.T) {
+	s, _, stop := startFakeElastic(t)
+	defer stop()
+
+	order := Order{Schedule: &Schedule{Started: true, Pending: []string{"a", "b"}}}
+	order.ID = "scheduled"
+	_, err := s.AddOrder(&order)
+	if err != nil {
+		t.Fatalf("Error adding order: %s", err)
+	}
+
+	order.Schedule.Pending = []string{"b"}
+	_, err = s.UpdateOrderSchedule(order.ID, order.Schedule)
+	if err != nil {
+		t.Fatalf("Error updating schedule: %s", err)
+	}
+	orders, err := s.GetScheduledOrders()
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("Expected 1 scheduled order, got %d: %v", len(orders), err)
+	}
+	if len(orders[0].Schedule.Pending) != 1 || orders[0].Schedule.Pending[0] != "b" {
+		t.Fatalf("Expected pending [b], got %v", orders[0].Schedule.Pending)
+	}
+
+	order.Schedule.Pending = nil
+	_, err = s.UpdateOrderSchedule(order.ID, order.Schedule)
+	if err != nil {
+		t.Fatalf("Error updating schedule: %s", err)
+	}
+	orders, err = s.GetScheduledOrders()
+	if err != nil || len(orders) != 0 {
+		t.Fatalf("Expected no scheduled orders, got %d: %v", len(orders), err)
+	}
+
+	stopped := Order{Schedule: &Schedule{Start: "2019-01-01T02:00:00Z"}}
+	stopped.ID = "stopped"
+	_, err = s.AddOrder(&stopped)
+	if err != nil {
+		t.Fatalf("Error adding order: %s", err)
+	}
+	orders, err = s.GetScheduledOrders()
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("Expected 1 scheduled order, got %d: %v", len(orders), err)
+	}
+	stopped.Schedule.Stopped = true
+	_, err = s.UpdateOrderSchedule(stopped.ID, stopped.Schedule)
+	if err != nil {
+		t.Fatalf("Error updating schedule: %s", err)
+	}
+	orders, err = s.GetScheduledOrders()
+	if err != nil || len(orders) != 0 {
+		t.Fatalf("Expected no scheduled orders after stop, got %d: %v", len(orders), err)
+	}
+}
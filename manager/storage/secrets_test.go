@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestOrderSecretsValidation(t *testing.T) {
+	cases := map[string]bool{
+		"- {name: db, env: DB_PASSWORD}":      true,
+		"- {name: cert, file: conf/cert.pem}": true,
+		"- {name: db}":                        false,
+		"- {env: DB_PASSWORD}":                false,
+		"- {name: db, env: 'A=B'}":            false,
+		"- {name: cert, file: /etc/cert}":     false,
+		"- {name: cert, file: ../cert}":       false,
+		"- {name: cert, file: conf/../../x}":  false,
+		"- {name: cert, file: conf/..}":       false,
+		"- {name: cert, file: .}":             false,
+	}
+	for secrets, valid := range cases {
+		var order Order
+		err := yaml.Unmarshal([]byte("secrets:\n"+secrets+"\ndeploy:\n  run:\n    commands: [./app]\n  target:\n    tags: [swarm]\n"), &order)
+		if err != nil {
+			t.Fatalf("Error parsing order: %s", err)
+		}
+		err = order.Validate()
+		if valid && err != nil {
+			t.Errorf("Unexpected validation error for %s: %s", secrets, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("Expected validation error for %s", secrets)
+		}
+	}
+}
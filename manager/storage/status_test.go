@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestOrderStatus(t *testing.T) {
+	status := NewOrderStatus([]string{"a", "b"})
+	if status.State != StateQueued {
+		t.Fatalf("Expected %s, got %s", StateQueued, status.State)
+	}
+
+	steps := []struct {
+		target, state string
+		changed       bool
+		order         string // expected aggregated state
+	}{
+		{"a", StateSent, true, StateQueued},
+		{"b", StateSent, true, StateSent},
+		{"a", StateRunning, true, StateSent},
+		{"a", StateInstalling, false, StateSent}, // no going back
+		{"b", StateUndeliverable, true, StateRunning},
+		{"b", StateFailed, false, StateRunning},   // manager error after undeliverable
+		{"a", StateSucceeded, true, StateFailed},  // not all succeeded
+		{"a", StateFailed, false, ""},             // final
+		{"b", StateReceived, true, StateReceived}, // delivered late
+		{"b", StateSucceeded, true, StateSucceeded},
+		{"c", StateReceived, true, StateReceived}, // not matched initially
+	}
+	for i, step := range steps {
+		changed := status.Set(step.target, step.state)
+		if changed != step.changed {
+			t.Fatalf("Step %d: expected changed=%v for %s->%s", i, step.changed, step.target, step.state)
+		}
+		if step.order == "" {
+			continue
+		}
+		if status.State != step.order {
+			t.Fatalf("Step %d: expected order state %s, got %s", i, step.order, status.State)
+		}
+	}
+	if len(status.Targets) != 3 {
+		t.Fatalf("Expected 3 targets, got %d", len(status.Targets))
+	}
+}
+
+func TestOrderStatusRetriable(t *testing.T) {
+	status := NewOrderStatus([]string{"a", "b", "c", "d"})
+	status.Set("a", StateSucceeded)
+	status.Set("b", StateFailed)
+	status.Set("c", StateUndeliverable)
+	status.Set("d", StateRunning)
+
+	retriable := status.Retriable()
+	if len(retriable) != 2 || retriable[0] != "b" || retriable[1] != "c" {
+		t.Fatalf("Expected [b c], got %v", retriable)
+	}
+
+	// resent targets are no longer retriable
+	status.Reset("b", StateSent)
+	retriable = status.Retriable()
+	if len(retriable) != 1 || retriable[0] != "c" {
+		t.Fatalf("Expected [c], got %v", retriable)
+	}
+}
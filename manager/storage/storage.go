@@ -20,11 +20,15 @@ type Storage interface {
 	AddOrder(*Order) (dublicate bool, err error)
 	GetOrder(id string) (*Order, error)
 	DeleteOrder(id string) (found bool, err error)
+	UpdateOrderStatus(id string, status *OrderStatus) (found bool, err error)
+	UpdateOrderSchedule(id string, schedule *Schedule) (found bool, err error)
+	GetScheduledOrders() ([]Order, error)
 	//
 	GetTargets(tags []string, from, size int) ([]Target, int64, error)
 	GetTargetKeys() (map[string]string, error)
 	PatchTarget(id string, target *Target) (found bool, err error)
 	IndexTarget(target *Target) (found bool, err error) // add or update
+	UpdateTargetDeployments(id string, deployments []string) (found bool, err error)
 	MatchTargets(ids, tags []string) (allIDs, hitIDs, hitTags []string, err error)
 	AddTargetTrans(*Target) (conflict bool, trans *transaction, err error)
 	GetTarget(id string) (*Target, error)
@@ -42,6 +46,18 @@ type Storage interface {
 	DeleteTokens(name string) error
 	PurgeExpiredTokens() (total int64, err error)
 	//
+	GetSecrets() ([]SecretMeta, error)
+	GetSecret(name string) (*Secret, error)
+	IndexSecret(*Secret) error // add or update
+	DeleteSecret(name string) (found bool, err error)
+	//
+	GetPipelines(from, size int) ([]Pipeline, int64, error)
+	AddPipeline(*Pipeline) (duplicate bool, err error)
+	GetPipeline(id string) (*Pipeline, error)
+	UpdatePipeline(*Pipeline) (found bool, err error)
+	DeletePipeline(id string) (found bool, err error)
+	GetActivePipelines() ([]Pipeline, error)
+	//
 	DoBulk(...interface{}) error
 }
 
@@ -75,6 +91,7 @@ type mapping struct {
 type mappingProp struct {
 	Type       string                 `json:"type,omitempty"`
 	Properties map[string]mappingProp `json:"properties,omitempty"` // object datatype
+	Enabled    *bool                  `json:"enabled,omitempty"`    // false to store an object without indexing
 }
 
 const (
@@ -83,13 +100,20 @@ const (
 	indexOrder       = "order"
 	indexLog         = "log"
 	indexToken       = "token"
+	indexSecret      = "secret"
+	indexPipeline    = "pipeline"
 	typeFixed        = "_doc"
 	mappingStrict    = "strict"
 	propTypeKeyword  = "keyword"
 	propTypeDate     = "date"
 	propTypeText     = "text"
 	propTypeBool     = "boolean"
+	propTypeInteger  = "integer"
+	propTypeLong     = "long"
+	propTypeFloat    = "float"
 	propTypeGeoPoint = "geo_point"
+	propTypeBinary   = "binary"
+	propTypeObject   = "object"
 	opTypeCreate     = "create"
 )
 
@@ -140,13 +164,18 @@ func StartElasticStorage(url string) (Storage, error) {
 	m.Settings.RefreshInterval = "1s"
 	m.Mappings.Doc.Dynamic = mappingStrict
 	m.Mappings.Doc.Prop = map[string]mappingProp{
-		"id":           {Type: propTypeKeyword},
-		"tags":         {Type: propTypeKeyword}, // array
-		"location":     {Type: propTypeGeoPoint},
-		"publicKey":    {Type: propTypeKeyword},
-		"createdAt":    {Type: propTypeDate},
-		"updatedAt":    {Type: propTypeDate},
-		"logRequestAt": {Type: propTypeDate},
+		"id":               {Type: propTypeKeyword},
+		"tags":             {Type: propTypeKeyword}, // array
+		"location":         {Type: propTypeGeoPoint},
+		"publicKey":        {Type: propTypeKeyword},
+		"publicKeySwarmio": {Type: propTypeBinary},
+		"createdAt":        {Type: propTypeDate},
+		"updatedAt":        {Type: propTypeDate},
+		"logRequestAt":     {Type: propTypeDate},
+		"configPending":    {Type: propTypeBool},
+		"deployments":      {Type: propTypeKeyword}, // array
+		"memory":           {Type: propTypeLong},
+		"arch":             {Type: propTypeKeyword},
 	}
 	err = s.createIndex(indexTarget, m)
 	if err != nil {
@@ -163,15 +192,29 @@ func StartElasticStorage(url string) (Storage, error) {
 		"debug":       {Type: propTypeBool},
 		"description": {Type: propTypeText},
 		"createdAt":   {Type: propTypeDate},
+		"commit":      {Type: propTypeKeyword},
+		"archive":     {Type: propTypeKeyword},
+		"pull":        {Type: propTypeBool},
 		"build": {
 			Properties: map[string]mappingProp{
 				"commands":  {Type: propTypeKeyword}, // array
 				"artifacts": {Type: propTypeKeyword}, // array
 				"host":      {Type: propTypeKeyword},
+				"matrix": {
+					Properties: map[string]mappingProp{
+						"arch": {Type: propTypeKeyword},
+						"host": {Type: propTypeKeyword},
+					},
+				},
 			},
 		},
 		"deploy": {
 			Properties: map[string]mappingProp{
+				"preInstall": {
+					Properties: map[string]mappingProp{
+						"commands": {Type: propTypeKeyword}, // array
+					},
+				},
 				"install": {
 					Properties: map[string]mappingProp{
 						"commands": {Type: propTypeKeyword}, // array
@@ -183,6 +226,26 @@ func StartElasticStorage(url string) (Storage, error) {
 						"autoRestart": {Type: propTypeBool},
 					},
 				},
+				"postRun": {
+					Properties: map[string]mappingProp{
+						"commands": {Type: propTypeKeyword}, // array
+					},
+				},
+				"stop": {
+					Properties: map[string]mappingProp{
+						"commands": {Type: propTypeKeyword}, // array
+					},
+				},
+				"container": {
+					Properties: map[string]mappingProp{
+						"image":   {Type: propTypeKeyword},
+						"tag":     {Type: propTypeKeyword},
+						"env":     {Type: propTypeKeyword}, // array
+						"ports":   {Type: propTypeKeyword}, // array
+						"volumes": {Type: propTypeKeyword}, // array
+						"restart": {Type: propTypeKeyword},
+					},
+				},
 				"target": {
 					Properties: map[string]mappingProp{
 						"ids":  {Type: propTypeKeyword}, // array
@@ -196,6 +259,67 @@ func StartElasticStorage(url string) (Storage, error) {
 						"list": {Type: propTypeKeyword}, // array
 					},
 				},
+				"rollout": {
+					Properties: map[string]mappingProp{
+						"canary":     {Type: propTypeKeyword},
+						"batch":      {Type: propTypeKeyword},
+						"soak":       {Type: propTypeKeyword},
+						"timeout":    {Type: propTypeKeyword},
+						"maxFailure": {Type: propTypeFloat},
+					},
+				},
+			},
+		},
+		"secrets": {
+			Properties: map[string]mappingProp{ // array
+				"name": {Type: propTypeKeyword},
+				"env":  {Type: propTypeKeyword},
+				"file": {Type: propTypeKeyword},
+			},
+		},
+		"template": {
+			Properties: map[string]mappingProp{
+				"commands": {Type: propTypeBool},
+				"files":    {Type: propTypeKeyword}, // array
+			},
+		},
+		"schedule": {
+			Properties: map[string]mappingProp{
+				"start": {Type: propTypeDate},
+				"windows": { // array
+					Properties: map[string]mappingProp{
+						"ids":      {Type: propTypeKeyword}, // array
+						"tags":     {Type: propTypeKeyword}, // array
+						"days":     {Type: propTypeKeyword}, // array
+						"start":    {Type: propTypeKeyword},
+						"end":      {Type: propTypeKeyword},
+						"timezone": {Type: propTypeKeyword},
+					},
+				},
+				"started": {Type: propTypeBool},
+				"pending": {Type: propTypeKeyword}, // array
+				"stopped": {Type: propTypeBool},
+			},
+		},
+		"status": {
+			Properties: map[string]mappingProp{
+				"state":     {Type: propTypeKeyword},
+				"updatedAt": {Type: propTypeDate},
+				"targets": { // array
+					Properties: map[string]mappingProp{
+						"id":        {Type: propTypeKeyword},
+						"state":     {Type: propTypeKeyword},
+						"updatedAt": {Type: propTypeDate},
+					},
+				},
+				"rollout": {
+					Properties: map[string]mappingProp{
+						"batch":   {Type: propTypeInteger},
+						"batches": {Type: propTypeInteger},
+						"halted":  {Type: propTypeBool},
+						"message": {Type: propTypeText},
+					},
+				},
 			},
 		},
 	}
@@ -238,16 +362,67 @@ func StartElasticStorage(url string) (Storage, error) {
 		return nil, err
 	}
 
+	m = mapping{}
+	m.Settings.Shards = 1
+	m.Settings.Replicas = 0
+	m.Settings.RefreshInterval = "1s"
+	m.Mappings.Doc.Dynamic = mappingStrict
+	m.Mappings.Doc.Prop = map[string]mappingProp{
+		"name":      {Type: propTypeKeyword},
+		"sealed":    {Type: propTypeBinary},
+		"createdAt": {Type: propTypeDate},
+		"updatedAt": {Type: propTypeDate},
+	}
+	err = s.createIndex(indexSecret, m)
+	if err != nil {
+		return nil, err
+	}
+
+	disabled := false
+	m = mapping{}
+	m.Settings.Shards = 1
+	m.Settings.Replicas = 0
+	m.Settings.RefreshInterval = "1s"
+	m.Mappings.Doc.Dynamic = mappingStrict
+	m.Mappings.Doc.Prop = map[string]mappingProp{
+		"id":          {Type: propTypeKeyword},
+		"description": {Type: propTypeText},
+		"createdAt":   {Type: propTypeDate},
+		"state":       {Type: propTypeKeyword},
+		"updatedAt":   {Type: propTypeDate},
+		"steps": {
+			Properties: map[string]mappingProp{ // array
+				"name":      {Type: propTypeKeyword},
+				"dependsOn": {Type: propTypeKeyword}, // array
+				"order":     {Type: propTypeObject, Enabled: &disabled},
+				"orderID":   {Type: propTypeKeyword},
+				"state":     {Type: propTypeKeyword},
+				"message":   {Type: propTypeText},
+			},
+		},
+	}
+	err = s.createIndex(indexPipeline, m)
+	if err != nil {
+		return nil, err
+	}
+
 	return &s, nil
 }
 
+// createIndex creates the index if missing, otherwise adds new fields of the mapping to the existing index
 func (s *storage) createIndex(index string, mapping mapping) error {
 	// Use the IndexExists service to check if a specified index exists.
 	exists, err := s.client.IndexExists(index).Do(s.ctx)
 	if err != nil {
 		return fmt.Errorf("error checking index: %s", err)
 	}
-	if !exists {
+	if exists {
+		_, err := s.client.PutMapping().Index(index).Type(typeFixed).
+			BodyJson(map[string]interface{}{"properties": mapping.Mappings.Doc.Prop}).Do(s.ctx)
+		if err != nil {
+			return fmt.Errorf("error updating mapping of index %s: %s", index, err)
+		}
+	} else {
 		// Create a new index.
 		createIndex, err := s.client.CreateIndex(index).
 			BodyJson(mapping).Do(s.ctx)
@@ -297,6 +472,22 @@ func (s *storage) PatchTarget(id string, target *Target) (found bool, err error)
 	return true, nil
 }
 
+// UpdateTargetDeployments replaces the deployments of the target, returns false if target is not found
+//	Unlike PatchTarget, an empty list is stored too.
+func (s *storage) UpdateTargetDeployments(id string, deployments []string) (found bool, err error) {
+	res, err := s.client.Update().Index(indexTarget).Type(typeFixed).Id(id).
+		Doc(map[string]interface{}{"deployments": deployments}).Do(s.ctx)
+	if err != nil {
+		e := err.(*elastic.Error)
+		if e.Status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	log.Printf("Updated deployments of %s/%s v%d", res.Index, res.Id, res.Version)
+	return true, nil
+}
+
 // IndexTarget adds or updates the target
 func (s *storage) IndexTarget(target *Target) (found bool, err error) {
 	res, err := s.client.Index().Index(indexTarget).Type(typeFixed).
@@ -550,6 +741,60 @@ func (s *storage) DeleteOrder(id string) (found bool, err error) {
 	return true, nil
 }
 
+// UpdateOrderStatus replaces the status of the order, returns false if order is not found
+func (s *storage) UpdateOrderStatus(id string, status *OrderStatus) (found bool, err error) {
+	res, err := s.client.Update().Index(indexOrder).Type(typeFixed).Id(id).
+		Doc(map[string]interface{}{"status": status}).Do(s.ctx)
+	if err != nil {
+		e := err.(*elastic.Error)
+		if e.Status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	log.Printf("Updated status of %s/%s v%d", res.Index, res.Id, res.Version)
+	return true, nil
+}
+
+// UpdateOrderSchedule replaces the schedule of the order, returns false if order is not found
+func (s *storage) UpdateOrderSchedule(id string, schedule *Schedule) (found bool, err error) {
+	res, err := s.client.Update().Index(indexOrder).Type(typeFixed).Id(id).
+		Doc(map[string]interface{}{"schedule": schedule}).Do(s.ctx)
+	if err != nil {
+		e := err.(*elastic.Error)
+		if e.Status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	log.Printf("Updated schedule of %s/%s v%d", res.Index, res.Id, res.Version)
+	return true, nil
+}
+
+// GetScheduledOrders returns orders which are not started or have targets waiting for a maintenance window, unless stopped
+func (s *storage) GetScheduledOrders() (orders []Order, err error) {
+	query := elastic.NewBoolQuery().
+		Should(elastic.NewTermQuery("schedule.started", false)).
+		Should(elastic.NewExistsQuery("schedule.pending")).
+		MustNot(elastic.NewTermQuery("schedule.stopped", true))
+
+	// TODO paginate or use the scroll service
+	searchResult, err := s.client.Search().Index(indexOrder).Type(typeFixed).
+		Query(query).Sort("createdAt", true).Size(1000).Do(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orders = make([]Order, len(searchResult.Hits.Hits))
+	for i, hit := range searchResult.Hits.Hits {
+		err := json.Unmarshal(*hit.Source, &orders[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
 func (s *storage) GetLogs(target, task, stage, command, output, error, sortField string, sortAsc bool, from, size int) (logs []Log, total int64, err error) {
 	query := elastic.NewBoolQuery()
 	if target != "" {
@@ -760,6 +1005,173 @@ func (s *storage) PurgeExpiredTokens() (int64, error) {
 	return res.Total, nil
 }
 
+// GetSecrets returns the names and timestamps of all secrets
+func (s *storage) GetSecrets() ([]SecretMeta, error) {
+	fetch := elastic.NewFetchSourceContext(true).Include("name", "createdAt", "updatedAt")
+
+	searchResult, err := s.client.Search().Index(indexSecret).Type(typeFixed).FetchSourceContext(fetch).
+		Query(elastic.NewMatchAllQuery()).Size(1000).Sort("name", true).Do(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]SecretMeta, len(searchResult.Hits.Hits))
+	for i, hit := range searchResult.Hits.Hits {
+		err := json.Unmarshal(*hit.Source, &secrets[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return secrets, nil
+}
+
+func (s *storage) GetSecret(name string) (*Secret, error) {
+	res, err := s.client.Get().Index(indexSecret).Type(typeFixed).
+		Id(name).Do(s.ctx)
+	if err != nil {
+		e := err.(*elastic.Error)
+		if e.Status == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var secret Secret
+	err = json.Unmarshal(*res.Source, &secret)
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// IndexSecret adds or updates the secret
+func (s *storage) IndexSecret(secret *Secret) error {
+	res, err := s.client.Index().Index(indexSecret).Type(typeFixed).
+		Id(secret.Name).BodyJson(secret).Do(s.ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Indexed %s/%s v%d", res.Index, res.Id, res.Version)
+	return nil
+}
+
+func (s *storage) DeleteSecret(name string) (found bool, err error) {
+	res, err := s.client.Delete().Index(indexSecret).Type(typeFixed).
+		Id(name).Do(s.ctx)
+	if err != nil {
+		e := err.(*elastic.Error)
+		if e.Status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	log.Printf("Deleted %s/%s v%d", res.Index, res.Id, res.Version)
+	return true, nil
+}
+
+func (s *storage) GetPipelines(from, size int) (pipelines []Pipeline, total int64, err error) {
+	searchResult, err := s.client.Search().Index(indexPipeline).Type(typeFixed).
+		Query(elastic.NewMatchAllQuery()).Sort("createdAt", false).From(from).Size(size).Do(s.ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipelines = make([]Pipeline, len(searchResult.Hits.Hits))
+	for i, hit := range searchResult.Hits.Hits {
+		err := json.Unmarshal(*hit.Source, &pipelines[i])
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return pipelines, searchResult.Hits.TotalHits, nil
+}
+
+func (s *storage) AddPipeline(pipeline *Pipeline) (duplicate bool, err error) {
+	res, err := s.client.Index().Index(indexPipeline).Type(typeFixed).
+		Id(pipeline.ID).BodyJson(pipeline).OpType(opTypeCreate).Do(s.ctx)
+	if err != nil {
+		e := err.(*elastic.Error)
+		if e.Status == http.StatusConflict {
+			return true, nil
+		}
+		return false, err
+	}
+	log.Printf("Indexed %s/%s v%d", res.Index, res.Id, res.Version)
+	return false, nil
+}
+
+func (s *storage) GetPipeline(id string) (*Pipeline, error) {
+	res, err := s.client.Get().Index(indexPipeline).Type(typeFixed).
+		Id(id).Do(s.ctx)
+	if err != nil {
+		e := err.(*elastic.Error)
+		if e.Status == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var pipeline Pipeline
+	err = json.Unmarshal(*res.Source, &pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeline, nil
+}
+
+// UpdatePipeline replaces the pipeline, returns false if pipeline is not found
+func (s *storage) UpdatePipeline(pipeline *Pipeline) (found bool, err error) {
+	existing, err := s.GetPipeline(pipeline.ID)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		return false, nil
+	}
+	res, err := s.client.Index().Index(indexPipeline).Type(typeFixed).
+		Id(pipeline.ID).BodyJson(pipeline).Do(s.ctx)
+	if err != nil {
+		return false, err
+	}
+	log.Printf("Updated %s/%s v%d", res.Index, res.Id, res.Version)
+	return true, nil
+}
+
+func (s *storage) DeletePipeline(id string) (found bool, err error) {
+	res, err := s.client.Delete().Index(indexPipeline).Type(typeFixed).
+		Id(id).Do(s.ctx)
+	if err != nil {
+		e := err.(*elastic.Error)
+		if e.Status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	log.Printf("Deleted %s/%s v%d", res.Index, res.Id, res.Version)
+	return true, nil
+}
+
+// GetActivePipelines returns pipelines which are running
+func (s *storage) GetActivePipelines() (pipelines []Pipeline, err error) {
+	query := elastic.NewTermQuery("state", StateRunning)
+
+	// TODO paginate or use the scroll service
+	searchResult, err := s.client.Search().Index(indexPipeline).Type(typeFixed).
+		Query(query).Size(1000).Do(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelines = make([]Pipeline, len(searchResult.Hits.Hits))
+	for i, hit := range searchResult.Hits.Hits {
+		err := json.Unmarshal(*hit.Source, &pipelines[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pipelines, nil
+}
+
 // DoBulk performs elastic.BulkableRequests
 func (s *storage) DoBulk(requests ...interface{}) error {
 	bulk := s.client.Bulk()
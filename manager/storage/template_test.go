@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestOrderTemplateValidation(t *testing.T) {
+	cases := map[string]bool{
+		"config.yml":         true,
+		"conf/app.yml":       true,
+		"./conf/app.yml":     true,
+		"conf/../app.yml":    true,
+		"conf//app.yml":      true,
+		"..app.yml":          true,
+		"/etc/app.yml":       false,
+		"../app.yml":         false,
+		"..":                 false,
+		".":                  false,
+		"./":                 false,
+		"conf/..":            false,
+		"conf/../../app.yml": false,
+		"./../app.yml":       false,
+		"conf/./../../x.yml": false,
+		"//etc/app.yml":      false,
+		"''":                 false,
+	}
+	for path, valid := range cases {
+		var order Order
+		err := yaml.Unmarshal([]byte("template:\n  files: ["+path+"]\ndeploy:\n  run:\n    commands: [./app]\n  target:\n    tags: [swarm]\n"), &order)
+		if err != nil {
+			t.Fatalf("Error parsing order: %s", err)
+		}
+		err = order.Validate()
+		if valid && err != nil {
+			t.Errorf("Unexpected validation error for %s: %s", path, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("Expected validation error for %s", path)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package swarmio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of requests signed by registered targets
+const (
+	HeaderTarget    = "X-Swarmio-Target"
+	HeaderKey       = "X-Swarmio-Key"
+	HeaderCert      = "X-Swarmio-Cert" // signature of the key by the manager
+	HeaderTime      = "X-Swarmio-Time"
+	HeaderSignature = "X-Swarmio-Signature"
+	// RequestMaxAge is the tolerated age of signed requests, including clock skew
+	RequestMaxAge = 5 * time.Minute
+)
+
+// SignRequest authenticates the request of the target with its certificate
+func SignRequest(req *http.Request, targetID string, cert Cert) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := Sign(cert.PrivateKey, requestMessage(req, targetID, timestamp))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderTarget, targetID)
+	req.Header.Set(HeaderKey, base64.StdEncoding.EncodeToString(cert.PublicKey))
+	req.Header.Set(HeaderCert, base64.StdEncoding.EncodeToString(cert.Signature))
+	req.Header.Set(HeaderTime, timestamp)
+	req.Header.Set(HeaderSignature, base64.StdEncoding.EncodeToString(signature))
+	return nil
+}
+
+// VerifyRequest checks that the request is signed with a key certified by the CA for the target and returns the target id
+//	Keys certified before being bound to target ids are accepted only if they match the registered key of the target.
+func VerifyRequest(r *http.Request, ca, registeredKey []byte) (targetID string, err error) {
+	targetID = r.Header.Get(HeaderTarget)
+	if targetID == "" {
+		return "", fmt.Errorf("request is not signed")
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderKey))
+	if err != nil {
+		return "", fmt.Errorf("error decoding key: %s", err)
+	}
+	keySignature, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderCert))
+	if err != nil {
+		return "", fmt.Errorf("error decoding certificate: %s", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderSignature))
+	if err != nil {
+		return "", fmt.Errorf("error decoding signature: %s", err)
+	}
+	timestamp := r.Header.Get(HeaderTime)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("error parsing time: %s", err)
+	}
+
+	if age := time.Since(time.Unix(unix, 0)); age > RequestMaxAge || age < -RequestMaxAge {
+		return "", fmt.Errorf("request has expired")
+	}
+	if !Verify(ca, keyMessage(targetID, publicKey), keySignature) &&
+		!(len(registeredKey) > 0 && bytes.Equal(publicKey, registeredKey) && Verify(ca, publicKey, keySignature)) {
+		return "", fmt.Errorf("key is not certified for target %s", targetID)
+	}
+	if !Verify(publicKey, requestMessage(r, targetID, timestamp), signature) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	return targetID, nil
+}
+
+func requestMessage(r *http.Request, targetID, timestamp string) []byte {
+	return []byte(strings.Join([]string{r.Method, r.URL.RequestURI(), targetID, timestamp}, "\n"))
+}
@@ -0,0 +1,94 @@
+package swarmio
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignedRequest(t *testing.T) {
+	caPublic, caPrivate, _ := ed25519.GenerateKey(nil)
+	public, private, _ := ed25519.GenerateKey(nil)
+	signature, _ := CertifyKey(caPrivate, "target-1", public)
+	cert := Cert{PublicKey: public, PrivateKey: private, Signature: signature, CA: caPublic}
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://manager:8080/orders/abc/artifacts/0a1b", nil)
+		err := SignRequest(req, "target-1", cert)
+		if err != nil {
+			t.Fatalf("Error signing request: %s", err)
+		}
+		return req
+	}
+
+	id, err := VerifyRequest(newRequest(), caPublic, nil)
+	if err != nil {
+		t.Fatalf("Valid request is not verified: %s", err)
+	}
+	if id != "target-1" {
+		t.Fatalf("Expected target-1, got %s", id)
+	}
+
+	otherCA, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyRequest(newRequest(), otherCA, nil); err == nil {
+		t.Fatalf("Request is verified with key of another manager")
+	}
+
+	req := newRequest()
+	req.URL.Path = "/orders/other/artifacts/0a1b"
+	if _, err := VerifyRequest(req, caPublic, nil); err == nil {
+		t.Fatalf("Request is verified for another path")
+	}
+
+	req = newRequest()
+	req.Header.Set(HeaderTarget, "target-2")
+	if _, err := VerifyRequest(req, caPublic, nil); err == nil {
+		t.Fatalf("Request is verified for another target")
+	}
+
+	// the key is certified only for target-1
+	req, _ = http.NewRequest(http.MethodGet, "http://manager:8080/orders/abc/artifacts/0a1b", nil)
+	err = SignRequest(req, "target-2", cert)
+	if err != nil {
+		t.Fatalf("Error signing request: %s", err)
+	}
+	if _, err := VerifyRequest(req, caPublic, nil); err == nil {
+		t.Fatalf("Request is verified for a target with the key of another target")
+	}
+
+	// keys certified before binding to target ids are accepted only as registered key of the target
+	legacySignature, _ := Sign(caPrivate, public)
+	legacy := Cert{PublicKey: public, PrivateKey: private, Signature: legacySignature, CA: caPublic}
+	req, _ = http.NewRequest(http.MethodGet, "http://manager:8080/orders/abc/artifacts/0a1b", nil)
+	err = SignRequest(req, "target-1", legacy)
+	if err != nil {
+		t.Fatalf("Error signing request: %s", err)
+	}
+	if _, err := VerifyRequest(req, caPublic, nil); err == nil {
+		t.Fatalf("Request with legacy certificate is verified without registered key")
+	}
+	otherPublic, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyRequest(req, caPublic, otherPublic); err == nil {
+		t.Fatalf("Request with legacy certificate is verified with another registered key")
+	}
+	if _, err := VerifyRequest(req, caPublic, public); err != nil {
+		t.Fatalf("Request with legacy certificate of registered key is not verified: %s", err)
+	}
+
+	if _, err := CertifyKey(caPrivate, "", public); err == nil {
+		t.Fatalf("Key is certified without target id")
+	}
+
+	req = newRequest()
+	req.Header.Set(HeaderTime, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	if _, err := VerifyRequest(req, caPublic, nil); err == nil {
+		t.Fatalf("Expired request is verified")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://manager:8080/orders/abc/artifacts/0a1b", nil)
+	if _, err := VerifyRequest(req, caPublic, nil); err == nil {
+		t.Fatalf("Unsigned request is verified")
+	}
+}
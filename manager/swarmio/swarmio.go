@@ -2,6 +2,7 @@ package swarmio
 
 import (
 	"crypto/ed25519"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -87,14 +88,47 @@ func LoadCert() (Cert, error) {
 	return DecodeCert(b)
 }
 
-func SignPublicKey(publicKey []byte) (*Cert, error) {
+// Sign signs the message, e.g. the digest of task artifacts
+func Sign(privateKey, message []byte) ([]byte, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: %d", len(privateKey))
+	}
+	return ed25519.Sign(privateKey, message), nil
+}
+
+// Verify reports whether the signature of message is valid
+func Verify(publicKey, message, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(publicKey, message, signature)
+}
+
+// SignPublicKey certifies the key of the target with the CA key
+func SignPublicKey(targetID string, publicKey []byte) (*Cert, error) {
 	cert, err := LoadCert()
 	if err != nil {
 		return nil, err
 	}
 
+	signature, err := CertifyKey(cert.PrivateKey, targetID, publicKey)
+	if err != nil {
+		return nil, err
+	}
 	return &Cert{
-		Signature: ed25519.Sign(cert.PrivateKey, publicKey),
+		Signature: signature,
 		CA:        cert.PublicKey,
 	}, nil
 }
+
+// CertifyKey signs the key together with the target id, so that the key is only accepted for that target
+func CertifyKey(caPrivateKey []byte, targetID string, publicKey []byte) ([]byte, error) {
+	if targetID == "" {
+		return nil, fmt.Errorf("target id not given")
+	}
+	return Sign(caPrivateKey, keyMessage(targetID, publicKey))
+}
+
+func keyMessage(targetID string, publicKey []byte) []byte {
+	return append([]byte(targetID+"\n"), publicKey...)
+}
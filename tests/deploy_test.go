@@ -134,6 +134,10 @@ func TestDeploy(t *testing.T) {
 		checkFiles(t, orderID)
 	})
 
+	t.Run("check order status", func(t *testing.T) {
+		checkStatus(t, orderID)
+	})
+
 	t.Log("TEAR DOWN:")
 	for i := len(tearDownFuncs) - 1; i >= 0; i-- {
 		tearDownFuncs[i](t)
@@ -324,6 +328,40 @@ func checkLogs(t *testing.T, orderID string) {
 	}
 }
 
+func checkStatus(t *testing.T, orderID string) {
+	t.Log("Getting order status.")
+	resp, err := http.Get(managerExposedEndpoint + "/orders/" + orderID)
+	if err != nil {
+		t.Fatal("Error getting order:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected status 200, but got", resp.StatusCode)
+	}
+
+	var order struct {
+		Status struct {
+			State   string `json:"state"`
+			Targets []struct {
+				ID    string `json:"id"`
+				State string `json:"state"`
+			} `json:"targets"`
+		} `json:"status"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&order)
+	if err != nil {
+		t.Fatal("Error decoding response:", err)
+	}
+
+	if order.Status.State != "succeeded" {
+		t.Fatalf("Expected order state succeeded, but got %s:\n%s", order.Status.State, spew.Sdump(order))
+	}
+	if len(order.Status.Targets) != 1 || order.Status.Targets[0].State != "succeeded" {
+		t.Fatalf("Expected one succeeded target:\n%s", spew.Sdump(order))
+	}
+}
+
 func checkFiles(t *testing.T, orderID string) {
 	md5sum := func(filepath string) string {
 		f, err := os.Open(filepath)